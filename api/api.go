@@ -0,0 +1,475 @@
+// Package api implements a JSON REST API for managing shortened urls.
+//
+// Wrap the handler with `RequireApiKey` to require api keys and enforce their scopes.
+//
+// Routes:
+//
+//	GET    /openapi.json            the OpenAPI 3 document of the API
+//	POST   /links                   create a shortened url
+//	GET    /links                   list shortened urls (query: limit, cursor)
+//	POST   /links/batch             create multiple shortened urls
+//	GET    /links/{id}              get a shortened url
+//	PATCH  /links/{id}              update the original url of a shortened url
+//	DELETE /links/{id}              delete a shortened url
+//	PUT    /links/{id}/expiration   extend, shorten or remove the expiration date of a shortened url
+//	GET    /links/{id}/stats        get the click statistics of a shortened url
+//	GET    /links/{id}/timeseries   get the clicks of a shortened url (query: from, to, granularity)
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TomerHeber/go-short-url"
+)
+
+//go:embed openapi.json
+var openApiDocument []byte
+
+const (
+	defaultMaxBatchSize = 100
+	maxRequestBodySize  = 1 << 20
+)
+
+// Options may be used to customize the handler returned by `NewHandler`.
+type Options struct {
+	// MaxBatchSize is the maximum number of links in a `POST /links/batch` request (default 100).
+	MaxBatchSize int
+
+	// CreateRateLimiter limits the creation of shortened urls per api key (see `RequireApiKey`),
+	// or per client ip for requests without an api key. A batch takes a token per link.
+	// Requests over the limit are rejected with `429 Too Many Requests`.
+	CreateRateLimiter short.RateLimiter
+
+	// TrustProxyHeaders reads the client ip from the `X-Forwarded-For` and `X-Real-IP` headers.
+	// The client ip is the last `X-Forwarded-For` address, which was added by the proxy (the other addresses are set by the client).
+	// Enable it only when the handler is behind a single reverse proxy that sets these headers.
+	TrustProxyHeaders bool
+}
+
+type handler struct {
+	shortener short.Shortener
+	options   Options
+}
+
+// NewHandler returns an http.Handler that serves the management API.
+// To serve the API under a path prefix use `http.StripPrefix`.
+func NewHandler(s short.Shortener, options Options) http.Handler {
+	if options.MaxBatchSize <= 0 {
+		options.MaxBatchSize = defaultMaxBatchSize
+	}
+
+	return &handler{
+		shortener: s,
+		options:   options,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(path.Clean("/"+r.URL.Path), "/"), "/")
+
+	// The `domain` query parameter selects a domain of the shortener (see `short.ContextWithDomain`).
+	if domain := r.URL.Query().Get("domain"); domain != "" {
+		r = r.WithContext(short.ContextWithDomain(r.Context(), domain))
+	}
+
+	var allowed []string
+	for _, rt := range routes {
+		id, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		if hf, ok := rt.methods[r.Method]; ok {
+			hf(h, w, r, id)
+			return
+		}
+		for method := range rt.methods {
+			allowed = append(allowed, method)
+		}
+	}
+
+	if len(allowed) == 0 {
+		writeError(w, http.StatusNotFound, &Error{Code: CodeNotFound, Message: fmt.Sprintf("route %s not found", r.URL.Path)})
+		return
+	}
+
+	sort.Strings(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, &Error{Code: CodeMethodNotAllowed, Message: fmt.Sprintf("method %s not allowed", r.Method)})
+}
+
+// routeHandlerFunc handles a request of a route (`id` is the link id of routes with an `{id}` segment).
+type routeHandlerFunc func(h *handler, w http.ResponseWriter, r *http.Request, id string)
+
+// withoutId adapts a handler of a route without an `{id}` segment.
+func withoutId(hf func(h *handler, w http.ResponseWriter, r *http.Request)) routeHandlerFunc {
+	return func(h *handler, w http.ResponseWriter, r *http.Request, _ string) { hf(h, w, r) }
+}
+
+type route struct {
+	path    string
+	methods map[string]routeHandlerFunc
+}
+
+// routes are the routes of the API (the paths of openapi.json).
+// The routes are matched in order, and a route that does not handle the request method
+// does not hide the next routes that match the path (e.g. `GET /links/batch` gets the link "batch").
+var routes = []route{
+	{"/openapi.json", map[string]routeHandlerFunc{http.MethodGet: withoutId((*handler).openApi)}},
+	{"/links", map[string]routeHandlerFunc{http.MethodGet: withoutId((*handler).list), http.MethodPost: withoutId((*handler).create)}},
+	{"/links/batch", map[string]routeHandlerFunc{http.MethodPost: withoutId((*handler).batchCreate)}},
+	{"/links/{id}", map[string]routeHandlerFunc{http.MethodGet: (*handler).get, http.MethodPatch: (*handler).update, http.MethodDelete: (*handler).delete}},
+	{"/links/{id}/expiration", map[string]routeHandlerFunc{http.MethodPut: (*handler).setExpiration}},
+	{"/links/{id}/stats", map[string]routeHandlerFunc{http.MethodGet: (*handler).stats}},
+	{"/links/{id}/timeseries", map[string]routeHandlerFunc{http.MethodGet: (*handler).timeSeries}},
+}
+
+// match returns the link id if the path `segments` match the route.
+func (rt route) match(segments []string) (string, bool) {
+	pattern := strings.Split(strings.Trim(rt.path, "/"), "/")
+	if len(pattern) != len(segments) {
+		return "", false
+	}
+
+	id := ""
+	for i, segment := range pattern {
+		switch {
+		case segment == "{id}":
+			id = segments[i]
+		case segment != segments[i]:
+			return "", false
+		}
+	}
+
+	return id, true
+}
+
+func (h *handler) openApi(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openApiDocument)
+}
+
+func (h *handler) create(w http.ResponseWriter, r *http.Request) {
+	key, ok := authorize(w, r, short.ScopeCreate)
+	if !ok {
+		return
+	}
+
+	var req CreateLinkRequest
+	if !readJson(w, r, &req) {
+		return
+	}
+
+	if err := h.rateLimitCreate(r, key, 1); err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	link, err := h.createLink(r, key, &req)
+	if err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusCreated, link)
+}
+
+// rateLimitCreate takes `n` tokens from the create rate limiter bucket of the api key `key` (or of the client ip if key is nil).
+func (h *handler) rateLimitCreate(r *http.Request, key *short.ApiKey, n int) error {
+	if h.options.CreateRateLimiter == nil {
+		return nil
+	}
+
+	bucket := "ip:" + short.NewRequestMetadata(r, h.options.TrustProxyHeaders).ClientIP
+	if key != nil {
+		bucket = "key:" + key.Id
+	}
+
+	allowed, retryAfter, err := h.options.CreateRateLimiter.Allow(r.Context(), bucket, n)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &short.RateLimitError{RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// createLink creates a shortened url owned by `key` (may be nil).
+func (h *handler) createLink(r *http.Request, key *short.ApiKey, req *CreateLinkRequest) (*Link, error) {
+	if req.Domain != "" {
+		r = r.WithContext(short.ContextWithDomain(r.Context(), req.Domain))
+	}
+
+	config := req.urlConfig()
+
+	if key != nil {
+		config = config.WithOwner(key.Id)
+
+		// A key must not take over an alias owned by another key.
+		// The store checks the owner again when it overrides, so a concurrent change of owner is not taken over either.
+		if req.Alias != "" && req.Override && !key.HasScope(short.ScopeAdmin) {
+			info, err := h.shortener.GetShortenedUrlInfo(r.Context(), req.Alias)
+			if err != nil && !errors.Is(err, &short.IdNotFoundError{}) {
+				return nil, err
+			}
+			if err == nil && !key.CanAccess(info) {
+				return nil, &short.ConflictError{Id: req.Alias}
+			}
+			config = config.WithOverrideOwnedOnly(true)
+		}
+	}
+
+	surl, err := h.shortener.CreateShortenedUrl(r.Context(), req.Url, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.getLink(r, path.Base(surl.GetUrl()))
+}
+
+func (h *handler) getLink(r *http.Request, id string) (*Link, error) {
+	info, err := h.shortener.GetShortenedUrlInfo(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLink(info), nil
+}
+
+func (h *handler) batchCreate(w http.ResponseWriter, r *http.Request) {
+	key, ok := authorize(w, r, short.ScopeCreate)
+	if !ok {
+		return
+	}
+
+	var req BatchCreateRequest
+	if !readJson(w, r, &req) {
+		return
+	}
+
+	if len(req.Links) == 0 || len(req.Links) > h.options.MaxBatchSize {
+		writeError(w, http.StatusBadRequest, &Error{
+			Code:    CodeInvalidArgument,
+			Message: fmt.Sprintf("the number of links must be between 1 and %d", h.options.MaxBatchSize),
+		})
+		return
+	}
+
+	if err := h.rateLimitCreate(r, key, len(req.Links)); err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	res := BatchCreateResponse{Results: make([]BatchCreateResult, len(req.Links))}
+
+	for i := range req.Links {
+		link, err := h.createLink(r, key, &req.Links[i])
+		if err != nil {
+			_, res.Results[i].Error = newError(err)
+		} else {
+			res.Results[i].Link = link
+		}
+	}
+
+	writeJson(w, http.StatusOK, &res)
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	key, ok := authorize(w, r, short.ScopeCreate, short.ScopeReadStats)
+	if !ok {
+		return
+	}
+
+	var options short.ListOptions
+	if key != nil && !key.HasScope(short.ScopeAdmin) {
+		options.Owner = key.Id
+	}
+
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		var err error
+		if options.Limit, err = strconv.Atoi(limit); err != nil {
+			writeError(w, http.StatusBadRequest, &Error{Code: CodeInvalidArgument, Message: fmt.Sprintf("invalid limit %s", limit)})
+			return
+		}
+	}
+	options.Cursor = r.URL.Query().Get("cursor")
+
+	result, err := h.shortener.ListShortenedUrls(r.Context(), options)
+	if err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	res := ListLinksResponse{Links: make([]*Link, len(result.ShortenedUrls)), NextCursor: result.NextCursor}
+	for i, info := range result.ShortenedUrls {
+		res.Links[i] = newLink(info)
+	}
+
+	writeJson(w, http.StatusOK, &res)
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.authorizeLink(w, r, id, short.ScopeCreate, short.ScopeReadStats) {
+		return
+	}
+
+	link, err := h.getLink(r, id)
+	if err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, link)
+}
+
+func (h *handler) update(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.authorizeLink(w, r, id, short.ScopeCreate) {
+		return
+	}
+
+	var req UpdateLinkRequest
+	if !readJson(w, r, &req) {
+		return
+	}
+
+	if err := h.shortener.UpdateDestination(r.Context(), id, req.Url); err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	link, err := h.getLink(r, id)
+	if err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, link)
+}
+
+func (h *handler) setExpiration(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.authorizeLink(w, r, id, short.ScopeCreate) {
+		return
+	}
+
+	var req SetExpirationRequest
+	if !readJson(w, r, &req) {
+		return
+	}
+
+	if err := h.shortener.SetExpiration(r.Context(), id, req.ExpirationDate); err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	link, err := h.getLink(r, id)
+	if err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, link)
+}
+
+func (h *handler) delete(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.authorizeLink(w, r, id, short.ScopeCreate) {
+		return
+	}
+
+	if err := h.shortener.DeleteShortenedUrl(r.Context(), id); err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) stats(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.authorizeLink(w, r, id, short.ScopeReadStats) {
+		return
+	}
+
+	stats, err := h.shortener.GetStats(r.Context(), id)
+	if err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, newStats(stats))
+}
+
+func (h *handler) timeSeries(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.authorizeLink(w, r, id, short.ScopeReadStats) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -7)
+	granularity := short.GranularityDay
+
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if value := query.Get(name); value != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				writeError(w, http.StatusBadRequest, &Error{Code: CodeInvalidArgument, Message: fmt.Sprintf("invalid %s %s (must be RFC 3339)", name, value)})
+				return
+			}
+		}
+	}
+
+	if value := query.Get("granularity"); value != "" {
+		granularity = short.Granularity(value)
+	}
+
+	ts, err := h.shortener.GetTimeSeries(r.Context(), id, from, to, granularity)
+	if err != nil {
+		writeShortenerError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, newTimeSeries(ts))
+}
+
+// readJson decodes the request body into `v`. On failure an error response is written and false is returned.
+func readJson(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, &Error{Code: CodeInvalidArgument, Message: fmt.Sprintf("invalid request body: %s", err)})
+		return false
+	}
+
+	return true
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, e *Error) {
+	writeJson(w, statusCode, &ErrorResponse{Error: *e})
+}
+
+func writeShortenerError(w http.ResponseWriter, err error) {
+	var rateLimitErr *short.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		w.Header().Set("Retry-After", short.RetryAfterSeconds(rateLimitErr.RetryAfter))
+	}
+
+	statusCode, e := newError(err)
+	writeError(w, statusCode, e)
+}
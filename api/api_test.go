@@ -0,0 +1,430 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TomerHeber/go-short-url"
+	"github.com/stretchr/testify/require"
+)
+
+type shortenedUrl string
+
+func (s shortenedUrl) GetUrl() string {
+	return string(s)
+}
+
+// memoryShortener is an in-memory Shortener with the subset of methods used by the API.
+type memoryShortener struct {
+	short.Shortener
+	links   map[string]*short.ShortenedUrlInfo
+	apiKeys map[string]*short.ApiKey
+	nextId  int
+}
+
+func newMemoryShortener() *memoryShortener {
+	return &memoryShortener{links: map[string]*short.ShortenedUrlInfo{}, apiKeys: map[string]*short.ApiKey{}}
+}
+
+func (s *memoryShortener) CreateShortenedUrl(ctx context.Context, url string, config ...short.UrlConfig) (short.ShortenedURL, error) {
+	if strings.Contains(url, "broken") {
+		return nil, errors.New("database is down")
+	}
+	if !strings.HasPrefix(url, "https://") {
+		return nil, &short.ValidationError{}
+	}
+	host := "short.com"
+	if domain := short.DomainFromContext(ctx); domain != "" {
+		host = domain
+	}
+	s.nextId++
+	id := fmt.Sprintf("id%d", s.nextId)
+	s.links[id] = &short.ShortenedUrlInfo{Id: id, ShortUrl: "https://" + host + "/" + id, Url: url, State: short.LinkActive}
+	return shortenedUrl("https://" + host + "/" + id), nil
+}
+
+func (s *memoryShortener) GetShortenedUrlInfo(ctx context.Context, id string) (*short.ShortenedUrlInfo, error) {
+	if strings.Contains(id, "broken") {
+		return nil, errors.New("database is down")
+	}
+	info, ok := s.links[id]
+	if !ok {
+		return nil, &short.IdNotFoundError{}
+	}
+	return info, nil
+}
+
+func (s *memoryShortener) ListShortenedUrls(ctx context.Context, options short.ListOptions) (*short.ListResult, error) {
+	ids := make([]string, 0, len(s.links))
+	for id, info := range s.links {
+		if id > options.Cursor && (options.Owner == "" || options.Owner == info.Owner) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	if options.Limit == 0 {
+		options.Limit = 100
+	}
+
+	var result short.ListResult
+	if len(ids) > options.Limit {
+		ids = ids[:options.Limit]
+		result.NextCursor = ids[len(ids)-1]
+	}
+	for _, id := range ids {
+		result.ShortenedUrls = append(result.ShortenedUrls, s.links[id])
+	}
+	return &result, nil
+}
+
+func (s *memoryShortener) UpdateDestination(ctx context.Context, id string, url string) error {
+	info, ok := s.links[id]
+	if !ok {
+		return &short.IdNotFoundError{}
+	}
+	info.Url = url
+	return nil
+}
+
+func (s *memoryShortener) SetExpiration(ctx context.Context, id string, expiration *time.Time) error {
+	info, ok := s.links[id]
+	if !ok {
+		return &short.IdNotFoundError{}
+	}
+	info.ExpirationDate = expiration
+	return nil
+}
+
+func (s *memoryShortener) DeleteShortenedUrl(ctx context.Context, id string) error {
+	if _, ok := s.links[id]; !ok {
+		return &short.IdNotFoundError{}
+	}
+	delete(s.links, id)
+	return nil
+}
+
+func (s *memoryShortener) GetStats(ctx context.Context, id string) (*short.Stats, error) {
+	if _, ok := s.links[id]; !ok {
+		return nil, &short.IdNotFoundError{}
+	}
+	return &short.Stats{Clicks: 5, BotClicks: 1, UniqueVisitors: 3}, nil
+}
+
+func (s *memoryShortener) AuthenticateApiKey(ctx context.Context, key string) (*short.ApiKey, error) {
+	apiKey, ok := s.apiKeys[key]
+	if !ok {
+		return nil, &short.InvalidApiKeyError{}
+	}
+	return apiKey, nil
+}
+
+func (s *memoryShortener) GetTimeSeries(ctx context.Context, id string, from time.Time, to time.Time, granularity short.Granularity) (*short.TimeSeries, error) {
+	if granularity != short.GranularityDay && granularity != short.GranularityHour {
+		return nil, &short.ValidationError{}
+	}
+	return &short.TimeSeries{
+		Granularity:  granularity,
+		Buckets:      []short.TimeBucket{{Start: from, Clicks: 2}},
+		TopReferrers: []short.Count{{Value: "https://ref.com", Count: 2}},
+	}, nil
+}
+
+func TestHandler(t *testing.T) {
+	s := newMemoryShortener()
+	h := NewHandler(s, Options{MaxBatchSize: 2})
+
+	do := func(t *testing.T, method string, target string, body interface{}, res interface{}) int {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			require.Nil(t, json.NewEncoder(&buf).Encode(body))
+		}
+		r := httptest.NewRequest(method, target, &buf)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if res != nil && w.Body.Len() > 0 {
+			require.Nil(t, json.Unmarshal(w.Body.Bytes(), res))
+		}
+		return w.Code
+	}
+
+	requireError := func(t *testing.T, expectedStatusCode int, expectedCode string, statusCode int, res *ErrorResponse) {
+		t.Helper()
+		require.Equal(t, expectedStatusCode, statusCode)
+		require.Equal(t, expectedCode, res.Error.Code)
+	}
+
+	t.Run("create", func(t *testing.T) {
+		var link Link
+		code := do(t, http.MethodPost, "/links", &CreateLinkRequest{Url: "https://test.com"}, &link)
+		require.Equal(t, http.StatusCreated, code)
+		require.Equal(t, "https://test.com", link.Url)
+		require.Equal(t, "https://short.com/"+link.Id, link.ShortUrl)
+	})
+
+	t.Run("create invalid", func(t *testing.T) {
+		var res ErrorResponse
+		code := do(t, http.MethodPost, "/links", &CreateLinkRequest{Url: "ftp://test.com"}, &res)
+		requireError(t, http.StatusBadRequest, CodeInvalidArgument, code, &res)
+	})
+
+	t.Run("create unknown field", func(t *testing.T) {
+		var res ErrorResponse
+		code := do(t, http.MethodPost, "/links", map[string]string{"url": "https://test.com", "unknown": "a"}, &res)
+		requireError(t, http.StatusBadRequest, CodeInvalidArgument, code, &res)
+	})
+
+	t.Run("create internal error", func(t *testing.T) {
+		var res ErrorResponse
+		code := do(t, http.MethodPost, "/links", &CreateLinkRequest{Url: "https://broken.com"}, &res)
+		requireError(t, http.StatusInternalServerError, CodeInternal, code, &res)
+		require.NotContains(t, res.Error.Message, "database")
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		var res BatchCreateResponse
+		code := do(t, http.MethodPost, "/links/batch", &BatchCreateRequest{Links: []CreateLinkRequest{
+			{Url: "https://a.com"},
+			{Url: "ftp://b.com"},
+		}}, &res)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, res.Results, 2)
+		require.Equal(t, "https://a.com", res.Results[0].Link.Url)
+		require.Nil(t, res.Results[0].Error)
+		require.Nil(t, res.Results[1].Link)
+		require.Equal(t, CodeInvalidArgument, res.Results[1].Error.Code)
+	})
+
+	t.Run("batch too large", func(t *testing.T) {
+		var res ErrorResponse
+		code := do(t, http.MethodPost, "/links/batch", &BatchCreateRequest{Links: make([]CreateLinkRequest, 3)}, &res)
+		requireError(t, http.StatusBadRequest, CodeInvalidArgument, code, &res)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		var link Link
+		code := do(t, http.MethodGet, "/links/id1", nil, &link)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "id1", link.Id)
+		require.Equal(t, "active", link.State)
+
+		var res ErrorResponse
+		code = do(t, http.MethodGet, "/links/missing", nil, &res)
+		requireError(t, http.StatusNotFound, CodeNotFound, code, &res)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		var link Link
+		code := do(t, http.MethodPatch, "/links/id1", &UpdateLinkRequest{Url: "https://updated.com"}, &link)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "https://updated.com", link.Url)
+	})
+
+	t.Run("set expiration", func(t *testing.T) {
+		expirationDate := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		var link Link
+		code := do(t, http.MethodPut, "/links/id1/expiration", &SetExpirationRequest{ExpirationDate: &expirationDate}, &link)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, expirationDate, *link.ExpirationDate)
+
+		link = Link{}
+		code = do(t, http.MethodPut, "/links/id1/expiration", map[string]interface{}{"expirationDate": nil}, &link)
+		require.Equal(t, http.StatusOK, code)
+		require.Nil(t, link.ExpirationDate)
+
+		var res ErrorResponse
+		code = do(t, http.MethodPut, "/links/missing/expiration", &SetExpirationRequest{}, &res)
+		requireError(t, http.StatusNotFound, CodeNotFound, code, &res)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		var res ListLinksResponse
+		code := do(t, http.MethodGet, "/links?limit=1", nil, &res)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, res.Links, 1)
+		require.Equal(t, "id1", res.NextCursor)
+
+		next := ListLinksResponse{}
+		code = do(t, http.MethodGet, "/links?limit=1&cursor="+res.NextCursor, nil, &next)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, next.Links, 1)
+		require.Equal(t, "id2", next.Links[0].Id)
+		require.Empty(t, next.NextCursor)
+
+		var errRes ErrorResponse
+		code = do(t, http.MethodGet, "/links?limit=a", nil, &errRes)
+		requireError(t, http.StatusBadRequest, CodeInvalidArgument, code, &errRes)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		var stats Stats
+		code := do(t, http.MethodGet, "/links/id1/stats", nil, &stats)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, int64(5), stats.Clicks)
+		require.Equal(t, uint64(3), stats.UniqueVisitors)
+	})
+
+	t.Run("timeseries", func(t *testing.T) {
+		var ts TimeSeries
+		code := do(t, http.MethodGet, "/links/id1/timeseries?from=2022-10-01T00:00:00Z&to=2022-10-02T00:00:00Z&granularity=hour", nil, &ts)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, short.GranularityHour, ts.Granularity)
+		require.True(t, time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC).Equal(ts.Buckets[0].Start))
+		require.Equal(t, []Count{{Value: "https://ref.com", Count: 2}}, ts.TopReferrers)
+
+		var res ErrorResponse
+		code = do(t, http.MethodGet, "/links/id1/timeseries?from=yesterday", nil, &res)
+		requireError(t, http.StatusBadRequest, CodeInvalidArgument, code, &res)
+
+		code = do(t, http.MethodGet, "/links/id1/timeseries?granularity=week", nil, &res)
+		requireError(t, http.StatusBadRequest, CodeInvalidArgument, code, &res)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		code := do(t, http.MethodDelete, "/links/id1", nil, nil)
+		require.Equal(t, http.StatusNoContent, code)
+
+		var res ErrorResponse
+		code = do(t, http.MethodDelete, "/links/id1", nil, &res)
+		requireError(t, http.StatusNotFound, CodeNotFound, code, &res)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		var res ErrorResponse
+		code := do(t, http.MethodPut, "/links", nil, &res)
+		requireError(t, http.StatusMethodNotAllowed, CodeMethodNotAllowed, code, &res)
+	})
+
+	t.Run("route not found", func(t *testing.T) {
+		var res ErrorResponse
+		code := do(t, http.MethodGet, "/unknown", nil, &res)
+		requireError(t, http.StatusNotFound, CodeNotFound, code, &res)
+	})
+}
+
+func TestOpenApiDocument(t *testing.T) {
+	var document struct {
+		OpenApi string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+
+	w := httptest.NewRecorder()
+	NewHandler(newMemoryShortener(), Options{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &document))
+	require.True(t, strings.HasPrefix(document.OpenApi, "3."))
+
+	for path, methods := range map[string][]string{
+		"/links":                 {"get", "post"},
+		"/links/batch":           {"post"},
+		"/links/{id}":            {"get", "patch", "delete"},
+		"/links/{id}/expiration": {"put"},
+		"/links/{id}/stats":      {"get"},
+		"/links/{id}/timeseries": {"get"},
+	} {
+		for _, method := range methods {
+			require.Contains(t, document.Paths[path], method, "%s %s", method, path)
+		}
+	}
+
+	t.Run("routes", func(t *testing.T) {
+		// Every route of the handler is documented, and every documented path is a route.
+		documented := map[string][]string{}
+		for path, item := range document.Paths {
+			for method := range item {
+				if method != "parameters" {
+					documented[path] = append(documented[path], strings.ToUpper(method))
+				}
+			}
+			sort.Strings(documented[path])
+		}
+
+		registered := map[string][]string{}
+		for _, rt := range routes {
+			for method := range rt.methods {
+				registered[rt.path] = append(registered[rt.path], method)
+			}
+			sort.Strings(registered[rt.path])
+		}
+
+		require.Equal(t, registered, documented)
+	})
+
+	t.Run("package doc", func(t *testing.T) {
+		src, err := os.ReadFile("api.go")
+		require.Nil(t, err)
+
+		for _, rt := range routes {
+			for method := range rt.methods {
+				require.Regexp(t, fmt.Sprintf(`(?m)^//\t%s +%s +\S`, method, regexp.QuoteMeta(rt.path)), string(src), "%s %s", method, rt.path)
+			}
+		}
+	})
+}
+
+func TestDomain(t *testing.T) {
+	h := NewHandler(newMemoryShortener(), Options{})
+
+	create := func(target string, req *CreateLinkRequest) *Link {
+		var buf bytes.Buffer
+		require.Nil(t, json.NewEncoder(&buf).Encode(req))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, &buf))
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		var link Link
+		require.Nil(t, json.Unmarshal(w.Body.Bytes(), &link))
+		return &link
+	}
+
+	require.True(t, strings.HasPrefix(create("/links", &CreateLinkRequest{Url: "https://test.com"}).ShortUrl, "https://short.com/"))
+	require.True(t, strings.HasPrefix(create("/links?domain=acme.link", &CreateLinkRequest{Url: "https://test.com"}).ShortUrl, "https://acme.link/"))
+	require.True(t, strings.HasPrefix(create("/links", &CreateLinkRequest{Url: "https://test.com", Domain: "eu.acme.link"}).ShortUrl, "https://eu.acme.link/"))
+}
+
+func TestCreateRateLimiter(t *testing.T) {
+	limiter, err := short.NewMemoryRateLimiter(1.0/60, 3)
+	require.Nil(t, err)
+
+	s := newMemoryShortener()
+	s.apiKeys["sk_alice"] = &short.ApiKey{Id: "alice", Scopes: []short.Scope{short.ScopeCreate}}
+	h := RequireApiKey(s)(NewHandler(s, Options{CreateRateLimiter: limiter}))
+
+	do := func(key string, target string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		require.Nil(t, json.NewEncoder(&buf).Encode(body))
+		r := httptest.NewRequest(http.MethodPost, target, &buf)
+		r.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("sk_alice", "/links/batch", &BatchCreateRequest{Links: []CreateLinkRequest{{Url: "https://a.com"}, {Url: "https://b.com"}}})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = do("sk_alice", "/links", &CreateLinkRequest{Url: "https://c.com"})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = do("sk_alice", "/links", &CreateLinkRequest{Url: "https://d.com"})
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	var res ErrorResponse
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	require.Equal(t, CodeRateLimited, res.Error.Code)
+	require.Len(t, s.links, 3)
+}
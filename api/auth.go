@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/TomerHeber/go-short-url"
+)
+
+type apiKeyContextKey struct{}
+
+// ApiKeyAuthenticator authenticates api keys (implemented by `short.Shortener`).
+type ApiKeyAuthenticator interface {
+	AuthenticateApiKey(ctx context.Context, key string) (*short.ApiKey, error)
+}
+
+// ContextWithApiKey returns a copy of `ctx` that carries the api key of a request.
+func ContextWithApiKey(ctx context.Context, key *short.ApiKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// ApiKeyFromContext returns the api key carried by `ctx` (see `ContextWithApiKey`).
+func ApiKeyFromContext(ctx context.Context) (*short.ApiKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(*short.ApiKey)
+	return key, ok
+}
+
+// RequireApiKey returns a middleware that rejects requests without a valid api key with 401.
+// The key is read from the `Authorization: Bearer <key>` header or from the `X-Api-Key` header.
+//
+// The api key is added to the request context. The handler returned by `NewHandler` then enforces
+// the scopes of the key and restricts keys without the admin scope to the shortened urls they own.
+// The requests of a tenant key run on the shortened urls of the tenant (see `short.ContextWithTenant`).
+// Without this middleware the handler allows all the operations.
+func RequireApiKey(authenticator ApiKeyAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := requestApiKey(r)
+			if key == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, &Error{Code: CodeUnauthenticated, Message: "missing api key"})
+				return
+			}
+
+			apiKey, err := authenticator.AuthenticateApiKey(r.Context(), key)
+			if err != nil {
+				var invalidErr *short.InvalidApiKeyError
+				if errors.As(err, &invalidErr) {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+				}
+				writeShortenerError(w, err)
+				return
+			}
+
+			ctx := ContextWithApiKey(r.Context(), apiKey)
+			if apiKey.Tenant != "" {
+				// The requests of a tenant key run on the shortened urls of the tenant.
+				ctx = short.ContextWithTenant(ctx, apiKey.Tenant)
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func requestApiKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if scheme, key, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(key)
+		}
+		return ""
+	}
+
+	return r.Header.Get("X-Api-Key")
+}
+
+// authorize checks that the api key of the request (if any) has one of `scopes`.
+// On failure an error response is written and false is returned.
+func authorize(w http.ResponseWriter, r *http.Request, scopes ...short.Scope) (*short.ApiKey, bool) {
+	key, ok := ApiKeyFromContext(r.Context())
+	if !ok {
+		return nil, true
+	}
+
+	for _, scope := range scopes {
+		if key.HasScope(scope) {
+			return key, true
+		}
+	}
+
+	writeError(w, http.StatusForbidden, &Error{Code: CodePermissionDenied, Message: "the api key does not have the required scope"})
+	return nil, false
+}
+
+// authorizeLink checks that the api key of the request (if any) has one of `scopes` and can access the shortened url `id`.
+// Shortened urls that the key cannot access are reported as not found.
+// On failure an error response is written and false is returned.
+func (h *handler) authorizeLink(w http.ResponseWriter, r *http.Request, id string, scopes ...short.Scope) bool {
+	key, ok := authorize(w, r, scopes...)
+	if !ok {
+		return false
+	}
+	if key == nil || key.HasScope(short.ScopeAdmin) {
+		return true
+	}
+
+	info, err := h.shortener.GetShortenedUrlInfo(r.Context(), id)
+	if err != nil {
+		writeShortenerError(w, err)
+		return false
+	}
+
+	if !key.CanAccess(info) {
+		writeError(w, http.StatusNotFound, &Error{Code: CodeNotFound, Message: "the id " + id + " not found"})
+		return false
+	}
+
+	return true
+}
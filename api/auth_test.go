@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TomerHeber/go-short-url"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiKeyFromContext(t *testing.T) {
+	_, ok := ApiKeyFromContext(context.Background())
+	require.False(t, ok)
+
+	key := &short.ApiKey{Id: "abc"}
+	res, ok := ApiKeyFromContext(ContextWithApiKey(context.Background(), key))
+	require.True(t, ok)
+	require.Equal(t, key, res)
+}
+
+func TestRequireApiKey(t *testing.T) {
+	s := newMemoryShortener()
+	s.apiKeys["sk_admin"] = &short.ApiKey{Id: "admin", Scopes: []short.Scope{short.ScopeAdmin}}
+	s.apiKeys["sk_alice"] = &short.ApiKey{Id: "alice", Scopes: []short.Scope{short.ScopeCreate}}
+	s.apiKeys["sk_bob"] = &short.ApiKey{Id: "bob", Scopes: []short.Scope{short.ScopeCreate, short.ScopeReadStats}}
+	s.apiKeys["sk_stats"] = &short.ApiKey{Id: "stats", Scopes: []short.Scope{short.ScopeReadStats}}
+	s.links["alice1"] = &short.ShortenedUrlInfo{Id: "alice1", Url: "https://alice.com", Owner: "alice"}
+	s.links["bob1"] = &short.ShortenedUrlInfo{Id: "bob1", Url: "https://bob.com", Owner: "bob"}
+
+	h := RequireApiKey(s)(NewHandler(s, Options{}))
+
+	do := func(t *testing.T, method string, target string, header string, value string, body interface{}) (int, *ErrorResponse) {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			require.Nil(t, json.NewEncoder(&buf).Encode(body))
+		}
+		r := httptest.NewRequest(method, target, &buf)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		var res ErrorResponse
+		if w.Code >= http.StatusBadRequest {
+			require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, &res
+	}
+
+	t.Run("missing api key", func(t *testing.T) {
+		code, res := do(t, http.MethodGet, "/links", "", "", nil)
+		require.Equal(t, http.StatusUnauthorized, code)
+		require.Equal(t, CodeUnauthenticated, res.Error.Code)
+
+		code, _ = do(t, http.MethodGet, "/links", "Authorization", "Basic YTpi", nil)
+		require.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("invalid api key", func(t *testing.T) {
+		code, res := do(t, http.MethodGet, "/links", "Authorization", "Bearer sk_unknown", nil)
+		require.Equal(t, http.StatusUnauthorized, code)
+		require.Equal(t, CodeUnauthenticated, res.Error.Code)
+	})
+
+	t.Run("headers", func(t *testing.T) {
+		code, _ := do(t, http.MethodGet, "/links/alice1", "Authorization", "Bearer sk_alice", nil)
+		require.Equal(t, http.StatusOK, code)
+
+		code, _ = do(t, http.MethodGet, "/links/alice1", "X-Api-Key", "sk_alice", nil)
+		require.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		code, res := do(t, http.MethodPost, "/links", "X-Api-Key", "sk_stats", &CreateLinkRequest{Url: "https://test.com"})
+		require.Equal(t, http.StatusForbidden, code)
+		require.Equal(t, CodePermissionDenied, res.Error.Code)
+
+		code, _ = do(t, http.MethodGet, "/links/alice1/stats", "X-Api-Key", "sk_alice", nil)
+		require.Equal(t, http.StatusForbidden, code)
+
+		code, _ = do(t, http.MethodPost, "/links", "X-Api-Key", "sk_alice", &CreateLinkRequest{Url: "https://test.com"})
+		require.Equal(t, http.StatusCreated, code)
+
+		code, _ = do(t, http.MethodGet, "/links/bob1/stats", "X-Api-Key", "sk_bob", nil)
+		require.Equal(t, http.StatusOK, code)
+
+		code, _ = do(t, http.MethodDelete, "/links/bob1", "X-Api-Key", "sk_stats", nil)
+		require.Equal(t, http.StatusForbidden, code)
+	})
+
+	t.Run("ownership", func(t *testing.T) {
+		code, res := do(t, http.MethodGet, "/links/bob1", "X-Api-Key", "sk_alice", nil)
+		require.Equal(t, http.StatusNotFound, code)
+		require.Equal(t, CodeNotFound, res.Error.Code)
+
+		code, _ = do(t, http.MethodPatch, "/links/bob1", "X-Api-Key", "sk_alice", &UpdateLinkRequest{Url: "https://evil.com"})
+		require.Equal(t, http.StatusNotFound, code)
+		require.Equal(t, "https://bob.com", s.links["bob1"].Url)
+
+		code, _ = do(t, http.MethodDelete, "/links/bob1", "X-Api-Key", "sk_alice", nil)
+		require.Equal(t, http.StatusNotFound, code)
+
+		code, _ = do(t, http.MethodPatch, "/links/bob1", "X-Api-Key", "sk_admin", &UpdateLinkRequest{Url: "https://bob2.com"})
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "https://bob2.com", s.links["bob1"].Url)
+	})
+
+	t.Run("override", func(t *testing.T) {
+		code, res := do(t, http.MethodPost, "/links", "X-Api-Key", "sk_alice", &CreateLinkRequest{Url: "https://evil.com", Alias: "bob1", Override: true})
+		require.Equal(t, http.StatusConflict, code)
+		require.Equal(t, CodeAlreadyExists, res.Error.Code)
+
+		// The override is not allowed when the owner of the alias cannot be checked.
+		code, res = do(t, http.MethodPost, "/links", "X-Api-Key", "sk_alice", &CreateLinkRequest{Url: "https://evil.com", Alias: "broken1", Override: true})
+		require.Equal(t, http.StatusInternalServerError, code)
+		require.Equal(t, CodeInternal, res.Error.Code)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		list := func(key string) []*Link {
+			r := httptest.NewRequest(http.MethodGet, "/links", nil)
+			r.Header.Set("X-Api-Key", key)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var res ListLinksResponse
+			require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+			return res.Links
+		}
+
+		links := list("sk_bob")
+		require.Len(t, links, 1)
+		require.Equal(t, "bob1", links[0].Id)
+
+		require.Len(t, list("sk_admin"), len(s.links))
+	})
+}
+
+func TestRequireApiKeyTenant(t *testing.T) {
+	s := newMemoryShortener()
+	s.apiKeys["sk_acme_secret"] = &short.ApiKey{Id: "acme1", Scopes: []short.Scope{short.ScopeAdmin}, Tenant: "acme"}
+	s.apiKeys["sk_default"] = &short.ApiKey{Id: "default", Scopes: []short.Scope{short.ScopeAdmin}}
+
+	var tenant string
+	h := RequireApiKey(s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = short.TenantFromContext(r.Context())
+	}))
+
+	serve := func(key string) {
+		r := httptest.NewRequest(http.MethodGet, "/links", nil)
+		r.Header.Set("X-Api-Key", key)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	serve("sk_acme_secret")
+	require.Equal(t, "acme", tenant)
+
+	serve("sk_default")
+	require.Equal(t, "", tenant)
+
+	code, res := newError(&short.QuotaExceededError{})
+	require.Equal(t, http.StatusForbidden, code)
+	require.Equal(t, CodeQuotaExceeded, res.Code)
+
+	code, res = newError(&short.TenantNotFoundError{})
+	require.Equal(t, http.StatusNotFound, code)
+	require.Equal(t, CodeNotFound, res.Code)
+
+	code, res = newError(fmt.Errorf("resolve: %w", &short.ExpiredError{Id: "old"}))
+	require.Equal(t, http.StatusGone, code)
+	require.Equal(t, CodeGone, res.Code)
+}
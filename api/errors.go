@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/TomerHeber/go-short-url"
+)
+
+// Error codes of the error responses (see `short.ErrorCode`).
+const (
+	CodeInvalidArgument    = string(short.CodeInvalidArgument)
+	CodeNotFound           = string(short.CodeNotFound)
+	CodeGone               = string(short.CodeGone)
+	CodeAlreadyExists      = string(short.CodeAlreadyExists)
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeUnauthenticated    = string(short.CodeUnauthenticated)
+	CodePermissionDenied   = string(short.CodePermissionDenied)
+	CodeFailedPrecondition = string(short.CodeFailedPrecondition)
+	CodeRateLimited        = string(short.CodeRateLimited)
+	CodeQuotaExceeded      = string(short.CodeQuotaExceeded)
+	CodeInternal           = string(short.CodeInternal)
+)
+
+// statusCodes maps the error codes of the shortener to http status codes.
+var statusCodes = map[short.ErrorCode]int{
+	short.CodeInvalidArgument:    http.StatusBadRequest,
+	short.CodeNotFound:           http.StatusNotFound,
+	short.CodeGone:               http.StatusGone,
+	short.CodeAlreadyExists:      http.StatusConflict,
+	short.CodeUnauthenticated:    http.StatusUnauthorized,
+	short.CodePermissionDenied:   http.StatusForbidden,
+	short.CodeFailedPrecondition: http.StatusConflict,
+	short.CodeRateLimited:        http.StatusTooManyRequests,
+	short.CodeQuotaExceeded:      http.StatusForbidden,
+}
+
+// newError maps an error returned by the shortener to an http status code and an error body.
+// Internal error messages are not exposed.
+func newError(err error) (int, *Error) {
+	code := short.ErrorCodeOf(err)
+
+	statusCode, ok := statusCodes[code]
+	if !ok {
+		return http.StatusInternalServerError, &Error{Code: CodeInternal, Message: http.StatusText(http.StatusInternalServerError)}
+	}
+
+	return statusCode, &Error{Code: string(code), Message: err.Error()}
+}
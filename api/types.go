@@ -0,0 +1,214 @@
+package api
+
+import (
+	"time"
+
+	"github.com/TomerHeber/go-short-url"
+)
+
+// CreateLinkRequest is the body of `POST /links`.
+type CreateLinkRequest struct {
+	Url string `json:"url"`
+	// Alias is used instead of a random id (optional).
+	Alias string `json:"alias,omitempty"`
+	// Override replaces an existing shortened url with the same alias.
+	Override bool `json:"override,omitempty"`
+	// ExpirationDate is optional.
+	ExpirationDate *time.Time `json:"expirationDate,omitempty"`
+	// RedirectType is 301, 302, 307 or 308 (optional).
+	RedirectType int `json:"redirectType,omitempty"`
+	// Domain is the domain of the shortened url (optional, see `short.UrlConfig.WithDomain`).
+	Domain string `json:"domain,omitempty"`
+	// Password protects the shortened url (optional, see `short.UrlConfig.WithPassword`).
+	Password string `json:"password,omitempty"`
+	// MaxClicks limits the number of resolves (optional, see `short.UrlConfig.WithMaxClicks`).
+	MaxClicks int64 `json:"maxClicks,omitempty"`
+	// ActivationDate is the time the shortened url starts to resolve (optional).
+	ActivationDate *time.Time `json:"activationDate,omitempty"`
+	// TtlSeconds sets the expiration date relative to the creation (optional, instead of ExpirationDate).
+	TtlSeconds int64 `json:"ttlSeconds,omitempty"`
+	// SlidingExpiration extends the expiration date to the ttl on each resolve (requires TtlSeconds).
+	SlidingExpiration bool `json:"slidingExpiration,omitempty"`
+}
+
+// UpdateLinkRequest is the body of `PATCH /links/{id}`.
+type UpdateLinkRequest struct {
+	Url string `json:"url"`
+}
+
+// SetExpirationRequest is the body of `PUT /links/{id}/expiration`.
+type SetExpirationRequest struct {
+	// ExpirationDate is the new expiration date (null removes it).
+	ExpirationDate *time.Time `json:"expirationDate"`
+}
+
+// BatchCreateRequest is the body of `POST /links/batch`.
+type BatchCreateRequest struct {
+	Links []CreateLinkRequest `json:"links"`
+}
+
+// Link is a shortened url.
+type Link struct {
+	Id             string     `json:"id"`
+	ShortUrl       string     `json:"shortUrl"`
+	Url            string     `json:"url"`
+	ExpirationDate *time.Time `json:"expirationDate,omitempty"`
+	RedirectType   int        `json:"redirectType,omitempty"`
+	CreatedAt      *time.Time `json:"createdAt,omitempty"`
+	// Flagged is true if the url is on a third-party shortener domain.
+	Flagged bool `json:"flagged,omitempty"`
+	// PasswordProtected is true if the shortened url requires a password.
+	PasswordProtected bool `json:"passwordProtected,omitempty"`
+	// RemainingClicks is the number of resolves left of a click-limited shortened url.
+	RemainingClicks *int64 `json:"remainingClicks,omitempty"`
+	// ActivationDate is the time the shortened url starts to resolve.
+	ActivationDate *time.Time `json:"activationDate,omitempty"`
+	// SlidingTtlSeconds is the ttl the expiration date is extended to on each resolve.
+	SlidingTtlSeconds int64 `json:"slidingTtlSeconds,omitempty"`
+	// State is "active", "expired" or "disabled" (a click-limited shortened url that used up its clicks).
+	State string `json:"state"`
+}
+
+// ListLinksResponse is the body of the `GET /links` response.
+type ListLinksResponse struct {
+	Links      []*Link `json:"links"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+// BatchCreateResult is the result of a single link of a batch.
+// Exactly one of Link and Error is set.
+type BatchCreateResult struct {
+	Link  *Link  `json:"link,omitempty"`
+	Error *Error `json:"error,omitempty"`
+}
+
+// BatchCreateResponse is the body of the `POST /links/batch` response.
+// Results are in the same order as the links of the request.
+type BatchCreateResponse struct {
+	Results []BatchCreateResult `json:"results"`
+}
+
+// Stats is the body of the `GET /links/{id}/stats` response.
+type Stats struct {
+	Clicks         int64      `json:"clicks"`
+	BotClicks      int64      `json:"botClicks"`
+	UniqueVisitors uint64     `json:"uniqueVisitors"`
+	LastAccessed   *time.Time `json:"lastAccessed,omitempty"`
+}
+
+// TimeBucket is the clicks of a time bucket.
+type TimeBucket struct {
+	Start          time.Time `json:"start"`
+	Clicks         int64     `json:"clicks"`
+	BotClicks      int64     `json:"botClicks"`
+	UniqueVisitors uint64    `json:"uniqueVisitors"`
+}
+
+// Count is the number of clicks of a value.
+type Count struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// TimeSeries is the body of the `GET /links/{id}/timeseries` response.
+type TimeSeries struct {
+	Granularity    short.Granularity `json:"granularity"`
+	UniqueVisitors uint64            `json:"uniqueVisitors"`
+	Buckets        []TimeBucket      `json:"buckets"`
+	TopReferrers   []Count           `json:"topReferrers"`
+	TopUserAgents  []Count           `json:"topUserAgents"`
+	Countries      []Count           `json:"countries"`
+	Regions        []Count           `json:"regions"`
+}
+
+// Error is the error of a failed request (or of a failed link in a batch).
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ErrorResponse is the body of all error responses.
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+func newLink(info *short.ShortenedUrlInfo) *Link {
+	return &Link{
+		Id:                info.Id,
+		ShortUrl:          info.ShortUrl,
+		Url:               info.Url,
+		ExpirationDate:    info.ExpirationDate,
+		RedirectType:      info.RedirectType,
+		CreatedAt:         info.CreatedAt,
+		Flagged:           info.Flagged,
+		PasswordProtected: info.PasswordProtected,
+		RemainingClicks:   info.RemainingClicks,
+		ActivationDate:    info.ActivationDate,
+		SlidingTtlSeconds: int64(info.SlidingTtl.Seconds()),
+		State:             info.State.String(),
+	}
+}
+
+func newStats(stats *short.Stats) *Stats {
+	return &Stats{
+		Clicks:         stats.Clicks,
+		BotClicks:      stats.BotClicks,
+		UniqueVisitors: stats.UniqueVisitors,
+		LastAccessed:   stats.LastAccessed,
+	}
+}
+
+func newCounts(counts []short.Count) []Count {
+	res := make([]Count, len(counts))
+	for i, c := range counts {
+		res[i] = Count{Value: c.Value, Count: c.Count}
+	}
+	return res
+}
+
+func newTimeSeries(ts *short.TimeSeries) *TimeSeries {
+	res := TimeSeries{
+		Granularity:    ts.Granularity,
+		UniqueVisitors: ts.UniqueVisitors,
+		Buckets:        make([]TimeBucket, len(ts.Buckets)),
+		TopReferrers:   newCounts(ts.TopReferrers),
+		TopUserAgents:  newCounts(ts.TopUserAgents),
+		Countries:      newCounts(ts.Countries),
+		Regions:        newCounts(ts.Regions),
+	}
+
+	for i, b := range ts.Buckets {
+		res.Buckets[i] = TimeBucket{Start: b.Start, Clicks: b.Clicks, BotClicks: b.BotClicks, UniqueVisitors: b.UniqueVisitors}
+	}
+
+	return &res
+}
+
+func (r *CreateLinkRequest) urlConfig() short.UrlConfig {
+	c := short.DefaultUrlConfig()
+	if r.Alias != "" {
+		c = c.WithAlias(r.Alias).WithOverrideAlias(r.Override)
+	}
+	if r.ExpirationDate != nil {
+		c = c.WithExpirationDate(*r.ExpirationDate)
+	}
+	if r.RedirectType != 0 {
+		c = c.WithRedirectType(r.RedirectType)
+	}
+	if r.Password != "" {
+		c = c.WithPassword(r.Password)
+	}
+	if r.MaxClicks != 0 {
+		c = c.WithMaxClicks(r.MaxClicks)
+	}
+	if r.ActivationDate != nil {
+		c = c.WithActivationDate(*r.ActivationDate)
+	}
+	if r.TtlSeconds != 0 {
+		c = c.WithTtl(time.Duration(r.TtlSeconds) * time.Second)
+	}
+	if r.SlidingExpiration {
+		c = c.WithSlidingExpiration(true)
+	}
+	return c
+}
@@ -0,0 +1,209 @@
+package short
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jxskiss/base62"
+)
+
+// apiKeyPrefix makes api keys recognizable (e.g. by secret scanners).
+// The keys of a tenant are `sk_<tenant>_<secret>`, so a key is authenticated in the namespace of its tenant.
+const apiKeyPrefix = "sk_"
+
+// Scope is a permission granted to an api key.
+type Scope string
+
+const (
+	// ScopeCreate allows creating shortened urls and managing the shortened urls owned by the key.
+	ScopeCreate Scope = "create"
+	// ScopeReadStats allows reading the statistics of the shortened urls owned by the key.
+	ScopeReadStats Scope = "read-stats"
+	// ScopeAdmin allows everything, including managing shortened urls owned by other keys.
+	ScopeAdmin Scope = "admin"
+)
+
+func (s Scope) validate() error {
+	switch s {
+	case ScopeCreate, ScopeReadStats, ScopeAdmin:
+		return nil
+	default:
+		return newValidationError("invalid scope %s (must be %s, %s or %s)", s, ScopeCreate, ScopeReadStats, ScopeAdmin)
+	}
+}
+
+// ApiKey is an api key. The key itself is only returned by `CreateApiKey`.
+type ApiKey struct {
+	Id        string
+	Name      string
+	Scopes    []Scope
+	CreatedAt time.Time
+	// Tenant is the tenant of the api key (empty for the default namespace).
+	// Requests authenticated with the key run on the tenant (see `ContextWithTenant`).
+	Tenant string
+}
+
+// HasScope returns true if the api key was granted `scope` (or the admin scope).
+func (k *ApiKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanAccess returns true if the api key has the admin scope or owns the shortened url.
+func (k *ApiKey) CanAccess(info *ShortenedUrlInfo) bool {
+	return k.HasScope(ScopeAdmin) || info.Owner == k.Id
+}
+
+// generateApiKey generates a random api key (256 bits) of `tenant`.
+func generateApiKey(tenant string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate a random api key: %w", err)
+	}
+
+	if tenant != "" {
+		return apiKeyPrefix + tenant + "_" + base62.EncodeToString(b), nil
+	}
+
+	return apiKeyPrefix + base62.EncodeToString(b), nil
+}
+
+// apiKeyTenant returns the tenant of an api key (empty for the default namespace).
+func apiKeyTenant(key string) string {
+	parts := strings.Split(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if len(parts) != 2 {
+		return ""
+	}
+
+	return parts[0]
+}
+
+// hashApiKey returns the hash that is stored instead of the api key.
+// Api keys are random and long, so a fast hash is enough (unlike passwords).
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newApiKey(rec *apiKeyRecord, tenant string) *ApiKey {
+	key := ApiKey{
+		Id:        rec.Id,
+		Name:      rec.Name,
+		Scopes:    make([]Scope, len(rec.Scopes)),
+		CreatedAt: time.Unix(rec.CreatedAt, 0),
+		Tenant:    tenant,
+	}
+
+	for i, scope := range rec.Scopes {
+		key.Scopes[i] = Scope(scope)
+	}
+
+	return &key
+}
+
+func (s *shortner) CreateApiKey(ctx context.Context, name string, scopes ...Scope) (string, *ApiKey, error) {
+	if len(scopes) == 0 {
+		return "", nil, newValidationError("an api key requires at least one scope")
+	}
+
+	rec := apiKeyRecord{
+		Name:      name,
+		Scopes:    make([]string, len(scopes)),
+		CreatedAt: time.Now().Unix(),
+	}
+
+	for i, scope := range scopes {
+		if err := scope.validate(); err != nil {
+			return "", nil, err
+		}
+		rec.Scopes[i] = string(scope)
+	}
+
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key, err := generateApiKey(ns.tenantId())
+	if err != nil {
+		return "", nil, err
+	}
+	rec.Hash = hashApiKey(key)
+
+	rec.Id, err = generateRandomId()
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = s.withQuota(ctx, ns, "api keys", ns.quota().MaxApiKeys, ns.store.CountApiKeys, func() error {
+		return ns.store.InsertApiKey(ctx, &rec)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, newApiKey(&rec, ns.tenantId()), nil
+}
+
+func (s *shortner) AuthenticateApiKey(ctx context.Context, key string) (*ApiKey, error) {
+	// The tenant of the key (if any) replaces the tenant of the context.
+	ns, err := s.namespace(ContextWithTenant(ctx, apiKeyTenant(key)))
+	if err != nil {
+		var notFoundErr *TenantNotFoundError
+		var validationErr *ValidationError
+		if errors.As(err, &notFoundErr) || errors.As(err, &validationErr) {
+			return nil, &InvalidApiKeyError{}
+		}
+		return nil, err
+	}
+
+	rec, err := ns.store.GetApiKey(ctx, hashApiKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return newApiKey(rec, ns.tenantId()), nil
+}
+
+func (s *shortner) ListApiKeys(ctx context.Context) ([]*ApiKey, error) {
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recs, err := ns.store.ListApiKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*ApiKey, len(recs))
+	for i, rec := range recs {
+		keys[i] = newApiKey(rec, ns.tenantId())
+	}
+
+	return keys, nil
+}
+
+func (s *shortner) RevokeApiKey(ctx context.Context, id string) error {
+	if err := validateId(id); err != nil {
+		return err
+	}
+
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ns.store.DeleteApiKey(ctx, id)
+}
@@ -0,0 +1,64 @@
+package short
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiKey(t *testing.T) {
+	t.Run("HasScope", func(t *testing.T) {
+		key := ApiKey{Scopes: []Scope{ScopeCreate}}
+		require.True(t, key.HasScope(ScopeCreate))
+		require.False(t, key.HasScope(ScopeReadStats))
+		require.False(t, key.HasScope(ScopeAdmin))
+
+		admin := ApiKey{Scopes: []Scope{ScopeAdmin}}
+		require.True(t, admin.HasScope(ScopeCreate))
+		require.True(t, admin.HasScope(ScopeReadStats))
+	})
+
+	t.Run("CanAccess", func(t *testing.T) {
+		key := ApiKey{Id: "abc", Scopes: []Scope{ScopeCreate}}
+		require.True(t, key.CanAccess(&ShortenedUrlInfo{Owner: "abc"}))
+		require.False(t, key.CanAccess(&ShortenedUrlInfo{Owner: "def"}))
+		require.False(t, key.CanAccess(&ShortenedUrlInfo{}))
+
+		admin := ApiKey{Id: "admin", Scopes: []Scope{ScopeAdmin}}
+		require.True(t, admin.CanAccess(&ShortenedUrlInfo{Owner: "def"}))
+	})
+
+	t.Run("generate and hash", func(t *testing.T) {
+		key1, err := generateApiKey("")
+		require.Nil(t, err)
+		key2, err := generateApiKey("")
+		require.Nil(t, err)
+
+		require.True(t, strings.HasPrefix(key1, apiKeyPrefix))
+		require.Greater(t, len(key1), 40)
+		require.NotEqual(t, key1, key2)
+
+		require.Equal(t, hashApiKey(key1), hashApiKey(key1))
+		require.NotEqual(t, hashApiKey(key1), hashApiKey(key2))
+		require.NotContains(t, hashApiKey(key1), key1)
+	})
+
+	t.Run("tenant keys", func(t *testing.T) {
+		key, err := generateApiKey("acme")
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(key, apiKeyPrefix+"acme_"))
+		require.Equal(t, "acme", apiKeyTenant(key))
+
+		key, err = generateApiKey("")
+		require.Nil(t, err)
+		require.Equal(t, "", apiKeyTenant(key))
+		require.Equal(t, "", apiKeyTenant("sk_a_b_c"))
+	})
+
+	t.Run("scope validation", func(t *testing.T) {
+		require.Nil(t, ScopeAdmin.validate())
+		var verr *ValidationError
+		require.ErrorAs(t, Scope("root").validate(), &verr)
+	})
+}
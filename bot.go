@@ -0,0 +1,70 @@
+package short
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// BotClassifier decides whether a request was made by a bot (e.g. a search engine crawler or a link preview fetcher).
+type BotClassifier interface {
+	IsBot(md *RequestMetadata) bool
+}
+
+// knownBotPatterns are case-insensitive user agent patterns of known crawlers, link preview fetchers and http clients.
+var knownBotPatterns = []string{
+	// Generic.
+	`bot\b`, `crawl`, `spider`, `slurp`, `scraper`, `headless`, `preview`, `fetcher`,
+	// Search engines.
+	`googlebot`, `google-inspectiontool`, `bingbot`, `bingpreview`, `duckduckbot`, `baiduspider`, `yandex`, `sogou`,
+	`exabot`, `applebot`, `petalbot`, `seznambot`, `naver`,
+	// Social networks and messengers.
+	`facebookexternalhit`, `facebot`, `twitterbot`, `slackbot`, `slack-imgproxy`, `linkedinbot`, `discordbot`,
+	`telegrambot`, `whatsapp`, `skypeuripreview`, `pinterest`, `redditbot`, `vkshare`, `embedly`, `quora link preview`,
+	`mastodon`, `iframely`, `outbrain`,
+	// SEO tools and AI crawlers.
+	`ahrefsbot`, `semrushbot`, `mj12bot`, `dotbot`, `bytespider`, `gptbot`, `ccbot`, `claudebot`, `perplexitybot`,
+	// HTTP clients.
+	`^curl/`, `^wget/`, `python-requests`, `python-urllib`, `aiohttp`, `go-http-client`, `^java/`, `okhttp`,
+	`apache-httpclient`, `libwww-perl`, `node-fetch`, `axios`,
+}
+
+type userAgentBotClassifier struct {
+	regex *regexp.Regexp
+}
+
+// DefaultBotClassifier returns a classifier that matches the user agent against a list of known crawlers,
+// link preview fetchers and http clients. A request without a user agent is classified as a bot.
+func DefaultBotClassifier() BotClassifier {
+	c, _ := NewBotClassifier()
+	return c
+}
+
+// NewBotClassifier returns a classifier that matches the user agent against the default list of known bots (see `DefaultBotClassifier()`),
+// and against `patterns`. Patterns are case-insensitive regular expressions.
+func NewBotClassifier(patterns ...string) (BotClassifier, error) {
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid bot pattern %s: %w", pattern, err)
+		}
+	}
+
+	all := append(append([]string{}, knownBotPatterns...), patterns...)
+
+	return &userAgentBotClassifier{
+		regex: regexp.MustCompile("(?i)(" + strings.Join(all, ")|(") + ")"),
+	}, nil
+}
+
+func (c *userAgentBotClassifier) IsBot(md *RequestMetadata) bool {
+	if md == nil {
+		return false
+	}
+
+	userAgent := strings.TrimSpace(md.UserAgent)
+	if userAgent == "" {
+		return true
+	}
+
+	return c.regex.MatchString(userAgent)
+}
@@ -0,0 +1,61 @@
+package short
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBotClassifier(t *testing.T) {
+	c := DefaultBotClassifier()
+
+	isBot := func(userAgent string) bool {
+		return c.IsBot(&RequestMetadata{UserAgent: userAgent})
+	}
+
+	t.Run("no metadata", func(t *testing.T) {
+		require.False(t, c.IsBot(nil))
+	})
+
+	t.Run("no user agent", func(t *testing.T) {
+		require.True(t, isBot(""))
+	})
+
+	t.Run("bots", func(t *testing.T) {
+		for _, userAgent := range []string{
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)",
+			"Twitterbot/1.0",
+			"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)",
+			"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)",
+			"WhatsApp/2.21.12.21 A",
+			"curl/7.79.1",
+			"python-requests/2.28.1",
+			"Go-http-client/1.1",
+		} {
+			require.True(t, isBot(userAgent), userAgent)
+		}
+	})
+
+	t.Run("humans", func(t *testing.T) {
+		for _, userAgent := range []string{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Safari/537.36",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 16_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.0 Mobile/15E148 Safari/604.1",
+			"Mozilla/5.0 (X11; Linux x86_64; rv:105.0) Gecko/20100101 Firefox/105.0",
+		} {
+			require.False(t, isBot(userAgent), userAgent)
+		}
+	})
+
+	t.Run("custom patterns", func(t *testing.T) {
+		c, err := NewBotClassifier(`^internal-monitor/`)
+		require.Nil(t, err)
+		require.True(t, c.IsBot(&RequestMetadata{UserAgent: "Internal-Monitor/1.0"}))
+		require.True(t, c.IsBot(&RequestMetadata{UserAgent: "Twitterbot/1.0"}))
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		_, err := NewBotClassifier(`(`)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,141 @@
+package short
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SelfLinkPolicy decides what happens to a destination that is a shortened url of the shortener (see `Config.WithSelfLinks`).
+type SelfLinkPolicy int
+
+const (
+	// ResolveSelfLinks replaces the destination with the final destination of the shortened url.
+	ResolveSelfLinks SelfLinkPolicy = iota
+	// RejectSelfLinks rejects the destination with a `BlockedUrlError`.
+	RejectSelfLinks
+)
+
+// ShortenerDomainAction decides what happens to a destination on a third-party shortener domain (see `Config.WithShortenerDomains`).
+type ShortenerDomainAction int
+
+const (
+	// RejectShortenerDomains rejects the destination with a `BlockedUrlError`.
+	RejectShortenerDomains ShortenerDomainAction = iota
+	// FlagShortenerDomains accepts the destination and sets `ShortenedUrlInfo.Flagged`.
+	FlagShortenerDomains
+)
+
+// KnownShortenerDomains are the domains of popular third-party url shorteners.
+var KnownShortenerDomains = []string{
+	"bit.ly", "bit.do", "buff.ly", "cutt.ly", "goo.gl", "is.gd", "ow.ly", "rb.gy", "rebrand.ly",
+	"s.id", "shorturl.at", "t.co", "t.ly", "tiny.cc", "tinyurl.com", "v.gd",
+}
+
+// maxSelfLinkDepth is the maximum number of shortened urls that are followed to the final destination.
+const maxSelfLinkDepth = 10
+
+// destination validates a url, replaces a shortened url of the shortener with its final destination and checks it with the url policy.
+// flagged is true if the destination is on a third-party shortener domain that is flagged.
+func (s *shortner) destination(ctx context.Context, u string) (dest string, flagged bool, err error) {
+	if err := validateUrl(u); err != nil {
+		return "", false, err
+	}
+
+	dest = u
+	for depth := 0; ; depth++ {
+		next, ok, err := s.followSelfLink(ctx, dest)
+		if err != nil {
+			return "", false, err
+		}
+		if !ok {
+			break
+		}
+		if s.selfLinks == RejectSelfLinks {
+			return "", false, &BlockedUrlError{Url: u, Reason: "the url is a shortened url of this shortener"}
+		}
+		if depth == maxSelfLinkDepth {
+			return "", false, &BlockedUrlError{Url: u, Reason: "the url redirects too many times"}
+		}
+		dest = next
+	}
+
+	if s.urlPolicy != nil {
+		if err := s.urlPolicy.Check(ctx, dest); err != nil {
+			return "", false, err
+		}
+	}
+
+	pu, err := url.Parse(dest)
+	if err != nil {
+		return "", false, newValidationError("invalid url %s: %w", dest, err)
+	}
+
+	if matchesDomain(strings.TrimSuffix(strings.ToLower(pu.Hostname()), "."), s.shortenerDomains) {
+		if s.shortenerDomainAction == RejectShortenerDomains {
+			return "", false, &BlockedUrlError{Url: u, Reason: "the url is on a third-party shortener domain"}
+		}
+		flagged = true
+	}
+
+	return dest, flagged, nil
+}
+
+// followSelfLink returns the destination of `u` if it is a shortened url of the shortener (on any of its hosts).
+// A shortened url that does not exist is rejected, since it may be created later and form a loop.
+func (s *shortner) followSelfLink(ctx context.Context, u string) (string, bool, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", false, newValidationError("invalid url %s: %w", u, err)
+	}
+
+	hostCtx, err := s.contextForHost(ctx, pu.Host)
+	if err != nil {
+		var ferr *ForeignHostError
+		if errors.As(err, &ferr) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	// Other paths of the host (e.g. a landing page) are not shortened urls.
+	if s.pathPrefix != "" && !strings.HasPrefix(pu.Path, s.pathPrefix+"/") {
+		return "", false, nil
+	}
+
+	id := strings.Trim(strings.TrimPrefix(pu.Path, s.pathPrefix), "/")
+	if validateId(id) != nil {
+		return "", false, nil
+	}
+
+	ns, err := s.namespace(hostCtx)
+	if err != nil {
+		return "", false, err
+	}
+
+	rec, state, err := ns.store.GetUrlState(hostCtx, id)
+	if err != nil {
+		return "", false, err
+	}
+	if state == LinkMissing || state == LinkTombstoned {
+		return "", false, &BlockedUrlError{Url: u, Reason: "the url is a shortened url that does not exist"}
+	}
+	if state != LinkActive {
+		return "", false, &BlockedUrlError{Url: u, Reason: "the url is a shortened url that is " + state.String()}
+	}
+
+	// The destination of a password-protected shortened url must not be copied to an unprotected one.
+	if rec.PasswordHash != "" {
+		return "", false, &BlockedUrlError{Url: u, Reason: "the url is a password-protected shortened url"}
+	}
+	if rec.ActivateAt != nil && time.Now().Unix() < *rec.ActivateAt {
+		return "", false, &BlockedUrlError{Url: u, Reason: "the url is a shortened url that is not active yet"}
+	}
+	if rec.RemainingClicks != nil {
+		return "", false, &BlockedUrlError{Url: u, Reason: "the url is a click-limited shortened url"}
+	}
+
+	return rec.Url, true, nil
+}
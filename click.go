@@ -0,0 +1,192 @@
+package short
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RequestMetadata holds information about the request that resolves a shortened url.
+// It is used to build the click events that are delivered to the click sinks.
+type RequestMetadata struct {
+	Referrer       string
+	UserAgent      string
+	ClientIP       string
+	AcceptLanguage string
+	// Method is the http method of the request (empty if the request is not an http request).
+	// `HEAD` requests do not take the clicks of a click-limited url.
+	Method string
+}
+
+// ClickEvent is a single resolve of a shortened url.
+type ClickEvent struct {
+	Id   string `json:"id" bson:"id"`
+	Host string `json:"host" bson:"host"`
+	// Tenant is the tenant of the shortened url (empty for the default namespace, see `ContextWithTenant`).
+	Tenant         string    `json:"tenant,omitempty" bson:"tenant,omitempty"`
+	Time           time.Time `json:"time" bson:"time"`
+	Referrer       string    `json:"referrer,omitempty" bson:"referrer,omitempty"`
+	UserAgent      string    `json:"userAgent,omitempty" bson:"userAgent,omitempty"`
+	ClientIP       string    `json:"clientIp,omitempty" bson:"clientIp,omitempty"`
+	AcceptLanguage string    `json:"acceptLanguage,omitempty" bson:"acceptLanguage,omitempty"`
+	// Bot is true if the click was made by a bot (see `BotClassifier`).
+	Bot bool `json:"bot" bson:"bot"`
+	// Country, Region and City are set when a GeoResolver is configured (see `GeoLocation`).
+	Country string `json:"country,omitempty" bson:"country,omitempty"`
+	Region  string `json:"region,omitempty" bson:"region,omitempty"`
+	City    string `json:"city,omitempty" bson:"city,omitempty"`
+
+	// visitorHash is a keyed hash of the client ip and the user agent (0 if both are unknown).
+	// It is used to estimate the number of unique visitors.
+	visitorHash uint64
+}
+
+// ClickSink receives click events.
+// Events are delivered asynchronously and in batches, Write is never called on the resolve path.
+// The context of Write expires after 10 seconds, a sink must not block past it.
+type ClickSink interface {
+	// Write delivers a batch of click events.
+	Write(ctx context.Context, events []ClickEvent) error
+	// Close releases any resources held by the sink.
+	Close() error
+}
+
+const (
+	defaultClickBatchSize     = 100
+	defaultClickFlushInterval = time.Second
+	clickQueueSize            = 10000
+	// clickWriteTimeout bounds the delivery of a batch to a sink, a hung sink must not stop the delivery to the others.
+	clickWriteTimeout = 10 * time.Second
+)
+
+// clickDispatcher queues click events and delivers them in batches to the sinks.
+type clickDispatcher struct {
+	sinks         []ClickSink
+	geoResolver   GeoResolver
+	batchSize     int
+	flushInterval time.Duration
+
+	events chan ClickEvent
+	done   chan struct{}
+	// start starts the delivery goroutine on the first event, a shortener that never resolves does not start it.
+	start sync.Once
+	// dropped is the number of events dropped because the queue was full.
+	dropped uint64
+
+	lock   sync.RWMutex
+	closed bool
+}
+
+func newClickDispatcher(sinks []ClickSink, geoResolver GeoResolver, batchSize int, flushInterval time.Duration) *clickDispatcher {
+	d := clickDispatcher{
+		sinks:         sinks,
+		geoResolver:   geoResolver,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		events:        make(chan ClickEvent, clickQueueSize),
+		done:          make(chan struct{}),
+	}
+
+	return &d
+}
+
+// dispatch queues an event without blocking.
+// If the queue is full the event is dropped, analytics must never add latency to redirects.
+func (d *clickDispatcher) dispatch(event ClickEvent) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	if d.closed {
+		return
+	}
+
+	d.start.Do(func() { go d.run() })
+
+	select {
+	case d.events <- event:
+	default:
+		atomic.AddUint64(&d.dropped, 1)
+	}
+}
+
+// droppedEvents returns the number of events dropped because the queue was full.
+func (d *clickDispatcher) droppedEvents() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+func (d *clickDispatcher) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]ClickEvent, 0, d.batchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		for _, sink := range d.sinks {
+			// Delivery errors are ignored, a failing sink must not affect the other sinks or the redirects.
+			ctx, cancel := context.WithTimeout(context.Background(), clickWriteTimeout)
+			_ = sink.Write(ctx, batch)
+			cancel()
+		}
+		batch = make([]ClickEvent, 0, d.batchSize)
+	}
+
+	for {
+		select {
+		case event, ok := <-d.events:
+			if !ok {
+				flush()
+				return
+			}
+			// The events are enriched here (and not on the resolve path) so enrichment never adds latency to redirects.
+			if d.geoResolver != nil {
+				enrichClickEvent(d.geoResolver, &event)
+			}
+			batch = append(batch, event)
+			if len(batch) >= d.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// close flushes the queued events and closes the sinks and the geo resolver.
+func (d *clickDispatcher) close(ctx context.Context) error {
+	d.lock.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.events)
+	}
+	d.lock.Unlock()
+
+	// If the delivery goroutine was never started there is nothing to deliver.
+	d.start.Do(func() { close(d.done) })
+
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var err error
+	for _, sink := range d.sinks {
+		if cerr := sink.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	if d.geoResolver != nil {
+		if cerr := d.geoResolver.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
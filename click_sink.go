@@ -0,0 +1,130 @@
+package short
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const clickEventsCollectionName = "click_events"
+
+type mongoClickSink struct {
+	collection *mongo.Collection
+}
+
+// NewMongoClickSink creates a click sink that stores the click events in MongoDB.
+// The events are stored in the `click_events` collection of the database in `mongoUri`.
+func NewMongoClickSink(mongoUri string) (ClickSink, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	database, err := getMongoDatabase(ctx, mongoUri)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := database.Collection(clickEventsCollectionName)
+	// Index the collection.
+	if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "host", Value: 1}, {Key: "id", Value: 1}, {Key: "time", Value: 1}},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create an index for collection %s: %w", clickEventsCollectionName, err)
+	}
+
+	return &mongoClickSink{collection: collection}, nil
+}
+
+func (s *mongoClickSink) Write(ctx context.Context, events []ClickEvent) error {
+	documents := make([]interface{}, len(events))
+	for i := range events {
+		documents[i] = &events[i]
+	}
+
+	if _, err := s.collection.InsertMany(ctx, documents, nil); err != nil {
+		return fmt.Errorf("failed to insert click events: %w", err)
+	}
+
+	return nil
+}
+
+func (s *mongoClickSink) Close() error {
+	return nil
+}
+
+type fileClickSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFileClickSink creates a click sink that appends the click events to a JSONL file (one JSON event per line).
+// The file is created if it does not exist.
+func NewFileClickSink(path string) (ClickSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	return &fileClickSink{file: f}, nil
+}
+
+func (s *fileClickSink) Write(ctx context.Context, events []ClickEvent) error {
+	var buf []byte
+	for i := range events {
+		line, err := json.Marshal(&events[i])
+		if err != nil {
+			return fmt.Errorf("failed to marshal a click event: %w", err)
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.file.Write(buf); err != nil {
+		return fmt.Errorf("failed to write click events to %s: %w", s.file.Name(), err)
+	}
+
+	return nil
+}
+
+func (s *fileClickSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.file.Close()
+}
+
+// storeClickSink adds the click events to the rollups of the store of their tenant or domain.
+type storeClickSink struct {
+	stores func(event ClickEvent) (Store, error)
+}
+
+func (s *storeClickSink) Write(ctx context.Context, events []ClickEvent) error {
+	byStore := map[Store][]ClickEvent{}
+	for _, event := range events {
+		store, err := s.stores(event)
+		if err != nil {
+			return err
+		}
+		byStore[store] = append(byStore[store], event)
+	}
+
+	for store, storeEvents := range byStore {
+		if err := store.RecordClicks(ctx, storeEvents); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *storeClickSink) Close() error {
+	return nil
+}
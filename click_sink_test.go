@@ -0,0 +1,66 @@
+package short
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFileClickSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clicks.jsonl")
+
+	sink, err := NewFileClickSink(path)
+	require.Nil(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	err = sink.Write(context.Background(), []ClickEvent{
+		{Id: "id1", Host: "short.com", Time: now, UserAgent: "curl/7.0"},
+		{Id: "id2", Host: "short.com", Time: now, Referrer: "https://ref.com"},
+	})
+	require.Nil(t, err)
+	require.Nil(t, sink.Close())
+
+	f, err := os.Open(path)
+	require.Nil(t, err)
+	defer f.Close()
+
+	var events []ClickEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event ClickEvent
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+
+	require.Len(t, events, 2)
+	require.Equal(t, "id1", events[0].Id)
+	require.Equal(t, "curl/7.0", events[0].UserAgent)
+	require.True(t, now.Equal(events[0].Time))
+	require.Equal(t, "https://ref.com", events[1].Referrer)
+}
+
+func TestMongoClickSink(t *testing.T) {
+	uri := getRandomMongoURIForTesting()
+
+	sink, err := NewMongoClickSink(uri)
+	require.Nil(t, err)
+
+	err = sink.Write(context.Background(), []ClickEvent{
+		{Id: "id1", Host: "short.com", Time: time.Now()},
+		{Id: "id1", Host: "short.com", Time: time.Now()},
+	})
+	require.Nil(t, err)
+	require.Nil(t, sink.Close())
+
+	database := mongoDbClientMap[uri].Database(getDatabaseNameForTesting(t, uri))
+	count, err := database.Collection(clickEventsCollectionName).CountDocuments(context.Background(), bson.M{"id": "id1"})
+	require.Nil(t, err)
+	require.Equal(t, int64(2), count)
+}
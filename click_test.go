@@ -0,0 +1,162 @@
+package short
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memoryClickSink struct {
+	lock    sync.Mutex
+	batches [][]ClickEvent
+	closed  bool
+}
+
+func (s *memoryClickSink) Write(ctx context.Context, events []ClickEvent) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *memoryClickSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	return nil
+}
+
+func (s *memoryClickSink) events() []ClickEvent {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var events []ClickEvent
+	for _, batch := range s.batches {
+		events = append(events, batch...)
+	}
+	return events
+}
+
+// funcClickSink is a ClickSink that writes the click events with a function.
+type funcClickSink struct {
+	write func(ctx context.Context, events []ClickEvent) error
+}
+
+func (s *funcClickSink) Write(ctx context.Context, events []ClickEvent) error {
+	return s.write(ctx, events)
+}
+
+func (s *funcClickSink) Close() error {
+	return nil
+}
+
+type staticGeoResolver struct {
+	closed bool
+}
+
+func (r *staticGeoResolver) Resolve(ip net.IP) (*GeoLocation, error) {
+	return &GeoLocation{Country: "US", Region: "US-NY", City: "New York"}, nil
+}
+
+func (r *staticGeoResolver) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestClickDispatcher(t *testing.T) {
+	t.Run("batch size", func(t *testing.T) {
+		sink := &memoryClickSink{}
+		d := newClickDispatcher([]ClickSink{sink}, nil, 2, time.Hour)
+
+		for i := 0; i < 4; i++ {
+			d.dispatch(ClickEvent{Id: "id"})
+		}
+
+		require.Eventually(t, func() bool { return len(sink.events()) == 4 }, time.Second, 10*time.Millisecond)
+		require.Nil(t, d.close(context.Background()))
+		require.Len(t, sink.batches, 2)
+		require.True(t, sink.closed)
+	})
+
+	t.Run("flush interval", func(t *testing.T) {
+		sink := &memoryClickSink{}
+		d := newClickDispatcher([]ClickSink{sink}, nil, 100, 10*time.Millisecond)
+
+		d.dispatch(ClickEvent{Id: "id"})
+
+		require.Eventually(t, func() bool { return len(sink.events()) == 1 }, time.Second, 10*time.Millisecond)
+		require.Nil(t, d.close(context.Background()))
+	})
+
+	t.Run("close flushes", func(t *testing.T) {
+		sink1 := &memoryClickSink{}
+		sink2 := &memoryClickSink{}
+		d := newClickDispatcher([]ClickSink{sink1, sink2}, nil, 100, time.Hour)
+
+		d.dispatch(ClickEvent{Id: "id1"})
+		d.dispatch(ClickEvent{Id: "id2"})
+
+		require.Nil(t, d.close(context.Background()))
+		require.Len(t, sink1.events(), 2)
+		require.Len(t, sink2.events(), 2)
+
+		// Dispatching after close is a no-op.
+		d.dispatch(ClickEvent{Id: "id3"})
+		require.Len(t, sink1.events(), 2)
+	})
+
+	t.Run("close without events", func(t *testing.T) {
+		sink := &memoryClickSink{}
+		d := newClickDispatcher([]ClickSink{sink}, nil, 100, time.Hour)
+
+		require.Nil(t, d.close(context.Background()))
+		require.True(t, sink.closed)
+		require.Empty(t, sink.events())
+	})
+
+	t.Run("bounded writes", func(t *testing.T) {
+		release := make(chan struct{})
+		var deadline bool
+		sink := &funcClickSink{write: func(ctx context.Context, events []ClickEvent) error {
+			_, deadline = ctx.Deadline()
+			<-release
+			return nil
+		}}
+		d := newClickDispatcher([]ClickSink{sink}, nil, 1, time.Hour)
+
+		// The sink blocks the first batch, the queue fills up and the following events are dropped.
+		for i := 0; i < clickQueueSize+10; i++ {
+			d.dispatch(ClickEvent{Id: "id"})
+		}
+		require.Greater(t, d.droppedEvents(), uint64(0))
+
+		close(release)
+		require.Nil(t, d.close(context.Background()))
+		require.True(t, deadline)
+	})
+
+	t.Run("geo enrichment", func(t *testing.T) {
+		sink := &memoryClickSink{}
+		resolver := &staticGeoResolver{}
+		d := newClickDispatcher([]ClickSink{sink}, resolver, 100, time.Hour)
+
+		d.dispatch(ClickEvent{Id: "id1", ClientIP: "1.2.3.4"})
+		d.dispatch(ClickEvent{Id: "id2"})
+
+		require.Nil(t, d.close(context.Background()))
+		require.True(t, resolver.closed)
+
+		events := sink.events()
+		require.Len(t, events, 2)
+		require.Equal(t, "US", events[0].Country)
+		require.Equal(t, "US-NY", events[0].Region)
+		require.Equal(t, "New York", events[0].City)
+		require.Empty(t, events[1].Country)
+	})
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TomerHeber/go-short-url"
+	"github.com/TomerHeber/go-short-url/api"
+)
+
+const importBatchSize = 100
+
+// link is a line of the export and import files.
+type link struct {
+	Id             string     `json:"id"`
+	Url            string     `json:"url"`
+	ExpirationDate *time.Time `json:"expirationDate,omitempty"`
+	RedirectType   int        `json:"redirectType,omitempty"`
+	MaxClicks      *int64     `json:"maxClicks,omitempty"`
+	ActivationDate *time.Time `json:"activationDate,omitempty"`
+	// SlidingTtl is the ttl of a sliding expiration (e.g. 24h0m0s).
+	SlidingTtl string `json:"slidingTtl,omitempty"`
+}
+
+func createCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlagSet("create")
+	alias := flags.String("alias", "", "use `alias` instead of a random id")
+	override := flags.Bool("override", false, "replace an existing shortened url with the same alias")
+	expiration := flags.String("expiration", "", "the expiration date in RFC 3339 format (e.g. 2022-12-31T23:59:59Z)")
+	redirectType := flags.Int("redirect-type", 0, "the redirect status code (301, 302, 307 or 308)")
+	password := flags.String("password", "", "protect the shortened url with `password`")
+	ttl := flags.Duration("ttl", 0, "expire the shortened url `duration` after it is created (e.g. 24h)")
+	sliding := flags.Bool("sliding", false, "extend the expiration to the ttl on each resolve")
+	activation := flags.String("activation", "", "the activation date in RFC 3339 format, the shortened url does not resolve before it")
+	maxClicks := flags.Int64("max-clicks", 0, "the number of resolves before the shortened url self-destructs (e.g. 1 for a one-time link)")
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	config := short.DefaultUrlConfig()
+	if *alias != "" {
+		config = config.WithAlias(*alias).WithOverrideAlias(*override)
+	} else if *override {
+		return &usageError{err: errors.New("-override requires -alias")}
+	}
+	if *expiration != "" {
+		expirationDate, err := time.Parse(time.RFC3339, *expiration)
+		if err != nil {
+			return &usageError{err: fmt.Errorf("invalid expiration date: %w", err)}
+		}
+		config = config.WithExpirationDate(expirationDate)
+	}
+	if *ttl != 0 {
+		config = config.WithTtl(*ttl)
+	}
+	if *sliding {
+		config = config.WithSlidingExpiration(true)
+	}
+	if *activation != "" {
+		activationDate, err := time.Parse(time.RFC3339, *activation)
+		if err != nil {
+			return &usageError{err: fmt.Errorf("invalid activation date: %w", err)}
+		}
+		config = config.WithActivationDate(activationDate)
+	}
+	if *redirectType != 0 {
+		config = config.WithRedirectType(*redirectType)
+	}
+	if *password != "" {
+		config = config.WithPassword(*password)
+	}
+	if *maxClicks != 0 {
+		config = config.WithMaxClicks(*maxClicks)
+	}
+
+	surl, err := c.shortener.CreateShortenedUrl(ctx, flags.Arg(0), config)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout, surl.GetUrl())
+	return nil
+}
+
+func resolveCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlagSet("resolve")
+	password := flags.String("password", "", "the `password` of a password-protected shortened url")
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	if *password != "" {
+		ctx = short.ContextWithPassword(ctx, *password)
+	}
+
+	var url string
+	var err error
+	if arg := flags.Arg(0); strings.Contains(arg, "://") {
+		url, err = c.shortener.GetUrlFromShortenedUrl(ctx, arg)
+	} else {
+		url, err = c.shortener.GetUrlFromShortenedUrlId(ctx, arg)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout, url)
+	return nil
+}
+
+func expireCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlagSet("expire")
+	never := flags.Bool("never", false, "remove the expiration date")
+	if err := parseFlags(flags, args, 1, 2); err != nil {
+		return err
+	}
+
+	var expiration *time.Time
+	if *never {
+		if flags.NArg() != 1 {
+			return &usageError{err: errors.New("-never does not take a date")}
+		}
+	} else {
+		if flags.NArg() != 2 {
+			return &usageError{err: errors.New("missing the expiration date")}
+		}
+		expirationDate, err := time.Parse(time.RFC3339, flags.Arg(1))
+		if err != nil {
+			return &usageError{err: fmt.Errorf("invalid expiration date: %w", err)}
+		}
+		expiration = &expirationDate
+	}
+
+	return c.shortener.SetExpiration(ctx, flags.Arg(0), expiration)
+}
+
+func deleteCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlagSet("delete")
+	if err := parseFlags(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	for _, id := range flags.Args() {
+		if err := c.shortener.DeleteShortenedUrl(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func listCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlagSet("list")
+	limit := flags.Int("limit", 100, "the maximum number of shortened urls")
+	cursor := flags.String("cursor", "", "list the shortened urls after the id `cursor`")
+	if err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	res, err := c.shortener.ListShortenedUrls(ctx, short.ListOptions{Limit: *limit, Cursor: *cursor})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSHORT URL\tURL\tEXPIRATION")
+	for _, info := range res.ShortenedUrls {
+		expiration := "-"
+		if info.ExpirationDate != nil {
+			expiration = info.ExpirationDate.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", info.Id, info.ShortUrl, info.Url, expiration)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if res.NextCursor != "" {
+		fmt.Fprintf(c.stderr, "more shortened urls: short list -cursor %s\n", res.NextCursor)
+	}
+
+	return nil
+}
+
+func statsCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlagSet("stats")
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	stats, err := c.shortener.GetStats(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	lastAccessed := "never"
+	if stats.LastAccessed != nil {
+		lastAccessed = stats.LastAccessed.UTC().Format(time.RFC3339)
+	}
+
+	fmt.Fprintf(c.stdout, "clicks: %d\n", stats.Clicks)
+	fmt.Fprintf(c.stdout, "bot clicks: %d\n", stats.BotClicks)
+	fmt.Fprintf(c.stdout, "unique visitors: %d\n", stats.UniqueVisitors)
+	fmt.Fprintf(c.stdout, "last accessed: %s\n", lastAccessed)
+	return nil
+}
+
+func importCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlagSet("import")
+	override := flags.Bool("override", false, "replace existing shortened urls with the same ids")
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	r := c.stdin
+	if name := flags.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var imported, failed int
+	var items []short.BatchItem
+	var lines []int
+
+	flush := func() {
+		for i, res := range c.shortener.CreateShortenedUrls(ctx, items) {
+			if res.Err != nil {
+				failed++
+				fmt.Fprintf(c.stderr, "line %d: %v\n", lines[i], res.Err)
+				continue
+			}
+			imported++
+		}
+		items, lines = items[:0], lines[:0]
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var l link
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			failed++
+			fmt.Fprintf(c.stderr, "line %d: %v\n", line, err)
+			continue
+		}
+
+		config := short.DefaultUrlConfig().WithAlias(l.Id).WithOverrideAlias(*override)
+		if l.SlidingTtl != "" {
+			// A sliding expiration restarts at the import, like on a resolve.
+			ttl, err := time.ParseDuration(l.SlidingTtl)
+			if err != nil {
+				failed++
+				fmt.Fprintf(c.stderr, "line %d: invalid sliding ttl: %v\n", line, err)
+				continue
+			}
+			config = config.WithTtl(ttl).WithSlidingExpiration(true)
+		} else if l.ExpirationDate != nil {
+			config = config.WithExpirationDate(*l.ExpirationDate)
+		}
+		if l.RedirectType != 0 {
+			config = config.WithRedirectType(l.RedirectType)
+		}
+		if l.MaxClicks != nil {
+			config = config.WithMaxClicks(*l.MaxClicks)
+		}
+		if l.ActivationDate != nil {
+			config = config.WithActivationDate(*l.ActivationDate)
+		}
+
+		items = append(items, short.BatchItem{Url: l.Url, Config: config})
+		lines = append(lines, line)
+		if len(items) == importBatchSize {
+			flush()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	flush()
+
+	fmt.Fprintf(c.stdout, "imported %d shortened urls\n", imported)
+	if failed > 0 {
+		return fmt.Errorf("failed to import %d shortened urls", failed)
+	}
+
+	return nil
+}
+
+func exportCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlagSet("export")
+	skipProtected := flags.Bool("skip-protected", false, "leave out the password-protected shortened urls instead of failing")
+	if err := parseFlags(flags, args, 0, 1); err != nil {
+		return err
+	}
+
+	var w io.Writer = c.stdout
+	if name := flags.Arg(0); name != "" && name != "-" {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	var cursor string
+	for {
+		res, err := c.shortener.ListShortenedUrls(ctx, short.ListOptions{Limit: 1000, Cursor: cursor})
+		if err != nil {
+			return err
+		}
+
+		for _, info := range res.ShortenedUrls {
+			// Click-limited shortened urls that are used up no longer resolve.
+			if info.State == short.LinkDisabled {
+				continue
+			}
+			// Only the hash of the password is stored, so an imported copy would not be protected.
+			if info.PasswordProtected {
+				if !*skipProtected {
+					return fmt.Errorf("the shortened url %s is password-protected and cannot be exported (use -skip-protected to leave it out)", info.Id)
+				}
+				fmt.Fprintf(c.stderr, "skipped the password-protected shortened url %s\n", info.Id)
+				continue
+			}
+
+			l := &link{
+				Id:             info.Id,
+				Url:            info.Url,
+				ExpirationDate: info.ExpirationDate,
+				RedirectType:   info.RedirectType,
+				MaxClicks:      info.RemainingClicks,
+				ActivationDate: info.ActivationDate,
+			}
+			if info.SlidingTtl != 0 {
+				l.SlidingTtl = info.SlidingTtl.String()
+			}
+			if err := enc.Encode(l); err != nil {
+				return err
+			}
+		}
+
+		if res.NextCursor == "" {
+			break
+		}
+		cursor = res.NextCursor
+	}
+
+	return bw.Flush()
+}
+
+func keysCommand(ctx context.Context, c *cli, args []string) error {
+	if len(args) == 0 {
+		return &usageError{err: errors.New("missing keys command")}
+	}
+
+	switch args[0] {
+	case "create":
+		flags := c.newFlagSet("keys create")
+		name := flags.String("name", "", "a name that describes the api key")
+		scopes := flags.String("scopes", "", "comma separated scopes (create, read-stats or admin)")
+		if err := parseFlags(flags, args[1:], 0, 0); err != nil {
+			return err
+		}
+		if *scopes == "" {
+			return &usageError{err: errors.New("-scopes is required")}
+		}
+
+		var keyScopes []short.Scope
+		for _, scope := range strings.Split(*scopes, ",") {
+			keyScopes = append(keyScopes, short.Scope(strings.TrimSpace(scope)))
+		}
+
+		key, apiKey, err := c.shortener.CreateApiKey(ctx, *name, keyScopes...)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.stderr, "created api key %s (the key cannot be retrieved later)\n", apiKey.Id)
+		fmt.Fprintln(c.stdout, key)
+	case "list":
+		flags := c.newFlagSet("keys list")
+		if err := parseFlags(flags, args[1:], 0, 0); err != nil {
+			return err
+		}
+
+		keys, err := c.shortener.ListApiKeys(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED")
+		for _, key := range keys {
+			scopes := make([]string, len(key.Scopes))
+			for i, scope := range key.Scopes {
+				scopes[i] = string(scope)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key.Id, key.Name, strings.Join(scopes, ","), key.CreatedAt.UTC().Format(time.RFC3339))
+		}
+		return w.Flush()
+	case "revoke":
+		flags := c.newFlagSet("keys revoke")
+		if err := parseFlags(flags, args[1:], 1, -1); err != nil {
+			return err
+		}
+
+		for _, id := range flags.Args() {
+			if err := c.shortener.RevokeApiKey(ctx, id); err != nil {
+				return err
+			}
+		}
+	default:
+		return &usageError{err: fmt.Errorf("unknown keys command %q", args[0])}
+	}
+
+	return nil
+}
+
+func tenantsCommand(ctx context.Context, c *cli, args []string) error {
+	if len(args) == 0 {
+		return &usageError{err: errors.New("missing tenants command")}
+	}
+
+	switch args[0] {
+	case "create", "update":
+		flags := c.newFlagSet("tenants " + args[0])
+		name := flags.String("name", "", "the name of the tenant")
+		domains := flags.String("domains", "", "comma separated domains of the shortened urls of the tenant")
+		maxLinks := flags.Int64("max-links", 0, "the maximum number of shortened urls (0 is unlimited)")
+		maxKeys := flags.Int64("max-keys", 0, "the maximum number of api keys (0 is unlimited)")
+		if err := parseFlags(flags, args[1:], 1, 1); err != nil {
+			return err
+		}
+
+		tenant := short.Tenant{
+			Id:    flags.Arg(0),
+			Name:  *name,
+			Quota: short.Quota{MaxLinks: *maxLinks, MaxApiKeys: *maxKeys},
+		}
+		if *domains != "" {
+			for _, domain := range strings.Split(*domains, ",") {
+				tenant.Domains = append(tenant.Domains, strings.TrimSpace(domain))
+			}
+		}
+
+		var err error
+		if args[0] == "create" {
+			_, err = c.shortener.CreateTenant(ctx, tenant)
+		} else {
+			_, err = c.shortener.UpdateTenant(ctx, tenant)
+		}
+		return err
+	case "list":
+		flags := c.newFlagSet("tenants list")
+		if err := parseFlags(flags, args[1:], 0, 0); err != nil {
+			return err
+		}
+
+		tenants, err := c.shortener.ListTenants(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tDOMAINS\tMAX LINKS\tMAX KEYS\tCREATED")
+		for _, tenant := range tenants {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", tenant.Id, tenant.Name, strings.Join(tenant.Domains, ","),
+				tenant.Quota.MaxLinks, tenant.Quota.MaxApiKeys, tenant.CreatedAt.UTC().Format(time.RFC3339))
+		}
+		return w.Flush()
+	case "delete":
+		flags := c.newFlagSet("tenants delete")
+		if err := parseFlags(flags, args[1:], 1, -1); err != nil {
+			return err
+		}
+
+		for _, id := range flags.Args() {
+			if err := c.shortener.DeleteTenant(ctx, id); err != nil {
+				return err
+			}
+		}
+	default:
+		return &usageError{err: fmt.Errorf("unknown tenants command %q", args[0])}
+	}
+
+	return nil
+}
+
+func serveCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlagSet("serve")
+	addr := flags.String("addr", ":8080", "the address to listen on")
+	withApi := flags.Bool("api", false, "serve the management API under /api/ (requires api keys, see the keys command)")
+	if err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", short.Handler(c.shortener, short.HandlerOptions{}))
+	if *withApi {
+		mux.Handle("/api/", http.StripPrefix("/api", api.RequireApiKey(c.shortener)(api.NewHandler(c.shortener, api.Options{}))))
+	}
+
+	server := &http.Server{Addr: *addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	fmt.Fprintf(c.stderr, "listening on %s\n", *addr)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return server.Shutdown(shutdownCtx)
+}
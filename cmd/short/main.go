@@ -0,0 +1,212 @@
+// Command short manages shortened urls from the command line.
+//
+// Usage:
+//
+//	short [-mongo-uri uri] [-host host | -base-url url] [-domains domain,...] [-domain domain] [-tenant id] <command> [flags] [args]
+//
+// Commands:
+//
+//	create   create a shortened url (flags: -alias, -override, -expiration, -ttl, -sliding, -activation, -redirect-type, -password, -max-clicks)
+//	resolve  print the original url of a shortened url or id (flags: -password)
+//	expire   set or remove (-never) the expiration date of a shortened url
+//	delete   delete shortened urls
+//	list     list shortened urls
+//	stats    print the click statistics of a shortened url
+//	import   create shortened urls from a JSON lines file (see export)
+//	export   write all the shortened urls as JSON lines (except the password-protected ones)
+//	keys     create, list and revoke the api keys of the management API
+//	tenants  create, list, update and delete tenants
+//	serve    run the redirect server (flags: -addr, -api)
+//
+// The mongo uri, the host, the base url, the domains, the domain and the tenant default to the SHORT_MONGO_URI, SHORT_HOST,
+// SHORT_BASE_URL, SHORT_DOMAINS, SHORT_DOMAIN and SHORT_TENANT environment variables.
+// With a domain (one of the host and the domains) the commands run on the shortened urls of the domain.
+// With a tenant the commands manage the shortened urls and the api keys of the tenant.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/TomerHeber/go-short-url"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"create":  {usage: "create [flags] <url>", run: createCommand},
+	"resolve": {usage: "resolve <id|short url>", run: resolveCommand},
+	"expire":  {usage: "expire <id> <date> | expire -never <id>", run: expireCommand},
+	"delete":  {usage: "delete <id>...", run: deleteCommand},
+	"list":    {usage: "list [-limit n] [-cursor id]", run: listCommand},
+	"stats":   {usage: "stats <id>", run: statsCommand},
+	"import":  {usage: "import [-override] <file|->", run: importCommand},
+	"export":  {usage: "export [-skip-protected] [file]", run: exportCommand},
+	"keys":    {usage: "keys create [-name name] -scopes scope,... | keys list | keys revoke <id>", run: keysCommand},
+	"tenants": {usage: "tenants create|update [-name name] [-domains domain,...] [-max-links n] [-max-keys n] <id> | tenants list | tenants delete <id>", run: tenantsCommand},
+	"serve":   {usage: "serve [-addr addr] [-api]", run: serveCommand},
+}
+
+// usageError is returned when the command line arguments are invalid.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+type cli struct {
+	stdin     io.Reader
+	stdout    io.Writer
+	stderr    io.Writer
+	shortener short.Shortener
+}
+
+// shortenerOptions are the global flags that configure the shortener.
+type shortenerOptions struct {
+	mongoUri string
+	host     string
+	baseUrl  string
+	domains  []string
+}
+
+// newShortener is a variable so tests can replace the shortener.
+var newShortener = func(options shortenerOptions) (short.Shortener, error) {
+	config := short.DefaultConfig()
+	if options.mongoUri != "" {
+		config = config.WithMongoUri(options.mongoUri)
+	}
+	if options.host != "" {
+		config = config.WithHost(options.host)
+	}
+	if options.baseUrl != "" {
+		config = config.WithBaseUrl(options.baseUrl)
+	}
+	if len(options.domains) > 0 {
+		config = config.WithDomains(options.domains...)
+	}
+	return short.NewShortener(config)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("short", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	mongoUri := flags.String("mongo-uri", os.Getenv("SHORT_MONGO_URI"), "the URI for connecting to Mongo")
+	host := flags.String("host", os.Getenv("SHORT_HOST"), "the host of the shortened urls")
+	baseUrl := flags.String("base-url", os.Getenv("SHORT_BASE_URL"), "the scheme, host and path prefix of the shortened urls (replaces -host)")
+	domains := flags.String("domains", os.Getenv("SHORT_DOMAINS"), "comma separated additional hosts of the shortened urls")
+	domain := flags.String("domain", os.Getenv("SHORT_DOMAIN"), "the domain of the shortened urls (the host if not set)")
+	tenant := flags.String("tenant", os.Getenv("SHORT_TENANT"), "the tenant of the shortened urls and api keys")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: short [-mongo-uri uri] [-host host | -base-url url] [-domains domain,...] [-domain domain] [-tenant id] <command> [flags] [args]")
+		fmt.Fprintln(stderr, "\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(stderr, "  short", commands[name].usage)
+		}
+		fmt.Fprintln(stderr, "\nflags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "short: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+
+	options := shortenerOptions{mongoUri: *mongoUri, host: *host, baseUrl: *baseUrl}
+	if *domains != "" {
+		for _, d := range strings.Split(*domains, ",") {
+			options.domains = append(options.domains, strings.TrimSpace(d))
+		}
+	}
+
+	s, err := newShortener(options)
+	if err != nil {
+		fmt.Fprintln(stderr, "short:", err)
+		return 1
+	}
+
+	if *domain != "" {
+		ctx = short.ContextWithDomain(ctx, *domain)
+	}
+	if *tenant != "" {
+		ctx = short.ContextWithTenant(ctx, *tenant)
+	}
+
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr, shortener: s}
+	err = cmd.run(ctx, c, flags.Args()[1:])
+
+	if closeErr := s.Close(context.Background()); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		var uerr *usageError
+		if errors.As(err, &uerr) {
+			fmt.Fprintln(stderr, "short:", err)
+			fmt.Fprintln(stderr, "usage: short", cmd.usage)
+			return 2
+		}
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(stderr, "short:", err)
+		}
+		return 1
+	}
+
+	return 0
+}
+
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	return flags
+}
+
+// parseFlags parses the flags of a command and checks the number of the remaining arguments.
+func parseFlags(flags *flag.FlagSet, args []string, minArgs int, maxArgs int) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{err: err}
+	}
+
+	if flags.NArg() < minArgs || (maxArgs >= 0 && flags.NArg() > maxArgs) {
+		return &usageError{err: errors.New("wrong number of arguments")}
+	}
+
+	return nil
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TomerHeber/go-short-url"
+	"github.com/stretchr/testify/require"
+)
+
+type shortenedUrl string
+
+func (s shortenedUrl) GetUrl() string {
+	return string(s)
+}
+
+// memoryShortener is an in-memory Shortener with the subset of methods used by the commands.
+type memoryShortener struct {
+	short.Shortener
+	links   map[string]*short.ShortenedUrlInfo
+	apiKeys []*short.ApiKey
+	tenants []*short.Tenant
+	// lastTenant is the tenant of the context of the last call.
+	lastTenant string
+	nextId     int
+}
+
+func (s *memoryShortener) CreateShortenedUrl(ctx context.Context, url string, config ...short.UrlConfig) (short.ShortenedURL, error) {
+	if !strings.HasPrefix(url, "https://") {
+		return nil, &short.ValidationError{}
+	}
+	host := "short.com"
+	if domain := short.DomainFromContext(ctx); domain != "" {
+		host = domain
+	}
+	s.nextId++
+	id := fmt.Sprintf("id%d", s.nextId)
+	s.links[id] = &short.ShortenedUrlInfo{Id: id, ShortUrl: "https://" + host + "/" + id, Url: url, State: short.LinkActive}
+	return shortenedUrl("https://" + host + "/" + id), nil
+}
+
+func (s *memoryShortener) CreateShortenedUrls(ctx context.Context, items []short.BatchItem) []short.BatchResult {
+	results := make([]short.BatchResult, len(items))
+	for i, item := range items {
+		results[i].ShortenedUrl, results[i].Err = s.CreateShortenedUrl(ctx, item.Url, item.Config)
+	}
+	return results
+}
+
+func (s *memoryShortener) GetUrlFromShortenedUrlId(ctx context.Context, id string) (string, error) {
+	info, ok := s.links[id]
+	if !ok {
+		return "", &short.IdNotFoundError{}
+	}
+	return info.Url, nil
+}
+
+func (s *memoryShortener) ListShortenedUrls(ctx context.Context, options short.ListOptions) (*short.ListResult, error) {
+	ids := make([]string, 0, len(s.links))
+	for id := range s.links {
+		if id > options.Cursor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var result short.ListResult
+	if len(ids) > options.Limit {
+		ids = ids[:options.Limit]
+		result.NextCursor = ids[len(ids)-1]
+	}
+	for _, id := range ids {
+		result.ShortenedUrls = append(result.ShortenedUrls, s.links[id])
+	}
+	return &result, nil
+}
+
+func (s *memoryShortener) SetExpiration(ctx context.Context, id string, expiration *time.Time) error {
+	info, ok := s.links[id]
+	if !ok {
+		return &short.IdNotFoundError{}
+	}
+	info.ExpirationDate = expiration
+	return nil
+}
+
+func (s *memoryShortener) DeleteShortenedUrl(ctx context.Context, id string) error {
+	if _, ok := s.links[id]; !ok {
+		return &short.IdNotFoundError{}
+	}
+	delete(s.links, id)
+	return nil
+}
+
+func (s *memoryShortener) GetStats(ctx context.Context, id string) (*short.Stats, error) {
+	if _, ok := s.links[id]; !ok {
+		return nil, &short.IdNotFoundError{}
+	}
+	return &short.Stats{Clicks: 5, BotClicks: 1, UniqueVisitors: 3}, nil
+}
+
+func (s *memoryShortener) CreateApiKey(ctx context.Context, name string, scopes ...short.Scope) (string, *short.ApiKey, error) {
+	key := &short.ApiKey{Id: fmt.Sprintf("key%d", len(s.apiKeys)+1), Name: name, Scopes: scopes}
+	s.apiKeys = append(s.apiKeys, key)
+	return "sk_" + key.Id, key, nil
+}
+
+func (s *memoryShortener) ListApiKeys(ctx context.Context) ([]*short.ApiKey, error) {
+	s.lastTenant = short.TenantFromContext(ctx)
+	return s.apiKeys, nil
+}
+
+func (s *memoryShortener) CreateTenant(ctx context.Context, tenant short.Tenant) (*short.Tenant, error) {
+	s.tenants = append(s.tenants, &tenant)
+	return &tenant, nil
+}
+
+func (s *memoryShortener) UpdateTenant(ctx context.Context, tenant short.Tenant) (*short.Tenant, error) {
+	for i, t := range s.tenants {
+		if t.Id == tenant.Id {
+			s.tenants[i] = &tenant
+			return &tenant, nil
+		}
+	}
+	return nil, &short.TenantNotFoundError{}
+}
+
+func (s *memoryShortener) ListTenants(ctx context.Context) ([]*short.Tenant, error) {
+	return s.tenants, nil
+}
+
+func (s *memoryShortener) DeleteTenant(ctx context.Context, id string) error {
+	for i, t := range s.tenants {
+		if t.Id == id {
+			s.tenants = append(s.tenants[:i], s.tenants[i+1:]...)
+			return nil
+		}
+	}
+	return &short.TenantNotFoundError{}
+}
+
+func (s *memoryShortener) RevokeApiKey(ctx context.Context, id string) error {
+	for i, key := range s.apiKeys {
+		if key.Id == id {
+			s.apiKeys = append(s.apiKeys[:i], s.apiKeys[i+1:]...)
+			return nil
+		}
+	}
+	return &short.IdNotFoundError{}
+}
+
+func (s *memoryShortener) Close(ctx context.Context) error {
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	s := &memoryShortener{links: map[string]*short.ShortenedUrlInfo{}}
+	var options shortenerOptions
+	newShortener = func(o shortenerOptions) (short.Shortener, error) {
+		options = o
+		return s, nil
+	}
+
+	runCommand := func(stdin string, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	t.Run("usage", func(t *testing.T) {
+		code, _, stderr := runCommand("")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, "usage: short")
+
+		code, _, stderr = runCommand("", "unknown")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, `unknown command "unknown"`)
+
+		code, _, stderr = runCommand("", "create")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, "usage: short create")
+
+		code, _, stderr = runCommand("", "create", "-expiration", "tomorrow", "https://test.com")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, "invalid expiration date")
+	})
+
+	t.Run("create", func(t *testing.T) {
+		code, stdout, _ := runCommand("", "-host", "short.com", "create", "-alias", "abc", "-expiration", "2030-01-01T00:00:00Z", "https://test.com")
+		require.Equal(t, 0, code)
+		require.Equal(t, "https://short.com/id1\n", stdout)
+
+		code, _, stderr := runCommand("", "create", "invalid")
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "short: invalid argument")
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		code, stdout, _ := runCommand("", "resolve", "id1")
+		require.Equal(t, 0, code)
+		require.Equal(t, "https://test.com\n", stdout)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		code, stdout, _ := runCommand("", "stats", "id1")
+		require.Equal(t, 0, code)
+		require.Contains(t, stdout, "clicks: 5\n")
+		require.Contains(t, stdout, "unique visitors: 3\n")
+		require.Contains(t, stdout, "last accessed: never\n")
+	})
+
+	t.Run("import", func(t *testing.T) {
+		code, stdout, stderr := runCommand(`{"id":"a","url":"https://a.com"}
+
+{"id":"b","url":"invalid"}
+not json
+{"id":"c","url":"https://c.com","redirectType":308}
+`, "import", "-")
+		require.Equal(t, 1, code)
+		require.Equal(t, "imported 2 shortened urls\n", stdout)
+		require.Contains(t, stderr, "line 3: invalid argument")
+		require.Contains(t, stderr, "line 4: invalid character")
+		require.Contains(t, stderr, "failed to import 2 shortened urls")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		code, stdout, stderr := runCommand("", "list", "-limit", "2")
+		require.Equal(t, 0, code)
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		require.Len(t, lines, 3)
+		require.Contains(t, lines[0], "SHORT URL")
+		require.Contains(t, lines[1], "https://test.com")
+		require.Contains(t, stderr, "short list -cursor id2")
+	})
+
+	t.Run("export", func(t *testing.T) {
+		expirationDate := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		s.links["id1"].ExpirationDate = &expirationDate
+
+		file := filepath.Join(t.TempDir(), "links.jsonl")
+		code, _, _ := runCommand("", "export", file)
+		require.Equal(t, 0, code)
+
+		data, err := os.ReadFile(file)
+		require.Nil(t, err)
+		require.Equal(t, `{"id":"id1","url":"https://test.com","expirationDate":"2030-01-01T00:00:00Z"}
+{"id":"id2","url":"https://a.com"}
+{"id":"id3","url":"https://c.com"}
+`, string(data))
+
+		// The sliding ttl is exported, and password-protected shortened urls are not exported without their password.
+		s.links["id3"].SlidingTtl = 24 * time.Hour
+		s.links["id4"] = &short.ShortenedUrlInfo{Id: "id4", Url: "https://d.com", PasswordProtected: true}
+		defer delete(s.links, "id4")
+
+		code, _, stderr := runCommand("", "export", file)
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "id4 is password-protected")
+
+		code, _, stderr = runCommand("", "export", "-skip-protected", file)
+		require.Equal(t, 0, code)
+		require.Contains(t, stderr, "skipped the password-protected shortened url id4")
+
+		data, err = os.ReadFile(file)
+		require.Nil(t, err)
+		require.Equal(t, `{"id":"id1","url":"https://test.com","expirationDate":"2030-01-01T00:00:00Z"}
+{"id":"id2","url":"https://a.com"}
+{"id":"id3","url":"https://c.com","slidingTtl":"24h0m0s"}
+`, string(data))
+
+		code, _, stderr = runCommand(`{"id":"e","url":"https://e.com","slidingTtl":"forever"}
+`, "import", "-")
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "line 1: invalid sliding ttl")
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		code, _, _ := runCommand("", "expire", "id2", "2031-01-01T00:00:00Z")
+		require.Equal(t, 0, code)
+		require.Equal(t, time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC), *s.links["id2"].ExpirationDate)
+
+		code, _, _ = runCommand("", "expire", "-never", "id2")
+		require.Equal(t, 0, code)
+		require.Nil(t, s.links["id2"].ExpirationDate)
+
+		code, _, stderr := runCommand("", "expire", "id2")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, "missing the expiration date")
+
+		code, _, stderr = runCommand("", "expire", "id2", "tomorrow")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, "invalid expiration date")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		code, _, _ := runCommand("", "delete", "id1", "id2")
+		require.Equal(t, 0, code)
+		require.Len(t, s.links, 1)
+
+		code, _, stderr := runCommand("", "delete", "id1")
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "not found")
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		code, _, stderr := runCommand("", "keys", "create", "-name", "ci")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, "-scopes is required")
+
+		code, stdout, stderr := runCommand("", "keys", "create", "-name", "ci", "-scopes", "create, read-stats")
+		require.Equal(t, 0, code)
+		require.Equal(t, "sk_key1\n", stdout)
+		require.Contains(t, stderr, "created api key key1")
+		require.Equal(t, []short.Scope{short.ScopeCreate, short.ScopeReadStats}, s.apiKeys[0].Scopes)
+
+		code, stdout, _ = runCommand("", "keys", "list")
+		require.Equal(t, 0, code)
+		require.Contains(t, stdout, "create,read-stats")
+
+		code, _, _ = runCommand("", "keys", "revoke", "key1")
+		require.Equal(t, 0, code)
+		require.Empty(t, s.apiKeys)
+
+		code, _, stderr = runCommand("", "keys", "rotate")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, `unknown keys command "rotate"`)
+	})
+	t.Run("tenants", func(t *testing.T) {
+		code, _, _ := runCommand("", "tenants", "create", "-name", "Acme", "-domains", "go.acme.com, acme.link", "-max-links", "10", "acme")
+		require.Equal(t, 0, code)
+		require.Equal(t, &short.Tenant{
+			Id: "acme", Name: "Acme", Domains: []string{"go.acme.com", "acme.link"}, Quota: short.Quota{MaxLinks: 10},
+		}, s.tenants[0])
+
+		code, _, _ = runCommand("", "tenants", "update", "-max-keys", "2", "acme")
+		require.Equal(t, 0, code)
+		require.Equal(t, int64(2), s.tenants[0].Quota.MaxApiKeys)
+		require.Empty(t, s.tenants[0].Domains)
+
+		code, stdout, _ := runCommand("", "tenants", "list")
+		require.Equal(t, 0, code)
+		require.Contains(t, stdout, "acme")
+
+		code, _, _ = runCommand("", "-tenant", "acme", "keys", "list")
+		require.Equal(t, 0, code)
+		require.Equal(t, "acme", s.lastTenant)
+
+		code, _, _ = runCommand("", "tenants", "delete", "acme")
+		require.Equal(t, 0, code)
+		require.Empty(t, s.tenants)
+
+		code, _, stderr := runCommand("", "tenants", "create")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, "wrong number of arguments")
+	})
+	t.Run("domains", func(t *testing.T) {
+		code, stdout, _ := runCommand("", "-domains", "acme.link, eu.acme.link", "-domain", "eu.acme.link", "create", "https://test.com")
+		require.Equal(t, 0, code)
+		require.True(t, strings.HasPrefix(stdout, "https://eu.acme.link/"))
+		require.Equal(t, []string{"acme.link", "eu.acme.link"}, options.domains)
+
+		code, _, _ = runCommand("", "-base-url", "http://intranet-host/s", "list")
+		require.Equal(t, 0, code)
+		require.Equal(t, "http://intranet-host/s", options.baseUrl)
+	})
+}
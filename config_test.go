@@ -1,7 +1,9 @@
 package short
 
 import (
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -61,6 +63,22 @@ func TestConfig(t *testing.T) {
 			require.Nil(t, c.getConfig().err)
 			require.Equal(t, "myurl.com", c.getConfig().host)
 		})
+
+		t.Run("upper case", func(t *testing.T) {
+			c := DefaultConfig().WithHost("HTTPS://Short.Example:8443")
+			require.Nil(t, c.getConfig().err)
+			require.Equal(t, "short.example:8443", c.getConfig().host)
+		})
+
+		t.Run("empty host", func(t *testing.T) {
+			helperInvalid(t, "")
+			helperInvalid(t, "https://")
+		})
+
+		t.Run("invalid schema", func(t *testing.T) {
+			helperInvalid(t, "ftp://myurl.com")
+			helperInvalid(t, "mailto://myurl.com")
+		})
 	})
 
 	t.Run("WithMongoUri", func(t *testing.T) {
@@ -82,4 +100,143 @@ func TestConfig(t *testing.T) {
 			require.NotNil(t, c.getConfig().err)
 		})
 	})
+
+	t.Run("WithClickCounting", func(t *testing.T) {
+		t.Run("default", func(t *testing.T) {
+			c := DefaultConfig()
+			require.True(t, c.getConfig().clickCounting)
+		})
+
+		t.Run("disabled", func(t *testing.T) {
+			c := DefaultConfig().WithClickCounting(false)
+			require.False(t, c.getConfig().clickCounting)
+		})
+	})
+
+	t.Run("WithClickBatching", func(t *testing.T) {
+		t.Run("valid", func(t *testing.T) {
+			c := DefaultConfig().WithClickBatching(10, time.Minute)
+			require.Nil(t, c.getConfig().err)
+			require.Equal(t, 10, c.getConfig().clickBatchSize)
+			require.Equal(t, time.Minute, c.getConfig().clickFlushInterval)
+		})
+
+		t.Run("invalid", func(t *testing.T) {
+			c := DefaultConfig().WithClickBatching(0, time.Minute)
+			require.NotNil(t, c.getConfig().err)
+		})
+	})
+
+	t.Run("WithVisitorHashSalt", func(t *testing.T) {
+		c := DefaultConfig().WithVisitorHashSalt("secret")
+		require.Equal(t, "secret", c.getConfig().visitorHashSalt)
+	})
+
+	t.Run("WithBotClassifier", func(t *testing.T) {
+		t.Run("default", func(t *testing.T) {
+			c := DefaultConfig()
+			require.NotNil(t, c.getConfig().botClassifier)
+		})
+
+		t.Run("disabled", func(t *testing.T) {
+			c := DefaultConfig().WithBotClassifier(nil)
+			require.Nil(t, c.getConfig().botClassifier)
+		})
+	})
+
+	t.Run("WithDefaultRedirectType", func(t *testing.T) {
+		t.Run("default", func(t *testing.T) {
+			c := DefaultConfig()
+			require.Equal(t, http.StatusFound, c.getConfig().redirectType)
+		})
+
+		t.Run("valid", func(t *testing.T) {
+			c := DefaultConfig().WithDefaultRedirectType(http.StatusMovedPermanently)
+			require.Nil(t, c.getConfig().err)
+			require.Equal(t, http.StatusMovedPermanently, c.getConfig().redirectType)
+		})
+
+		t.Run("invalid", func(t *testing.T) {
+			c := DefaultConfig().WithDefaultRedirectType(http.StatusNotModified)
+			require.NotNil(t, c.getConfig().err)
+		})
+	})
+	t.Run("WithDomains", func(t *testing.T) {
+		t.Run("default", func(t *testing.T) {
+			c := DefaultConfig()
+			require.Empty(t, c.getConfig().domains)
+			require.False(t, c.getConfig().sharedAliases)
+		})
+
+		t.Run("valid", func(t *testing.T) {
+			c := DefaultConfig().WithDomains("Acme.link", "https://eu.acme.link:8443").WithSharedAliases(true)
+			require.Nil(t, c.getConfig().err)
+			require.Equal(t, []string{"acme.link", "eu.acme.link:8443"}, c.getConfig().domains)
+			require.True(t, c.getConfig().sharedAliases)
+		})
+
+		t.Run("invalid", func(t *testing.T) {
+			for _, domain := range []string{"myurl.com:fsdfsdf", "", "ftp://acme.link"} {
+				c := DefaultConfig().WithDomains("acme.link", domain)
+				require.NotNil(t, c.getConfig().err, domain)
+			}
+		})
+	})
+	t.Run("WithBaseUrl", func(t *testing.T) {
+		t.Run("valid", func(t *testing.T) {
+			c := DefaultConfig().WithBaseUrl("http://intranet-host:8080/s/")
+			require.Nil(t, c.getConfig().err)
+			require.Equal(t, "http", c.getConfig().scheme)
+			require.Equal(t, "intranet-host:8080", c.getConfig().host)
+			require.Equal(t, "/s", c.getConfig().pathPrefix)
+
+			c = DefaultConfig().WithBaseUrl("https://Intranet-Host/s")
+			require.Equal(t, "intranet-host", c.getConfig().host)
+		})
+
+		t.Run("root path", func(t *testing.T) {
+			c := DefaultConfig().WithBaseUrl("https://acme.com")
+			require.Nil(t, c.getConfig().err)
+			require.Equal(t, "", c.getConfig().pathPrefix)
+		})
+
+		t.Run("invalid", func(t *testing.T) {
+			for _, baseUrl := range []string{"acme.com", "ftp://acme.com", "https://", "https://acme.com/?a=b", "https://user@acme.com"} {
+				c := DefaultConfig().WithBaseUrl(baseUrl)
+				require.NotNil(t, c.getConfig().err, baseUrl)
+			}
+		})
+	})
+	t.Run("WithAliasDomains", func(t *testing.T) {
+		c := DefaultConfig().WithAliasDomains("WWW.my.url", "https://old.link")
+		require.Nil(t, c.getConfig().err)
+		require.Equal(t, []string{"www.my.url", "old.link"}, c.getConfig().aliasDomains)
+
+		c = DefaultConfig().WithAliasDomains("myurl%.com")
+		require.NotNil(t, c.getConfig().err)
+	})
+
+	t.Run("WithUrlPolicy", func(t *testing.T) {
+		require.Nil(t, DefaultConfig().getConfig().urlPolicy)
+
+		policy, err := NewUrlPolicy(UrlPolicyOptions{BlockPrivateAddresses: true})
+		require.Nil(t, err)
+		require.Equal(t, policy, DefaultConfig().WithUrlPolicy(policy).getConfig().urlPolicy)
+	})
+
+	t.Run("WithSelfLinks", func(t *testing.T) {
+		require.Equal(t, ResolveSelfLinks, DefaultConfig().getConfig().selfLinks)
+		require.Equal(t, RejectSelfLinks, DefaultConfig().WithSelfLinks(RejectSelfLinks).getConfig().selfLinks)
+		require.NotNil(t, DefaultConfig().WithSelfLinks(SelfLinkPolicy(5)).getConfig().err)
+	})
+
+	t.Run("WithShortenerDomains", func(t *testing.T) {
+		c := DefaultConfig().WithShortenerDomains(FlagShortenerDomains, "Bit.ly", "tinyurl.com")
+		require.Nil(t, c.getConfig().err)
+		require.Equal(t, []string{"bit.ly", "tinyurl.com"}, c.getConfig().shortenerDomains)
+		require.Equal(t, FlagShortenerDomains, c.getConfig().shortenerAction)
+
+		require.NotNil(t, DefaultConfig().WithShortenerDomains(RejectShortenerDomains, "bit.ly/path").getConfig().err)
+		require.NotNil(t, DefaultConfig().WithShortenerDomains(ShortenerDomainAction(5)).getConfig().err)
+	})
 }
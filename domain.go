@@ -0,0 +1,96 @@
+package short
+
+import (
+	"context"
+	"strings"
+)
+
+type domainContextKey struct{}
+
+// ContextWithDomain returns a copy of `ctx` that runs the operations of the shortener on `domain`.
+// The domain is the host of a tenant (see `Tenant.Domains`) or of the shortener (see `Config.WithDomains`).
+// Without a domain the operations run on the first domain of the tenant, or else on the host of the shortener.
+func ContextWithDomain(ctx context.Context, domain string) context.Context {
+	return context.WithValue(ctx, domainContextKey{}, strings.ToLower(domain))
+}
+
+// DomainFromContext returns the domain set by `ContextWithDomain` (empty if not set).
+func DomainFromContext(ctx context.Context) string {
+	domain, _ := ctx.Value(domainContextKey{}).(string)
+	return domain
+}
+
+func (s *shortner) Domains() []string {
+	return append([]string{s.host}, s.domains...)
+}
+
+// domainStore returns the store of the shortened urls of `domain` (false if the domain is not a domain of the shortener).
+// Domains share the store of the host when aliases are shared (see `Config.WithSharedAliases`).
+func (s *shortner) domainStore(domain string) (Store, bool) {
+	if domain == "" || domain == s.host {
+		return s.store, true
+	}
+
+	store, ok := s.domainStores[domain]
+	return store, ok
+}
+
+// domainNamespace returns the namespace of a domain of the shortener.
+func (s *shortner) domainNamespace(domain string) (*namespace, error) {
+	store, ok := s.domainStore(domain)
+	if !ok {
+		return nil, newValidationError("unknown domain %s", domain)
+	}
+
+	ns := namespace{store: store, host: s.host}
+	if domain != "" {
+		ns.host = domain
+	}
+	if s.sharedAliases && ns.host != s.host {
+		// The shortened urls of all the domains are in the same store, the domain is recorded with each url.
+		ns.domain = ns.host
+	}
+
+	return &ns, nil
+}
+
+// contextForHost returns a copy of `ctx` with the tenant and the domain of a shortened url host.
+// Alias domains are resolved on the host of the shortener, other hosts fail with a `ForeignHostError`.
+func (s *shortner) contextForHost(ctx context.Context, host string) (context.Context, error) {
+	host = strings.ToLower(host)
+	// A url without a host (e.g. `/abCD123`) is not a shortened url of the shortener.
+	if host == "" {
+		return nil, &ForeignHostError{Host: host}
+	}
+
+	tenant, err := s.GetTenantByDomain(ctx, host)
+	if err == nil {
+		return ContextWithDomain(ContextWithTenant(ctx, tenant.Id), host), nil
+	}
+	if _, ok := err.(*TenantNotFoundError); !ok {
+		return nil, err
+	}
+
+	if _, ok := s.domainStore(host); ok {
+		return ContextWithDomain(ctx, host), nil
+	}
+
+	if s.aliasDomains[host] {
+		return ctx, nil
+	}
+
+	return nil, &ForeignHostError{Host: host}
+}
+
+// clickStore returns the store that holds the rollups of a click event.
+func (s *shortner) clickStore(event ClickEvent) (Store, error) {
+	if event.Tenant != "" {
+		return s.tenantStore(event.Tenant)
+	}
+
+	if store, ok := s.domainStore(event.Host); ok {
+		return store, nil
+	}
+
+	return s.store, nil
+}
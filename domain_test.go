@@ -0,0 +1,55 @@
+package short
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDomain(t *testing.T) {
+	t.Run("context", func(t *testing.T) {
+		require.Equal(t, "", DomainFromContext(context.Background()))
+		require.Equal(t, "acme.link", DomainFromContext(ContextWithDomain(context.Background(), "Acme.link")))
+	})
+
+	hostStore := &store{name: "short.com"}
+	acmeStore := &store{name: "acme.link"}
+
+	t.Run("per-domain aliases", func(t *testing.T) {
+		s := &shortner{host: "short.com", store: hostStore, domains: []string{"acme.link"}, domainStores: map[string]Store{"acme.link": acmeStore}}
+		require.Equal(t, []string{"short.com", "acme.link"}, s.Domains())
+
+		ns, err := s.domainNamespace("")
+		require.Nil(t, err)
+		require.Equal(t, &namespace{store: hostStore, host: "short.com"}, ns)
+
+		ns, err = s.domainNamespace("acme.link")
+		require.Nil(t, err)
+		require.Equal(t, &namespace{store: acmeStore, host: "acme.link"}, ns)
+
+		var verr *ValidationError
+		_, err = s.domainNamespace("other.com")
+		require.ErrorAs(t, err, &verr)
+
+		store, err := s.clickStore(ClickEvent{Host: "acme.link"})
+		require.Nil(t, err)
+		require.Equal(t, acmeStore, store)
+
+		store, err = s.clickStore(ClickEvent{Host: "other.com"})
+		require.Nil(t, err)
+		require.Equal(t, hostStore, store)
+	})
+
+	t.Run("shared aliases", func(t *testing.T) {
+		s := &shortner{host: "short.com", store: hostStore, domains: []string{"acme.link"}, domainStores: map[string]Store{"acme.link": hostStore}, sharedAliases: true}
+
+		ns, err := s.domainNamespace("acme.link")
+		require.Nil(t, err)
+		require.Equal(t, &namespace{store: hostStore, host: "acme.link", domain: "acme.link"}, ns)
+
+		ns, err = s.domainNamespace("short.com")
+		require.Nil(t, err)
+		require.Equal(t, &namespace{store: hostStore, host: "short.com"}, ns)
+	})
+}
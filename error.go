@@ -1,17 +1,307 @@
 package short
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
-type ConflictError struct{}
+// ConflictError is returned when an id (of a shortened url, an api key or a tenant) already exists.
+type ConflictError struct {
+	// Id is the conflicting id (empty if not known, e.g. when a domain of a tenant is taken).
+	Id string
+}
 
 func (e *ConflictError) Error() string {
-	return "conflict - id already exist in collection"
+	if e.Id == "" {
+		return "conflict - id already exist in collection"
+	}
+	return fmt.Sprintf("conflict - id %s already exist in collection", e.Id)
+}
+
+// Is matches a `*ConflictError` target with the same id, or with any id if the target id is empty.
+func (e *ConflictError) Is(target error) bool {
+	t, ok := target.(*ConflictError)
+	return ok && (t.Id == "" || t.Id == e.Id)
 }
 
+// IdNotFoundError is returned when an id (of a shortened url or an api key) does not exist.
 type IdNotFoundError struct {
-	id string
+	Id string
+	// Host is the host of the shortened url when it is known (e.g. when it is resolved).
+	Host string
 }
 
 func (e *IdNotFoundError) Error() string {
-	return fmt.Sprintf("the id %s not found", e.id)
+	if e.Host == "" {
+		return fmt.Sprintf("the id %s not found", e.Id)
+	}
+	return fmt.Sprintf("the id %s not found on %s", e.Id, e.Host)
+}
+
+// Is matches an `*IdNotFoundError` target with the same id and host (an empty target id or host matches any).
+func (e *IdNotFoundError) Is(target error) bool {
+	t, ok := target.(*IdNotFoundError)
+	return ok && (t.Id == "" || t.Id == e.Id) && (t.Host == "" || t.Host == e.Host)
+}
+
+// ValidationError is returned when an argument is invalid (e.g. a url, an alias or an id).
+type ValidationError struct {
+	err error
+}
+
+func newValidationError(format string, a ...interface{}) error {
+	return &ValidationError{err: fmt.Errorf(format, a...)}
+}
+
+func (e *ValidationError) Error() string {
+	if e.err == nil {
+		return "invalid argument"
+	}
+	return e.err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.err
+}
+
+// Is matches any `*ValidationError` target.
+func (e *ValidationError) Is(target error) bool {
+	_, ok := target.(*ValidationError)
+	return ok
+}
+
+// InvalidApiKeyError is returned when an api key does not exist or was revoked.
+type InvalidApiKeyError struct{}
+
+func (e *InvalidApiKeyError) Error() string {
+	return "invalid api key"
+}
+
+// Is matches any `*InvalidApiKeyError` target.
+func (e *InvalidApiKeyError) Is(target error) bool {
+	_, ok := target.(*InvalidApiKeyError)
+	return ok
+}
+
+// RateLimitError is returned when a rate limit is exceeded (see `RateLimiter`).
+type RateLimitError struct {
+	// RetryAfter is the time until the operation is allowed again.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+// Is matches any `*RateLimitError` target.
+func (e *RateLimitError) Is(target error) bool {
+	_, ok := target.(*RateLimitError)
+	return ok
+}
+
+// TenantNotFoundError is returned when a tenant (or the tenant of a domain) does not exist.
+type TenantNotFoundError struct {
+	// Tenant is the id of the tenant (or the domain) that was not found.
+	Tenant string
+}
+
+func (e *TenantNotFoundError) Error() string {
+	return fmt.Sprintf("the tenant %s not found", e.Tenant)
+}
+
+// Is matches a `*TenantNotFoundError` target with the same tenant, or with any tenant if the target tenant is empty.
+func (e *TenantNotFoundError) Is(target error) bool {
+	t, ok := target.(*TenantNotFoundError)
+	return ok && (t.Tenant == "" || t.Tenant == e.Tenant)
+}
+
+// QuotaExceededError is returned when creating a shortened url or an api key would exceed the quota of a tenant.
+type QuotaExceededError struct {
+	Tenant string
+	// Resource is "links" or "api keys".
+	Resource string
+	Limit    int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("the tenant %s exceeded its quota of %d %s", e.Tenant, e.Limit, e.Resource)
+}
+
+// Is matches a `*QuotaExceededError` target with the same tenant, or with any tenant if the target tenant is empty.
+func (e *QuotaExceededError) Is(target error) bool {
+	t, ok := target.(*QuotaExceededError)
+	return ok && (t.Tenant == "" || t.Tenant == e.Tenant)
+}
+
+// ForeignHostError is returned when a shortened url is not a shortened url of the shortener
+// (its host is not a host of the shortener or its path does not start with the path prefix of the shortener).
+type ForeignHostError struct {
+	Host string
+}
+
+func (e *ForeignHostError) Error() string {
+	return fmt.Sprintf("the host %s is not a host of the shortener", e.Host)
+}
+
+// Is matches a `*ForeignHostError` target with the same host, or with any host if the target host is empty.
+func (e *ForeignHostError) Is(target error) bool {
+	t, ok := target.(*ForeignHostError)
+	return ok && (t.Host == "" || t.Host == e.Host)
+}
+
+// BlockedUrlError is returned when a url is not allowed by the url policy (see `Config.WithUrlPolicy`).
+type BlockedUrlError struct {
+	Url string
+	// Reason explains why the url is blocked (e.g. "the domain is denied").
+	Reason string
+}
+
+func (e *BlockedUrlError) Error() string {
+	return fmt.Sprintf("the url %s is blocked: %s", e.Url, e.Reason)
+}
+
+// Is matches a `*BlockedUrlError` target with the same url, or with any url if the target url is empty.
+func (e *BlockedUrlError) Is(target error) bool {
+	t, ok := target.(*BlockedUrlError)
+	return ok && (t.Url == "" || t.Url == e.Url)
+}
+
+// PasswordRequiredError is returned when a password-protected shortened url is resolved without the right password
+// (see `UrlConfig.WithPassword` and `ContextWithPassword`).
+type PasswordRequiredError struct {
+	Id string
+}
+
+func (e *PasswordRequiredError) Error() string {
+	return fmt.Sprintf("the shortened url %s requires a password", e.Id)
+}
+
+// Is matches a `*PasswordRequiredError` target with the same id, or with any id if the target id is empty.
+func (e *PasswordRequiredError) Is(target error) bool {
+	t, ok := target.(*PasswordRequiredError)
+	return ok && (t.Id == "" || t.Id == e.Id)
+}
+
+// NotYetActiveError is returned when a shortened url is resolved before its activation date (see `UrlConfig.WithActivationDate`).
+type NotYetActiveError struct {
+	Id string
+	// ActivationDate is the time the shortened url starts to resolve.
+	ActivationDate time.Time
+}
+
+func (e *NotYetActiveError) Error() string {
+	return fmt.Sprintf("the shortened url %s is not active until %s", e.Id, e.ActivationDate.UTC().Format(time.RFC3339))
+}
+
+// Is matches a `*NotYetActiveError` target with the same id, or with any id if the target id is empty.
+func (e *NotYetActiveError) Is(target error) bool {
+	t, ok := target.(*NotYetActiveError)
+	return ok && (t.Id == "" || t.Id == e.Id)
+}
+
+// ExpiredError is returned when a shortened url is resolved after its expiration date (see `UrlConfig.WithExpirationDate`).
+type ExpiredError struct {
+	Id string
+	// ExpirationDate is the time the shortened url expired.
+	ExpirationDate time.Time
+}
+
+func (e *ExpiredError) Error() string {
+	return fmt.Sprintf("the shortened url %s expired on %s", e.Id, e.ExpirationDate.UTC().Format(time.RFC3339))
+}
+
+// Is matches an `*ExpiredError` target with the same id, or with any id if the target id is empty.
+// It also matches an `*IdNotFoundError` target without a host, which expired shortened urls returned before.
+func (e *ExpiredError) Is(target error) bool {
+	if t, ok := target.(*ExpiredError); ok {
+		return t.Id == "" || t.Id == e.Id
+	}
+	return isNotFoundTarget(target, e.Id)
+}
+
+// GoneError is returned when a shortened url was deleted or used up its clicks (see `UrlConfig.WithMaxClicks`).
+type GoneError struct {
+	Id string
+	// State is `LinkTombstoned` or `LinkDisabled`.
+	State LinkState
+}
+
+func (e *GoneError) Error() string {
+	return fmt.Sprintf("the shortened url %s is %s", e.Id, e.State)
+}
+
+// Is matches a `*GoneError` target with the same id, or with any id if the target id is empty.
+// It also matches an `*IdNotFoundError` target without a host, which deleted and used up shortened urls returned before.
+func (e *GoneError) Is(target error) bool {
+	if t, ok := target.(*GoneError); ok {
+		return t.Id == "" || t.Id == e.Id
+	}
+	return isNotFoundTarget(target, e.Id)
+}
+
+// isNotFoundTarget returns true if `target` is an `*IdNotFoundError` without a host that matches `id`.
+func isNotFoundTarget(target error, id string) bool {
+	t, ok := target.(*IdNotFoundError)
+	return ok && (t.Id == "" || t.Id == id) && t.Host == ""
+}
+
+// ClickLimitedError is returned when a click-limited shortened url (see `UrlConfig.WithMaxClicks`) is resolved by a bot
+// (see `Config.WithBotClassifier`) or with a HEAD request. These requests do not take a click, so they are not given the url.
+type ClickLimitedError struct {
+	Id string
+}
+
+func (e *ClickLimitedError) Error() string {
+	return fmt.Sprintf("the click-limited shortened url %s is not resolved for bots and HEAD requests", e.Id)
+}
+
+// Is matches a `*ClickLimitedError` target with the same id, or with any id if the target id is empty.
+func (e *ClickLimitedError) Is(target error) bool {
+	t, ok := target.(*ClickLimitedError)
+	return ok && (t.Id == "" || t.Id == e.Id)
+}
+
+// ErrorCode is a stable code of an error, shared by the management API (`api.Error.Code`)
+// and the gRPC service (the reason of the `google.rpc.ErrorInfo` detail, upper case).
+type ErrorCode string
+
+const (
+	CodeInvalidArgument    ErrorCode = "invalid_argument"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeGone               ErrorCode = "gone"
+	CodeAlreadyExists      ErrorCode = "already_exists"
+	CodeUnauthenticated    ErrorCode = "unauthenticated"
+	CodePermissionDenied   ErrorCode = "permission_denied"
+	CodeFailedPrecondition ErrorCode = "failed_precondition"
+	CodeRateLimited        ErrorCode = "rate_limited"
+	CodeQuotaExceeded      ErrorCode = "quota_exceeded"
+	CodeInternal           ErrorCode = "internal"
+)
+
+// ErrorCodeOf returns the code of an error returned by the shortener (`CodeInternal` for unknown errors).
+func ErrorCodeOf(err error) ErrorCode {
+	switch {
+	case errors.Is(err, &ValidationError{}), errors.Is(err, &ForeignHostError{}), errors.Is(err, &BlockedUrlError{}):
+		return CodeInvalidArgument
+	// Expired and gone errors also match `IdNotFoundError`, so they are checked first.
+	case errors.Is(err, &ExpiredError{}), errors.Is(err, &GoneError{}):
+		return CodeGone
+	case errors.Is(err, &IdNotFoundError{}), errors.Is(err, &TenantNotFoundError{}):
+		return CodeNotFound
+	case errors.Is(err, &ConflictError{}):
+		return CodeAlreadyExists
+	case errors.Is(err, &InvalidApiKeyError{}):
+		return CodeUnauthenticated
+	case errors.Is(err, &PasswordRequiredError{}):
+		return CodePermissionDenied
+	case errors.Is(err, &NotYetActiveError{}), errors.Is(err, &ClickLimitedError{}):
+		return CodeFailedPrecondition
+	case errors.Is(err, &RateLimitError{}):
+		return CodeRateLimited
+	case errors.Is(err, &QuotaExceededError{}):
+		return CodeQuotaExceeded
+	default:
+		return CodeInternal
+	}
 }
@@ -0,0 +1,74 @@
+package short
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("resolve: %w", &IdNotFoundError{Id: "abc", Host: "short.com"})
+
+	t.Run("any id", func(t *testing.T) {
+		require.ErrorIs(t, err, &IdNotFoundError{})
+		require.ErrorIs(t, &ConflictError{Id: "abc"}, &ConflictError{})
+		require.ErrorIs(t, &ForeignHostError{Host: "evil.com"}, &ForeignHostError{})
+		require.ErrorIs(t, &InvalidApiKeyError{}, &InvalidApiKeyError{})
+		require.ErrorIs(t, newValidationError("invalid url"), &ValidationError{})
+	})
+
+	t.Run("same id", func(t *testing.T) {
+		require.ErrorIs(t, err, &IdNotFoundError{Id: "abc"})
+		require.ErrorIs(t, err, &IdNotFoundError{Id: "abc", Host: "short.com"})
+		require.False(t, errors.Is(err, &IdNotFoundError{Id: "xyz"}))
+		require.False(t, errors.Is(err, &IdNotFoundError{Id: "abc", Host: "other.com"}))
+		require.False(t, errors.Is(err, &ExpiredError{Id: "abc"}))
+	})
+
+	t.Run("expired and gone", func(t *testing.T) {
+		// Expired, deleted and used up shortened urls used to fail with an IdNotFoundError.
+		for _, err := range []error{&ExpiredError{Id: "abc"}, fmt.Errorf("resolve: %w", &GoneError{Id: "abc", State: LinkTombstoned})} {
+			require.ErrorIs(t, err, &IdNotFoundError{})
+			require.ErrorIs(t, err, &IdNotFoundError{Id: "abc"})
+			require.False(t, errors.Is(err, &IdNotFoundError{Id: "xyz"}))
+			require.False(t, errors.Is(err, &IdNotFoundError{Id: "abc", Host: "short.com"}))
+			require.Equal(t, CodeGone, ErrorCodeOf(err))
+		}
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		var nerr *IdNotFoundError
+		require.ErrorAs(t, err, &nerr)
+		require.Equal(t, "abc", nerr.Id)
+		require.Equal(t, "short.com", nerr.Host)
+		require.Equal(t, "the id abc not found on short.com", nerr.Error())
+		require.Equal(t, "conflict - id abc already exist in collection", (&ConflictError{Id: "abc"}).Error())
+	})
+}
+
+func TestErrorCodeOf(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		code ErrorCode
+	}{
+		{newValidationError("invalid url"), CodeInvalidArgument},
+		{&BlockedUrlError{Url: "https://evil.com", Reason: "the domain is denied"}, CodeInvalidArgument},
+		{&IdNotFoundError{Id: "abc"}, CodeNotFound},
+		{&TenantNotFoundError{Tenant: "acme"}, CodeNotFound},
+		{&ExpiredError{Id: "abc"}, CodeGone},
+		{&GoneError{Id: "abc", State: LinkTombstoned}, CodeGone},
+		{&ConflictError{}, CodeAlreadyExists},
+		{&InvalidApiKeyError{}, CodeUnauthenticated},
+		{&PasswordRequiredError{Id: "abc"}, CodePermissionDenied},
+		{&NotYetActiveError{Id: "abc"}, CodeFailedPrecondition},
+		{&ClickLimitedError{Id: "abc"}, CodeFailedPrecondition},
+		{&RateLimitError{}, CodeRateLimited},
+		{&QuotaExceededError{Tenant: "acme"}, CodeQuotaExceeded},
+		{fmt.Errorf("insert: %w", &ConflictError{Id: "abc"}), CodeAlreadyExists},
+		{errors.New("database is down"), CodeInternal},
+	} {
+		require.Equal(t, test.code, ErrorCodeOf(test.err), test.err.Error())
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/TomerHeber/go-short-url"
+	"github.com/TomerHeber/go-short-url/api"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/browser"
@@ -32,10 +38,21 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 }
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	s, err := short.NewShortener()
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer func() {
+		// Flushes the buffered clicks and disconnects from Mongo.
+		closeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.Close(closeCtx); err != nil {
+			log.Println(err)
+		}
+	}()
 
 	e := echo.New()
 
@@ -64,25 +81,26 @@ func main() {
 		})
 	})
 
-	e.GET("/:id", func(c echo.Context) error {
-		id := c.Param("id")
+	// The management API requires an api key (see `short keys create`).
+	e.Any("/api/*", echo.WrapHandler(http.StripPrefix("/api", api.RequireApiKey(s)(api.NewHandler(s, api.Options{})))))
 
-		if len(id) > 7 {
-			return echo.NewHTTPError(http.StatusNotFound)
-		}
+	e.GET("/:id", echo.WrapHandler(short.Handler(s, short.HandlerOptions{})))
 
-		url, err := s.GetUrlFromShortenedUrlId(c.Request().Context(), id)
-		if err != nil {
-			if errors.Is(err, &short.IdNotFoundError{}) {
-				return echo.NewHTTPError(http.StatusNotFound, err.Error())
-			}
-			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	go func() {
+		if err := e.Start(":8080"); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Error(err)
+			stop()
 		}
-
-		return c.Redirect(http.StatusMovedPermanently, url)
-	})
+	}()
 
 	//nolint
 	go browser.OpenURL("http://localhost:8080")
-	e.Logger.Fatal(e.Start(":8080"))
+
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		e.Logger.Error(err)
+	}
 }
@@ -0,0 +1,96 @@
+package short
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoLocation is the geographic location of an ip address.
+type GeoLocation struct {
+	// Country is the ISO 3166-1 alpha-2 country code (e.g. `US`).
+	Country string
+	// Region is the ISO 3166-2 code of the country subdivision (e.g. `US-CA`).
+	Region string
+	// City is the English name of the city.
+	City string
+}
+
+// GeoResolver returns the geographic location of an ip address.
+// It is used to enrich click events before they are delivered to the click sinks.
+type GeoResolver interface {
+	// Resolve returns the location of `ip` (nil if the location is unknown).
+	Resolve(ip net.IP) (*GeoLocation, error)
+	// Close releases any resources held by the resolver.
+	Close() error
+}
+
+type maxMindGeoResolver struct {
+	reader *maxminddb.Reader
+}
+
+// NewMaxMindGeoResolver creates a GeoResolver that reads a local MaxMind DB file (e.g. GeoLite2-City.mmdb).
+// Country, Region and City databases are supported. No network requests are made.
+func NewMaxMindGeoResolver(path string) (GeoResolver, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the MaxMind database %s: %w", path, err)
+	}
+
+	return &maxMindGeoResolver{reader: reader}, nil
+}
+
+func (r *maxMindGeoResolver) Resolve(ip net.IP) (*GeoLocation, error) {
+	var record struct {
+		Country struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		Subdivisions []struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"subdivisions"`
+		City struct {
+			Names map[string]string `maxminddb:"names"`
+		} `maxminddb:"city"`
+	}
+
+	if err := r.reader.Lookup(ip, &record); err != nil {
+		return nil, fmt.Errorf("failed to lookup %s: %w", ip, err)
+	}
+
+	if record.Country.IsoCode == "" {
+		return nil, nil
+	}
+
+	location := GeoLocation{
+		Country: record.Country.IsoCode,
+		City:    record.City.Names["en"],
+	}
+	if len(record.Subdivisions) > 0 && record.Subdivisions[0].IsoCode != "" {
+		location.Region = record.Country.IsoCode + "-" + record.Subdivisions[0].IsoCode
+	}
+
+	return &location, nil
+}
+
+func (r *maxMindGeoResolver) Close() error {
+	return r.reader.Close()
+}
+
+// enrichClickEvent sets the location of a click event from its client ip.
+// Failures are ignored, the event is delivered without a location.
+func enrichClickEvent(resolver GeoResolver, event *ClickEvent) {
+	ip := net.ParseIP(event.ClientIP)
+	if ip == nil {
+		return
+	}
+
+	location, err := resolver.Resolve(ip)
+	if err != nil || location == nil {
+		return
+	}
+
+	event.Country = location.Country
+	event.Region = location.Region
+	event.City = location.City
+}
@@ -0,0 +1,198 @@
+package short
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mmdbValue is a value encoded in the MaxMind DB data format.
+type mmdbValue []byte
+
+func mmdbControl(dataType int, size int) []byte {
+	if dataType <= 7 {
+		return []byte{byte(dataType<<5 | size)}
+	}
+	return []byte{byte(size), byte(dataType - 7)}
+}
+
+func mmdbString(s string) mmdbValue {
+	return append(mmdbControl(2, len(s)), s...)
+}
+
+func mmdbUint(dataType int, v uint64) mmdbValue {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	b := buf[:]
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return append(mmdbControl(dataType, len(b)), b...)
+}
+
+func mmdbMap(m map[string]mmdbValue) mmdbValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	v := mmdbControl(7, len(m))
+	for _, k := range keys {
+		v = append(v, mmdbString(k)...)
+		v = append(v, m[k]...)
+	}
+	return v
+}
+
+func mmdbArray(values ...mmdbValue) mmdbValue {
+	v := mmdbControl(11, len(values))
+	for _, value := range values {
+		v = append(v, value...)
+	}
+	return v
+}
+
+// writeTestMaxMindDatabase writes an IPv4 MaxMind DB file (24 bit records) that maps networks to records.
+func writeTestMaxMindDatabase(t *testing.T, networks map[string]mmdbValue) string {
+	t.Helper()
+
+	type node struct {
+		// Each record is either a child node, a data offset (>= 0) or empty.
+		children [2]*node
+		data     [2]int
+		index    int
+	}
+
+	var nodes []*node
+	newNode := func() *node {
+		n := &node{data: [2]int{-1, -1}, index: len(nodes)}
+		nodes = append(nodes, n)
+		return n
+	}
+	root := newNode()
+
+	var data []byte
+
+	cidrs := make([]string, 0, len(networks))
+	for cidr := range networks {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		require.Nil(t, err)
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To4()
+
+		offset := len(data)
+		data = append(data, networks[cidr]...)
+
+		n := root
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - i%8)) & 1
+			if i == ones-1 {
+				n.data[bit] = offset
+				break
+			}
+			if n.children[bit] == nil {
+				n.children[bit] = newNode()
+			}
+			n = n.children[bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	var tree []byte
+	for _, n := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			record := nodeCount
+			if n.children[bit] != nil {
+				record = n.children[bit].index
+			} else if n.data[bit] >= 0 {
+				record = nodeCount + 16 + n.data[bit]
+			}
+			tree = append(tree, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+
+	var db []byte
+	db = append(db, tree...)
+	db = append(db, make([]byte, 16)...)
+	db = append(db, data...)
+	db = append(db, "\xAB\xCD\xEFMaxMind.com"...)
+	db = append(db, mmdbMap(map[string]mmdbValue{
+		"node_count":                  mmdbUint(6, uint64(nodeCount)),
+		"record_size":                 mmdbUint(5, 24),
+		"ip_version":                  mmdbUint(5, 4),
+		"database_type":               mmdbString("GeoIP2-City"),
+		"languages":                   mmdbArray(mmdbString("en")),
+		"binary_format_major_version": mmdbUint(5, 2),
+		"binary_format_minor_version": mmdbUint(5, 0),
+		"build_epoch":                 mmdbUint(9, 1665000000),
+		"description":                 mmdbMap(map[string]mmdbValue{"en": mmdbString("test")}),
+	})...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	require.Nil(t, os.WriteFile(path, db, 0o644))
+
+	return path
+}
+
+func TestMaxMindGeoResolver(t *testing.T) {
+	path := writeTestMaxMindDatabase(t, map[string]mmdbValue{
+		"1.2.3.0/24": mmdbMap(map[string]mmdbValue{
+			"country":      mmdbMap(map[string]mmdbValue{"iso_code": mmdbString("US")}),
+			"subdivisions": mmdbArray(mmdbMap(map[string]mmdbValue{"iso_code": mmdbString("CA")})),
+			"city":         mmdbMap(map[string]mmdbValue{"names": mmdbMap(map[string]mmdbValue{"en": mmdbString("Mountain View")})}),
+		}),
+		"5.6.0.0/16": mmdbMap(map[string]mmdbValue{
+			"country": mmdbMap(map[string]mmdbValue{"iso_code": mmdbString("IL")}),
+		}),
+	})
+
+	resolver, err := NewMaxMindGeoResolver(path)
+	require.Nil(t, err)
+	defer resolver.Close()
+
+	t.Run("city", func(t *testing.T) {
+		location, err := resolver.Resolve(net.ParseIP("1.2.3.4"))
+		require.Nil(t, err)
+		require.Equal(t, &GeoLocation{Country: "US", Region: "US-CA", City: "Mountain View"}, location)
+	})
+
+	t.Run("country", func(t *testing.T) {
+		location, err := resolver.Resolve(net.ParseIP("5.6.7.8"))
+		require.Nil(t, err)
+		require.Equal(t, &GeoLocation{Country: "IL"}, location)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		location, err := resolver.Resolve(net.ParseIP("9.9.9.9"))
+		require.Nil(t, err)
+		require.Nil(t, location)
+	})
+
+	t.Run("enrich", func(t *testing.T) {
+		event := ClickEvent{ClientIP: "1.2.3.4"}
+		enrichClickEvent(resolver, &event)
+		require.Equal(t, "US", event.Country)
+		require.Equal(t, "US-CA", event.Region)
+		require.Equal(t, "Mountain View", event.City)
+
+		event = ClickEvent{ClientIP: "not an ip"}
+		enrichClickEvent(resolver, &event)
+		require.Empty(t, event.Country)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := NewMaxMindGeoResolver(filepath.Join(t.TempDir(), "missing.mmdb"))
+		require.Error(t, err)
+	})
+}
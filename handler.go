@@ -0,0 +1,344 @@
+package short
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HandlerOptions may be used to customize the handler returned by `Handler`.
+type HandlerOptions struct {
+	// NotFoundHandler serves requests for ids that do not exist.
+	// Defaults to a plain `404 Not Found` response.
+	NotFoundHandler http.Handler
+
+	// ErrorHandler serves requests that failed with an internal error.
+	// Defaults to a plain `500 Internal Server Error` response.
+	ErrorHandler http.Handler
+
+	// BlockedHandler serves requests for urls that are not allowed by the url policy (see `Config.WithUrlPolicy`).
+	// Defaults to a plain `403 Forbidden` response.
+	BlockedHandler http.Handler
+
+	// NotYetActiveHandler serves requests for shortened urls before their activation date (see `UrlConfig.WithActivationDate`),
+	// e.g. a "coming soon" page. The response is never cached. Defaults to a plain `404 Not Found` response.
+	NotYetActiveHandler http.Handler
+
+	// GoneHandler serves requests for shortened urls that expired, were deleted or used up their clicks,
+	// e.g. a "this link expired on ..." page (see `ResolveErrorFromContext`). The response is never cached,
+	// since the expiration may be extended. Defaults to a plain `410 Gone` response.
+	GoneHandler http.Handler
+
+	// BotPreview serves bots (see `Config.WithBotClassifier`) a preview page instead of a redirect.
+	BotPreview bool
+
+	// PermanentRedirectMaxAge is the time permanent redirects (301 and 308) may be cached (`Cache-Control: max-age`).
+	// Temporary redirects (302 and 307) and the redirects of limited shortened urls (see `Resolution.Limited`)
+	// are never cached, so every click reaches the shortener.
+	// Defaults to one day.
+	PermanentRedirectMaxAge time.Duration
+
+	// TrustProxyHeaders reads the client ip from the `X-Forwarded-For` and `X-Real-IP` headers.
+	// The client ip is the last `X-Forwarded-For` address, which was added by the proxy (the other addresses are set by the client).
+	// Enable it only when the handler is behind a single reverse proxy that sets these headers.
+	TrustProxyHeaders bool
+
+	// NotFoundRateLimiter limits the requests for ids that do not exist per client ip (e.g. to prevent enumerating ids).
+	// Every not found response takes a token from the bucket of the client ip,
+	// and once the bucket is empty all the requests of the client ip are rejected with `429 Too Many Requests`.
+	NotFoundRateLimiter RateLimiter
+
+	// PasswordRateLimiter limits the failed unlocks of password-protected shortened urls (see `UrlConfig.WithPassword`)
+	// per client ip and id. Every incorrect password takes a token from the bucket,
+	// and once the bucket is empty the unlocks are rejected with `429 Too Many Requests`.
+	// Defaults to an in-memory limiter of 5 attempts, then one attempt per minute.
+	PasswordRateLimiter RateLimiter
+}
+
+const defaultPermanentRedirectMaxAge = 24 * time.Hour
+
+const (
+	defaultPasswordRate  = 1.0 / 60
+	defaultPasswordBurst = 5
+	// maxUnlockFormSize is the maximum size of an unlock form body.
+	maxUnlockFormSize = 4096
+)
+
+type handler struct {
+	shortener Shortener
+	options   HandlerOptions
+	domains   map[string]bool
+	// pathPrefix is the path of the shortened urls before the id (see `Shortener.PathPrefix`).
+	pathPrefix string
+}
+
+// Handler returns an http.Handler that redirects shortened urls to their original urls.
+// The request path is the path of the shortened url: the path prefix of the shortener followed by the id
+// (e.g. `/abCD123`, or `/s/abCD123` for the base url `https://short.com/s`), other paths are not found.
+// The path prefix must not be stripped from the request (e.g. with `http.StripPrefix`).
+// Requests for the domain of a tenant (see `Tenant.Domains`) are resolved in the namespace of the tenant,
+// and requests for a domain of the shortener (see `Config.WithDomains`) in the namespace of the domain.
+// Password-protected shortened urls are served an unlock form that posts the password to the same url.
+// It may be used with the standard library or with any router that accepts an http.Handler (chi, echo, gin, ...).
+func Handler(s Shortener, options HandlerOptions) http.Handler {
+	if options.NotFoundHandler == nil {
+		options.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		})
+	}
+
+	if options.PermanentRedirectMaxAge <= 0 {
+		options.PermanentRedirectMaxAge = defaultPermanentRedirectMaxAge
+	}
+
+	if options.BlockedHandler == nil {
+		options.BlockedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+	}
+
+	if options.NotYetActiveHandler == nil {
+		options.NotYetActiveHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		})
+	}
+
+	if options.GoneHandler == nil {
+		options.GoneHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+		})
+	}
+
+	if options.PasswordRateLimiter == nil {
+		// The default rate is valid, NewMemoryRateLimiter does not fail.
+		options.PasswordRateLimiter, _ = NewMemoryRateLimiter(defaultPasswordRate, defaultPasswordBurst)
+	}
+
+	if options.ErrorHandler == nil {
+		options.ErrorHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		})
+	}
+
+	domains := map[string]bool{}
+	for _, domain := range s.Domains() {
+		domains[domain] = true
+	}
+
+	return &handler{
+		shortener:  s,
+		options:    options,
+		domains:    domains,
+		pathPrefix: s.PathPrefix(),
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// POST is used by the unlock form of password-protected shortened urls.
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	md := NewRequestMetadata(r, h.options.TrustProxyHeaders)
+
+	if h.options.NotFoundRateLimiter != nil {
+		allowed, retryAfter, err := h.options.NotFoundRateLimiter.Allow(r.Context(), md.ClientIP, 0)
+		if err != nil {
+			h.options.ErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		if !allowed {
+			WriteTooManyRequests(w, retryAfter)
+			return
+		}
+	}
+
+	if h.pathPrefix != "" && !strings.HasPrefix(r.URL.Path, h.pathPrefix+"/") {
+		h.notFound(w, r, md)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.pathPrefix), "/")
+	if len(id) == 0 || !isAlphaNumeric(id) {
+		h.notFound(w, r, md)
+		return
+	}
+
+	// Requests for a tenant domain are resolved in the namespace of the tenant,
+	// and requests for a domain of the shortener in the namespace of the domain.
+	ctx := r.Context()
+	host := strings.ToLower(r.Host)
+	if tenant, err := h.shortener.GetTenantByDomain(ctx, host); err == nil {
+		ctx = ContextWithDomain(ContextWithTenant(ctx, tenant.Id), host)
+	} else if !errors.As(err, new(*TenantNotFoundError)) {
+		h.options.ErrorHandler.ServeHTTP(w, r)
+		return
+	} else if h.domains[host] {
+		ctx = ContextWithDomain(ctx, host)
+	}
+
+	unlock := r.Method == http.MethodPost
+	passwordKey := md.ClientIP + "/" + host + "/" + id
+	if unlock {
+		allowed, retryAfter, err := h.options.PasswordRateLimiter.Allow(ctx, passwordKey, 0)
+		if err != nil {
+			h.options.ErrorHandler.ServeHTTP(w, r)
+			return
+		}
+		if !allowed {
+			WriteTooManyRequests(w, retryAfter)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxUnlockFormSize)
+		ctx = ContextWithPassword(ctx, r.PostFormValue("password"))
+	}
+
+	res, err := h.shortener.Resolve(ctx, id, md)
+	if err != nil {
+		var notFoundErr *IdNotFoundError
+		var blockedErr *BlockedUrlError
+		var passwordErr *PasswordRequiredError
+		var notYetActiveErr *NotYetActiveError
+		var expiredErr *ExpiredError
+		var goneErr *GoneError
+		var clickLimitedErr *ClickLimitedError
+		r = r.WithContext(context.WithValue(r.Context(), resolveErrorKey{}, err))
+		if errors.As(err, &notFoundErr) {
+			h.notFound(w, r, md)
+		} else if errors.As(err, &blockedErr) {
+			h.options.BlockedHandler.ServeHTTP(w, r)
+		} else if errors.As(err, &notYetActiveErr) {
+			// The page must not be cached past the activation date.
+			w.Header().Set("Cache-Control", "private, no-store")
+			h.options.NotYetActiveHandler.ServeHTTP(w, r)
+		} else if errors.As(err, &expiredErr) || errors.As(err, &goneErr) {
+			w.Header().Set("Cache-Control", "private, no-store")
+			h.options.GoneHandler.ServeHTTP(w, r)
+		} else if errors.As(err, &clickLimitedErr) {
+			// Bots and HEAD requests are not given the url of a click-limited url (no Location header and no preview).
+			w.Header().Set("Cache-Control", "private, no-store")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		} else if errors.As(err, &passwordErr) {
+			h.unlockForm(w, r, passwordKey, unlock)
+		} else {
+			h.options.ErrorHandler.ServeHTTP(w, r)
+		}
+		return
+	}
+
+	if h.options.BotPreview && res.Bot {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := WritePreview(w, res.Url); err != nil {
+			h.options.ErrorHandler.ServeHTTP(w, r)
+		}
+		return
+	}
+
+	redirectType := res.RedirectType
+	if redirectType == 0 {
+		redirectType = http.StatusFound
+	}
+	// An unlock must not be cached, and 307 and 308 would post the password to the destination.
+	if unlock {
+		redirectType = http.StatusSeeOther
+	}
+
+	// A cached redirect of a limited shortened url would bypass its limits (e.g. its clicks or its expiration).
+	if (redirectType == http.StatusMovedPermanently || redirectType == http.StatusPermanentRedirect) && !res.Limited {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(h.options.PermanentRedirectMaxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+
+	http.Redirect(w, r, res.Url, redirectType)
+}
+
+type resolveErrorKey struct{}
+
+// ResolveErrorFromContext returns the error of a request that failed to resolve (nil if not set).
+// It may be used by the handlers of `HandlerOptions`, e.g. to read `ExpiredError.ExpirationDate`.
+func ResolveErrorFromContext(ctx context.Context) error {
+	err, _ := ctx.Value(resolveErrorKey{}).(error)
+	return err
+}
+
+func (h *handler) notFound(w http.ResponseWriter, r *http.Request, md *RequestMetadata) {
+	if h.options.NotFoundRateLimiter != nil {
+		// The not found response is served even if the rate limiter fails.
+		_, _, _ = h.options.NotFoundRateLimiter.Allow(r.Context(), md.ClientIP, 1)
+	}
+
+	h.options.NotFoundHandler.ServeHTTP(w, r)
+}
+
+// unlockForm serves the unlock form of a password-protected shortened url.
+// `failed` is true if the request posted an incorrect password, which takes a token from the password rate limiter.
+func (h *handler) unlockForm(w http.ResponseWriter, r *http.Request, passwordKey string, failed bool) {
+	if failed {
+		// The unlock form is served even if the rate limiter fails.
+		_, _, _ = h.options.PasswordRateLimiter.Allow(r.Context(), passwordKey, 1)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = WriteUnlockForm(w, failed)
+}
+
+// WriteTooManyRequests writes a `429 Too Many Requests` response with a `Retry-After` header.
+func WriteTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", RetryAfterSeconds(retryAfter))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// RetryAfterSeconds formats `retryAfter` as the value of a `Retry-After` header (whole seconds, at least 1).
+func RetryAfterSeconds(retryAfter time.Duration) string {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return strconv.FormatInt(seconds, 10)
+}
+
+// NewRequestMetadata returns the metadata of an http request.
+// If `trustProxyHeaders` is true the client ip is read from the `X-Forwarded-For` (the last address) and `X-Real-IP` headers.
+func NewRequestMetadata(r *http.Request, trustProxyHeaders bool) *RequestMetadata {
+	return &RequestMetadata{
+		Referrer:       r.Referer(),
+		UserAgent:      r.UserAgent(),
+		ClientIP:       clientIP(r, trustProxyHeaders),
+		AcceptLanguage: r.Header.Get("Accept-Language"),
+		Method:         r.Method,
+	}
+}
+
+func clientIP(r *http.Request, trustProxyHeaders bool) string {
+	if trustProxyHeaders {
+		if forwardedFor := r.Header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+			// The proxy appends the address it received the request from, the addresses before it are set by the client.
+			addrs := strings.Split(forwardedFor[len(forwardedFor)-1], ",")
+			if addr := strings.TrimSpace(addrs[len(addrs)-1]); addr != "" {
+				return addr
+			}
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
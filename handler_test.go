@@ -0,0 +1,393 @@
+package short
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// resolveShortener is a Shortener that resolves ids with a function.
+type resolveShortener struct {
+	Shortener
+	resolve    func(ctx context.Context, id string, md *RequestMetadata) (*Resolution, error)
+	pathPrefix string
+}
+
+func (s *resolveShortener) Resolve(ctx context.Context, id string, md *RequestMetadata) (*Resolution, error) {
+	return s.resolve(ctx, id, md)
+}
+
+func (s *resolveShortener) Domains() []string {
+	return []string{"short.com", "eu.short.com"}
+}
+
+func (s *resolveShortener) PathPrefix() string {
+	return s.pathPrefix
+}
+
+func (s *resolveShortener) GetTenantByDomain(ctx context.Context, domain string) (*Tenant, error) {
+	if domain == "go.acme.com" {
+		return &Tenant{Id: "acme", Domains: []string{domain}}, nil
+	}
+	return nil, &TenantNotFoundError{Tenant: domain}
+}
+
+func TestHandler(t *testing.T) {
+	var lastMetadata *RequestMetadata
+
+	s := &resolveShortener{
+		resolve: func(ctx context.Context, id string, md *RequestMetadata) (*Resolution, error) {
+			lastMetadata = md
+			switch id {
+			case "found":
+				return &Resolution{Url: "https://test.com/path", RedirectType: http.StatusFound}, nil
+			case "permanent":
+				return &Resolution{Url: "https://test.com/path", RedirectType: http.StatusPermanentRedirect}, nil
+			case "bot":
+				return &Resolution{Url: "https://test.com/path", Bot: true}, nil
+			case "missing":
+				return nil, &IdNotFoundError{Id: id}
+			case "protected":
+				if PasswordFromContext(ctx) != "secret" {
+					return nil, &PasswordRequiredError{Id: id}
+				}
+				return &Resolution{Url: "https://test.com/protected", RedirectType: http.StatusPermanentRedirect, Limited: true}, nil
+			case "expiring":
+				return &Resolution{Url: "https://test.com/path", RedirectType: http.StatusMovedPermanently, Limited: true}, nil
+			case "expired":
+				return nil, &ExpiredError{Id: id, ExpirationDate: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+			case "used":
+				return nil, &GoneError{Id: id, State: LinkDisabled}
+			case "soon":
+				return nil, &NotYetActiveError{Id: id, ActivationDate: time.Now().Add(time.Hour)}
+			case "limited":
+				if DefaultBotClassifier().IsBot(md) || md.Method == http.MethodHead {
+					return nil, &ClickLimitedError{Id: id}
+				}
+				return &Resolution{Url: "https://test.com/once", RedirectType: http.StatusFound}, nil
+			case "blocked":
+				return nil, &BlockedUrlError{Url: "https://evil.com", Reason: "the domain is denied"}
+			case "tenant":
+				return &Resolution{Url: "https://test.com/" + TenantFromContext(ctx) + "/" + DomainFromContext(ctx)}, nil
+			default:
+				return nil, errors.New("internal error")
+			}
+		},
+	}
+
+	serve := func(h http.Handler, method string, target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("User-Agent", "Mozilla/5.0")
+		r.Header.Set("Referer", "https://ref.com")
+		r.Header.Set("Accept-Language", "en-US")
+		r.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	h := Handler(s, HandlerOptions{})
+
+	t.Run("redirect", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/found")
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, "https://test.com/path", w.Header().Get("Location"))
+		require.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+		require.Equal(t, &RequestMetadata{
+			Referrer:       "https://ref.com",
+			UserAgent:      "Mozilla/5.0",
+			ClientIP:       "192.0.2.1",
+			AcceptLanguage: "en-US",
+			Method:         http.MethodGet,
+		}, lastMetadata)
+	})
+
+	t.Run("head", func(t *testing.T) {
+		// The method is passed to Resolve, HEAD requests do not take the clicks of click-limited urls.
+		w := serve(h, http.MethodHead, "/found")
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, http.MethodHead, lastMetadata.Method)
+	})
+
+	t.Run("bot user agent", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/found", nil)
+		r.Header.Set("User-Agent", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, http.StatusFound, w.Code)
+		require.True(t, DefaultBotClassifier().IsBot(lastMetadata))
+	})
+
+	t.Run("click-limited", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/limited")
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, "https://test.com/once", w.Header().Get("Location"))
+
+		// Bots (including curl and an empty user agent) and HEAD requests are not given the url, not even in a preview.
+		h := Handler(s, HandlerOptions{BotPreview: true})
+		for _, test := range []struct{ method, userAgent string }{
+			{http.MethodGet, ""},
+			{http.MethodGet, "curl/8.4.0"},
+			{http.MethodHead, "Mozilla/5.0"},
+		} {
+			r := httptest.NewRequest(test.method, "/limited", nil)
+			r.Header.Set("User-Agent", test.userAgent)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			require.Equal(t, http.StatusForbidden, w.Code, test.userAgent)
+			require.Empty(t, w.Header().Get("Location"))
+			require.NotContains(t, w.Body.String(), "test.com")
+			require.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+		}
+	})
+
+	t.Run("permanent redirect", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/permanent")
+		require.Equal(t, http.StatusPermanentRedirect, w.Code)
+		require.Equal(t, "public, max-age=86400", w.Header().Get("Cache-Control"))
+
+		h := Handler(s, HandlerOptions{PermanentRedirectMaxAge: time.Hour})
+		w = serve(h, http.MethodGet, "/permanent")
+		require.Equal(t, "public, max-age=3600", w.Header().Get("Cache-Control"))
+
+		// A cached redirect would bypass the limits of a limited shortened url.
+		w = serve(h, http.MethodGet, "/expiring")
+		require.Equal(t, http.StatusMovedPermanently, w.Code)
+		require.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+	})
+
+	t.Run("default redirect type", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/bot")
+		require.Equal(t, http.StatusFound, w.Code)
+	})
+
+	t.Run("path prefix", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/s/found")
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		h := Handler(&resolveShortener{resolve: s.resolve, pathPrefix: "/s"}, HandlerOptions{})
+		w = serve(h, http.MethodGet, "/s/found")
+		require.Equal(t, http.StatusFound, w.Code)
+
+		for _, target := range []string{"/found", "/anything/else/found", "/s/else/found", "/sfound", "/s/"} {
+			w = serve(h, http.MethodGet, target)
+			require.Equal(t, http.StatusNotFound, w.Code, target)
+		}
+	})
+
+	t.Run("tenant domain", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "http://go.acme.com/tenant")
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, "https://test.com/acme/go.acme.com", w.Header().Get("Location"))
+
+		w = serve(h, http.MethodGet, "/tenant")
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, "https://test.com//", w.Header().Get("Location"))
+	})
+
+	t.Run("shortener domain", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "http://EU.short.com/tenant")
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, "https://test.com//eu.short.com", w.Header().Get("Location"))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/missing")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("blocked", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/blocked")
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("not yet active", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/soon")
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+	})
+
+	t.Run("gone", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/expired")
+		require.Equal(t, http.StatusGone, w.Code)
+		require.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+
+		w = serve(h, http.MethodGet, "/used")
+		require.Equal(t, http.StatusGone, w.Code)
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/inv@lid")
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		w = serve(h, http.MethodGet, "/")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("internal error", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/broken")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		w := serve(h, http.MethodDelete, "/found")
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		require.Equal(t, "GET, HEAD, POST", w.Header().Get("Allow"))
+	})
+
+	t.Run("bot without preview", func(t *testing.T) {
+		w := serve(h, http.MethodGet, "/bot")
+		require.Equal(t, http.StatusFound, w.Code)
+	})
+
+	t.Run("custom pages", func(t *testing.T) {
+		h := Handler(s, HandlerOptions{
+			NotFoundHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte("custom not found"))
+			}),
+			ErrorHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte("custom error"))
+			}),
+			BlockedHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnavailableForLegalReasons)
+				_, _ = w.Write([]byte("custom blocked"))
+			}),
+			NotYetActiveHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("coming soon"))
+			}),
+			GoneHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var expiredErr *ExpiredError
+				if errors.As(ResolveErrorFromContext(r.Context()), &expiredErr) {
+					_, _ = w.Write([]byte("expired on " + expiredErr.ExpirationDate.Format("2006-01-02")))
+					return
+				}
+				_, _ = w.Write([]byte("gone"))
+			}),
+		})
+
+		w := serve(h, http.MethodGet, "/missing")
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Equal(t, "custom not found", w.Body.String())
+
+		w = serve(h, http.MethodGet, "/broken")
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Equal(t, "custom error", w.Body.String())
+
+		w = serve(h, http.MethodGet, "/blocked")
+		require.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+		require.Equal(t, "custom blocked", w.Body.String())
+
+		w = serve(h, http.MethodGet, "/soon")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "coming soon", w.Body.String())
+
+		w = serve(h, http.MethodGet, "/expired")
+		require.Equal(t, "expired on 2024-01-02", w.Body.String())
+
+		w = serve(h, http.MethodGet, "/used")
+		require.Equal(t, "gone", w.Body.String())
+	})
+
+	t.Run("bot preview", func(t *testing.T) {
+		h := Handler(s, HandlerOptions{BotPreview: true})
+
+		w := serve(h, http.MethodGet, "/bot")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `<meta property="og:url" content="https://test.com/path">`)
+
+		w = serve(h, http.MethodGet, "/found")
+		require.Equal(t, http.StatusFound, w.Code)
+	})
+
+	t.Run("trust proxy headers", func(t *testing.T) {
+		h := Handler(s, HandlerOptions{TrustProxyHeaders: true})
+
+		serve(h, http.MethodGet, "/found")
+		require.Equal(t, "2.2.2.2", lastMetadata.ClientIP)
+
+		// The addresses before the one added by the proxy are set by the client.
+		r := httptest.NewRequest(http.MethodGet, "/found", nil)
+		r.Header.Add("X-Forwarded-For", "1.1.1.1")
+		r.Header.Add("X-Forwarded-For", "3.3.3.3, 4.4.4.4")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		require.Equal(t, "4.4.4.4", lastMetadata.ClientIP)
+	})
+
+	t.Run("not found rate limiter", func(t *testing.T) {
+		limiter, err := NewMemoryRateLimiter(1.0/60, 2)
+		require.Nil(t, err)
+		h := Handler(s, HandlerOptions{NotFoundRateLimiter: limiter})
+
+		require.Equal(t, http.StatusFound, serve(h, http.MethodGet, "/found").Code)
+		require.Equal(t, http.StatusNotFound, serve(h, http.MethodGet, "/missing").Code)
+		require.Equal(t, http.StatusNotFound, serve(h, http.MethodGet, "/inv@lid").Code)
+
+		// The bucket is empty, even existing ids are rejected.
+		w := serve(h, http.MethodGet, "/found")
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		require.Equal(t, "60", w.Header().Get("Retry-After"))
+
+		// Other clients are not limited.
+		r := httptest.NewRequest(http.MethodGet, "/found", nil)
+		r.RemoteAddr = "3.3.3.3:1234"
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, http.StatusFound, w.Code)
+	})
+
+	t.Run("password", func(t *testing.T) {
+		limiter, err := NewMemoryRateLimiter(1.0/60, 2)
+		require.Nil(t, err)
+		h := Handler(s, HandlerOptions{PasswordRateLimiter: limiter})
+
+		unlock := func(password string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, "/protected", strings.NewReader(url.Values{"password": {password}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			return w
+		}
+
+		w := serve(h, http.MethodGet, "/protected")
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Body.String(), `<form method="post">`)
+		require.NotContains(t, w.Body.String(), "incorrect")
+		require.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+
+		w = unlock("wrong")
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Body.String(), "incorrect")
+
+		// The unlock is never cached and never posts the password to the destination.
+		w = unlock("secret")
+		require.Equal(t, http.StatusSeeOther, w.Code)
+		require.Equal(t, "https://test.com/protected", w.Header().Get("Location"))
+		require.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+
+		w = unlock("wrong")
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// The bucket is empty, even the right password is rejected.
+		w = unlock("secret")
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		// Viewing the unlock form is not limited.
+		require.Equal(t, http.StatusUnauthorized, serve(h, http.MethodGet, "/protected").Code)
+	})
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	require.Equal(t, "1", RetryAfterSeconds(0))
+	require.Equal(t, "1", RetryAfterSeconds(100*time.Millisecond))
+	require.Equal(t, "2", RetryAfterSeconds(1100*time.Millisecond))
+	require.Equal(t, "60", RetryAfterSeconds(time.Minute))
+}
@@ -0,0 +1,96 @@
+package short
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// hllPrecision is the number of bits of the hash used to select a register (2^12 registers, ~1.6% standard error).
+const hllPrecision = 12
+
+const hllRegisters = 1 << hllPrecision
+
+// hyperLogLog estimates the number of distinct hashes added to it.
+// Sketches are merged by taking the maximum of each register, which allows the store to update them atomically with `$max`.
+type hyperLogLog struct {
+	registers [hllRegisters]uint8
+}
+
+// visitorHash returns a keyed hash of a visitor (ip + user agent).
+// Only the hash registers are kept, the ip and the user agent can not be recovered from the sketch.
+func visitorHash(salt string, ip string, userAgent string) uint64 {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(ip))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userAgent))
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// hllRegister returns the register index and the rank of a hash.
+func hllRegister(hash uint64) (int, uint8) {
+	index := int(hash >> (64 - hllPrecision))
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1
+	return index, rank
+}
+
+// hllRegisterKey returns the field name of a register in a store document.
+func hllRegisterKey(index int) string {
+	return "hll." + strconv.Itoa(index)
+}
+
+// newHyperLogLogFromRegisters creates a sketch from the registers stored in a document (register index => rank).
+func newHyperLogLogFromRegisters(registers map[string]int32) *hyperLogLog {
+	var h hyperLogLog
+	for key, rank := range registers {
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 || index >= hllRegisters {
+			continue
+		}
+		if uint8(rank) > h.registers[index] {
+			h.registers[index] = uint8(rank)
+		}
+	}
+	return &h
+}
+
+func (h *hyperLogLog) add(hash uint64) {
+	index, rank := hllRegister(hash)
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i, rank := range other.registers {
+		if rank > h.registers[i] {
+			h.registers[i] = rank
+		}
+	}
+}
+
+func (h *hyperLogLog) estimate() uint64 {
+	m := float64(hllRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	sum := 0.0
+	zeros := 0
+	for _, rank := range h.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+
+	// Small range correction (linear counting).
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
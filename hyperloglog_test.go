@@ -0,0 +1,73 @@
+package short
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	requireApproximately := func(t *testing.T, expected int, actual uint64) {
+		t.Helper()
+		require.InDelta(t, float64(expected), float64(actual), math.Max(2, 0.05*float64(expected)))
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		require.Equal(t, uint64(0), (&hyperLogLog{}).estimate())
+	})
+
+	t.Run("duplicates", func(t *testing.T) {
+		var h hyperLogLog
+		for i := 0; i < 1000; i++ {
+			h.add(visitorHash("salt", "1.2.3.4", "ua"))
+		}
+		require.Equal(t, uint64(1), h.estimate())
+	})
+
+	for _, n := range []int{10, 1000, 100000} {
+		n := n
+		t.Run(fmt.Sprintf("%d visitors", n), func(t *testing.T) {
+			var h hyperLogLog
+			for i := 0; i < n; i++ {
+				h.add(visitorHash("salt", fmt.Sprintf("10.0.%d.%d", i/256, i%256), "ua"))
+			}
+			requireApproximately(t, n, h.estimate())
+		})
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		var h1, h2 hyperLogLog
+		for i := 0; i < 2000; i++ {
+			h1.add(visitorHash("salt", fmt.Sprint(i), "ua"))
+		}
+		for i := 1000; i < 3000; i++ {
+			h2.add(visitorHash("salt", fmt.Sprint(i), "ua"))
+		}
+		h1.merge(&h2)
+		requireApproximately(t, 3000, h1.estimate())
+	})
+
+	t.Run("registers", func(t *testing.T) {
+		var h hyperLogLog
+		registers := map[string]int32{}
+		for i := 0; i < 500; i++ {
+			hash := visitorHash("salt", fmt.Sprint(i), "ua")
+			h.add(hash)
+			index, rank := hllRegister(hash)
+			key := hllRegisterKey(index)[len("hll."):]
+			if int32(rank) > registers[key] {
+				registers[key] = int32(rank)
+			}
+		}
+		require.Equal(t, h.estimate(), newHyperLogLogFromRegisters(registers).estimate())
+	})
+}
+
+func TestVisitorHash(t *testing.T) {
+	require.Equal(t, visitorHash("salt", "1.2.3.4", "ua"), visitorHash("salt", "1.2.3.4", "ua"))
+	require.NotEqual(t, visitorHash("salt", "1.2.3.4", "ua"), visitorHash("other", "1.2.3.4", "ua"))
+	require.NotEqual(t, visitorHash("salt", "1.2.3.4", "ua"), visitorHash("salt", "1.2.3.5", "ua"))
+	require.NotEqual(t, visitorHash("salt", "1.2.3.4", "ua"), visitorHash("salt", "1.2.3.4ua", ""))
+}
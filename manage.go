@@ -0,0 +1,258 @@
+package short
+
+import (
+	"context"
+	"time"
+)
+
+// defaultListLimit and maxListLimit are the default and maximum number of shortened urls returned by `ListShortenedUrls`.
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// LinkState is the state of an id (see `ShortenedUrlInfo.State`).
+type LinkState int
+
+const (
+	// LinkMissing is an id that does not exist.
+	LinkMissing LinkState = iota
+	// LinkActive is a shortened url that resolves (unless it is not active yet, see `UrlConfig.WithActivationDate`).
+	LinkActive
+	// LinkExpired is a shortened url after its expiration date.
+	LinkExpired
+	// LinkDisabled is a click-limited shortened url that used up its clicks.
+	LinkDisabled
+	// LinkTombstoned is a shortened url that was deleted.
+	LinkTombstoned
+)
+
+func (s LinkState) String() string {
+	switch s {
+	case LinkActive:
+		return "active"
+	case LinkExpired:
+		return "expired"
+	case LinkDisabled:
+		return "disabled"
+	case LinkTombstoned:
+		return "deleted"
+	default:
+		return "missing"
+	}
+}
+
+// ShortenedUrlInfo holds the details of a shortened url.
+type ShortenedUrlInfo struct {
+	Id string
+	// ShortUrl is the shortened url (e.g. https://short.com/abCD123).
+	ShortUrl string
+	// Url is the original url.
+	Url string
+	// ExpirationDate is nil if the shortened url never expires.
+	ExpirationDate *time.Time
+	// RedirectType is 0 if the shortener default is used.
+	RedirectType int
+	// CreatedAt is nil for shortened urls that were created before it was recorded.
+	CreatedAt *time.Time
+	// Owner is the id of the api key that owns the shortened url (empty if not owned).
+	Owner string
+	// Flagged is true if the url is on a third-party shortener domain (see `Config.WithShortenerDomains`).
+	Flagged bool
+	// PasswordProtected is true if the shortened url requires a password (see `UrlConfig.WithPassword`).
+	PasswordProtected bool
+	// RemainingClicks is the number of resolves left of a click-limited shortened url (nil if unlimited, see `UrlConfig.WithMaxClicks`).
+	RemainingClicks *int64
+	// ActivationDate is nil if the shortened url is active immediately (see `UrlConfig.WithActivationDate`).
+	ActivationDate *time.Time
+	// SlidingTtl is the ttl the expiration date is extended to on each resolve (0 if the expiration date is fixed).
+	SlidingTtl time.Duration
+	// State is `LinkActive`, `LinkExpired` or `LinkDisabled` (expired and used up shortened urls are kept until they are deleted).
+	State LinkState
+}
+
+// ListOptions may be used to page through the shortened urls.
+type ListOptions struct {
+	// Limit is the maximum number of shortened urls returned (default 100, maximum 1000).
+	Limit int
+	// Cursor is the `NextCursor` of the previous page (empty for the first page).
+	Cursor string
+	// Owner returns only the shortened urls owned by the api key `Owner` (optional).
+	Owner string
+}
+
+// ListResult is a page of shortened urls.
+type ListResult struct {
+	ShortenedUrls []*ShortenedUrlInfo
+	// NextCursor is empty if there are no more pages.
+	NextCursor string
+}
+
+// BatchItem is a shortened url to create with `CreateShortenedUrls`.
+type BatchItem struct {
+	Url string
+	// Config may be nil (see `DefaultUrlConfig()`).
+	Config UrlConfig
+}
+
+// BatchResult is the result of creating a BatchItem.
+// Exactly one of ShortenedUrl and Err is set.
+type BatchResult struct {
+	ShortenedUrl ShortenedURL
+	Err          error
+}
+
+func (s *shortner) newShortenedUrlInfo(rec *record, host string) *ShortenedUrlInfo {
+	if rec.Domain != "" {
+		host = rec.Domain
+	}
+
+	info := ShortenedUrlInfo{
+		Id:                rec.Id,
+		ShortUrl:          s.newShortenedUrl(rec.Id, host).GetUrl(),
+		Url:               rec.Url,
+		RedirectType:      rec.RedirectType,
+		Owner:             rec.Owner,
+		Flagged:           rec.Flagged,
+		PasswordProtected: rec.PasswordHash != "",
+		RemainingClicks:   rec.RemainingClicks,
+		SlidingTtl:        time.Duration(rec.SlidingTtl) * time.Second,
+		State:             rec.state(time.Now()),
+	}
+
+	if rec.ExpireAt != nil {
+		expirationDate := time.Unix(*rec.ExpireAt, 0)
+		info.ExpirationDate = &expirationDate
+	}
+
+	if rec.ActivateAt != nil {
+		activationDate := time.Unix(*rec.ActivateAt, 0)
+		info.ActivationDate = &activationDate
+	}
+
+	if rec.CreatedAt != 0 {
+		createdAt := time.Unix(rec.CreatedAt, 0)
+		info.CreatedAt = &createdAt
+	}
+
+	return &info
+}
+
+func (s *shortner) GetShortenedUrlInfo(ctx context.Context, id string) (*ShortenedUrlInfo, error) {
+	if err := validateId(id); err != nil {
+		return nil, err
+	}
+
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The details of expired and used up shortened urls are returned, e.g. to extend their expiration.
+	rec, state, err := ns.store.GetUrlState(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if state == LinkMissing || state == LinkTombstoned {
+		return nil, &IdNotFoundError{Id: id}
+	}
+
+	return s.newShortenedUrlInfo(rec, ns.host), nil
+}
+
+func (s *shortner) ListShortenedUrls(ctx context.Context, options ListOptions) (*ListResult, error) {
+	limit := options.Limit
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+	if limit < 0 || limit > maxListLimit {
+		return nil, newValidationError("the limit must be between 1 and %d", maxListLimit)
+	}
+
+	if options.Cursor != "" && !isAlphaNumeric(options.Cursor) {
+		return nil, newValidationError("invalid cursor %s", options.Cursor)
+	}
+
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch one more record to find out if there is a next page.
+	records, err := ns.store.List(ctx, &listQuery{owner: options.Owner, after: options.Cursor, limit: limit + 1})
+	if err != nil {
+		return nil, err
+	}
+
+	var result ListResult
+
+	if len(records) > limit {
+		records = records[:limit]
+		result.NextCursor = records[limit-1].Id
+	}
+
+	for _, rec := range records {
+		result.ShortenedUrls = append(result.ShortenedUrls, s.newShortenedUrlInfo(rec, ns.host))
+	}
+
+	return &result, nil
+}
+
+func (s *shortner) UpdateDestination(ctx context.Context, id string, url string) error {
+	if err := validateId(id); err != nil {
+		return err
+	}
+
+	url, flagged, err := s.destination(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ns.store.Update(ctx, id, url, flagged)
+}
+
+func (s *shortner) SetExpiration(ctx context.Context, id string, expiration *time.Time) error {
+	if err := validateId(id); err != nil {
+		return err
+	}
+
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ns.store.SetExpiration(ctx, id, expiration)
+}
+
+func (s *shortner) DeleteShortenedUrl(ctx context.Context, id string) error {
+	if err := validateId(id); err != nil {
+		return err
+	}
+
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ns.store.Delete(ctx, id)
+}
+
+func (s *shortner) CreateShortenedUrls(ctx context.Context, items []BatchItem) []BatchResult {
+	results := make([]BatchResult, len(items))
+
+	for i, item := range items {
+		var config []UrlConfig
+		if item.Config != nil {
+			config = append(config, item.Config)
+		}
+
+		results[i].ShortenedUrl, results[i].Err = s.CreateShortenedUrl(ctx, item.Url, config...)
+	}
+
+	return results
+}
@@ -0,0 +1,73 @@
+package short
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxPasswordLength is the maximum length of a password (bcrypt ignores the bytes after the 72nd).
+const maxPasswordLength = 72
+
+type passwordKey struct{}
+
+// ContextWithPassword returns a context with the password of a password-protected shortened url (see `UrlConfig.WithPassword`).
+func ContextWithPassword(ctx context.Context, password string) context.Context {
+	return context.WithValue(ctx, passwordKey{}, password)
+}
+
+// PasswordFromContext returns the password of the context (empty if not set).
+func PasswordFromContext(ctx context.Context) string {
+	password, _ := ctx.Value(passwordKey{}).(string)
+	return password
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) == 0 || len(password) > maxPasswordLength {
+		return "", newValidationError("the password must be 1 to %d bytes long", maxPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash the password: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+// checkPassword returns a `PasswordRequiredError` if the password of the context does not match `hash`.
+func checkPassword(ctx context.Context, id string, hash string) error {
+	password := PasswordFromContext(ctx)
+	if password == "" || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return &PasswordRequiredError{Id: id}
+	}
+
+	return nil
+}
+
+var unlockTemplate = template.Must(template.New("unlock").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Password required</title>
+<meta name="robots" content="noindex">
+</head>
+<body>
+<form method="post">
+<p>This link is password protected.</p>
+{{if .Failed}}<p>The password is incorrect.</p>
+{{end}}<input type="password" name="password" autofocus required>
+<button type="submit">Unlock</button>
+</form>
+</body>
+</html>
+`))
+
+// WriteUnlockForm writes an html form that posts the password of a password-protected shortened url.
+// `failed` shows that the previous password was incorrect.
+func WriteUnlockForm(w io.Writer, failed bool) error {
+	return unlockTemplate.Execute(w, struct{ Failed bool }{Failed: failed})
+}
@@ -0,0 +1,45 @@
+package short
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+)
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Host}}</title>
+<meta name="robots" content="noindex">
+<meta property="og:type" content="website">
+<meta property="og:title" content="{{.Host}}">
+<meta property="og:description" content="{{.Url}}">
+<meta property="og:url" content="{{.Url}}">
+<meta name="twitter:card" content="summary">
+<meta name="twitter:title" content="{{.Host}}">
+<meta name="twitter:description" content="{{.Url}}">
+</head>
+<body>
+<a href="{{.Url}}">{{.Url}}</a>
+</body>
+</html>
+`))
+
+// WritePreview writes an html page with the metadata (Open Graph and Twitter card) of a shortened url destination.
+// It may be served to bots instead of a redirect (see `Resolution.Bot`).
+func WritePreview(w io.Writer, destination string) error {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return fmt.Errorf("invalid url %s: %w", destination, err)
+	}
+
+	return previewTemplate.Execute(w, struct {
+		Host string
+		Url  string
+	}{
+		Host: u.Hostname(),
+		Url:  destination,
+	})
+}
@@ -0,0 +1,23 @@
+package short
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePreview(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.Nil(t, WritePreview(&buf, "https://test.com/path?a=b&c=\"d\""))
+		require.Contains(t, buf.String(), `<title>test.com</title>`)
+		require.Contains(t, buf.String(), `<meta property="og:url" content="https://test.com/path?a=b&amp;c=&#34;d&#34;">`)
+		require.Contains(t, buf.String(), `<a href="https://test.com/path?a=b&amp;c=%22d%22">`)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.Error(t, WritePreview(&buf, "https://test com/%%"))
+	})
+}
@@ -0,0 +1,251 @@
+package short
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const rateLimitsCollectionName = "rate_limits"
+
+// memoryRateLimiterSweepSize is the minimum number of buckets before idle buckets are removed.
+const memoryRateLimiterSweepSize = 10000
+
+// RateLimiter limits the rate of operations per key (e.g. per client ip or per api key) with token buckets.
+type RateLimiter interface {
+	// Allow takes `n` tokens from the bucket of `key` if the bucket has at least `n` tokens.
+	// If it does not, no tokens are taken and false is returned with the time until enough tokens are available.
+	// If `n` is 0 no tokens are taken, and true is returned if the bucket has at least one token.
+	Allow(ctx context.Context, key string, n int) (bool, time.Duration, error)
+}
+
+// tokenBucket is the state of the bucket of a key.
+// A bucket holds up to `burst` tokens and is refilled with `rate` tokens per second.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type memoryRateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	sweepSize int
+}
+
+func validateRateLimit(rate float64, burst int) error {
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return newValidationError("invalid rate limit rate %v (must be positive)", rate)
+	}
+
+	if burst <= 0 {
+		return newValidationError("invalid rate limit burst %d (must be positive)", burst)
+	}
+
+	return nil
+}
+
+// requiredTokens returns the number of tokens the bucket must hold to allow taking `n` tokens.
+func requiredTokens(n int, burst float64) (float64, error) {
+	if n < 0 || float64(n) > burst {
+		return 0, newValidationError("the number of tokens %d must be between 0 and the burst %v", n, burst)
+	}
+
+	if n == 0 {
+		return 1, nil
+	}
+
+	return float64(n), nil
+}
+
+// retryAfter returns the time until a bucket with `tokens` tokens holds `required` tokens.
+func retryAfter(tokens float64, required float64, rate float64) time.Duration {
+	return time.Duration(math.Ceil((required - tokens) / rate * float64(time.Second)))
+}
+
+// NewMemoryRateLimiter creates a rate limiter that keeps the token buckets in memory.
+// A bucket holds up to `burst` tokens and is refilled with `rate` tokens per second.
+// The buckets are not shared between instances, see `NewMongoRateLimiter` for multi-instance deployments.
+func NewMemoryRateLimiter(rate float64, burst int) (RateLimiter, error) {
+	if err := validateRateLimit(rate, burst); err != nil {
+		return nil, err
+	}
+
+	return &memoryRateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		now:       time.Now,
+		buckets:   map[string]*tokenBucket{},
+		sweepSize: memoryRateLimiterSweepSize,
+	}, nil
+}
+
+func (l *memoryRateLimiter) Allow(ctx context.Context, key string, n int) (bool, time.Duration, error) {
+	required, err := requiredTokens(n, l.burst)
+	if err != nil {
+		return false, 0, err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.sweepSize {
+			l.sweep(now)
+		}
+		b = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+
+	l.refill(b, now)
+
+	if b.tokens < required {
+		return false, retryAfter(b.tokens, required, l.rate), nil
+	}
+
+	b.tokens -= float64(n)
+
+	return true, 0, nil
+}
+
+func (l *memoryRateLimiter) refill(b *tokenBucket, now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.updated = now
+	}
+}
+
+// sweep removes the full buckets (a missing bucket is the same as a full bucket).
+func (l *memoryRateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+
+	// Do not sweep on every new key when most buckets are in use.
+	l.sweepSize = memoryRateLimiterSweepSize
+	if 2*len(l.buckets) > l.sweepSize {
+		l.sweepSize = 2 * len(l.buckets)
+	}
+}
+
+type mongoRateLimiter struct {
+	name       string
+	rate       float64
+	burst      float64
+	collection *mongo.Collection
+}
+
+// NewMongoRateLimiter creates a rate limiter that keeps the token buckets in MongoDB, so they are shared between instances.
+// A bucket holds up to `burst` tokens and is refilled with `rate` tokens per second.
+// The buckets are stored in the `rate_limits` collection of the database in `mongoUri`.
+// `name` separates the buckets of different rate limiters (e.g. "create" and "not-found").
+func NewMongoRateLimiter(mongoUri string, name string, rate float64, burst int) (RateLimiter, error) {
+	if err := validateRateLimit(rate, burst); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	database, err := getMongoDatabase(ctx, mongoUri)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := database.Collection(rateLimitsCollectionName)
+	// Index the collection.
+	if _, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"key": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.M{"expireAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}}); err != nil {
+		return nil, fmt.Errorf("failed to create an index for collection %s: %w", rateLimitsCollectionName, err)
+	}
+
+	return &mongoRateLimiter{
+		name:       name,
+		rate:       rate,
+		burst:      float64(burst),
+		collection: collection,
+	}, nil
+}
+
+func (l *mongoRateLimiter) Allow(ctx context.Context, key string, n int) (bool, time.Duration, error) {
+	required, err := requiredTokens(n, l.burst)
+	if err != nil {
+		return false, 0, err
+	}
+
+	now := time.Now()
+	nowMs := now.UnixMilli()
+
+	// The bucket is refilled and taken from in a single atomic update.
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tokens": bson.M{"$min": bson.A{
+				l.burst,
+				bson.M{"$add": bson.A{
+					bson.M{"$ifNull": bson.A{"$tokens", l.burst}},
+					bson.M{"$multiply": bson.A{
+						bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{nowMs, bson.M{"$ifNull": bson.A{"$updatedAt", nowMs}}}}}},
+						l.rate / 1000,
+					}},
+				}},
+			}},
+			"updatedAt": nowMs,
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"allowed": bson.M{"$gte": bson.A{"$tokens", required}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"tokens": bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", n}}, "$tokens"}},
+			// An idle bucket is full after burst/rate seconds, and then it is the same as a missing bucket.
+			// TTL indexes require a date.
+			"expireAt": now.Add(time.Duration(l.burst / l.rate * float64(time.Second))),
+		}}},
+	}
+
+	res := l.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"key": l.name + ":" + key},
+		pipeline,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	)
+	if res.Err() != nil {
+		return false, 0, fmt.Errorf("failed to update the rate limit bucket of %s: %w", key, res.Err())
+	}
+
+	var payload struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+
+	if err := res.Decode(&payload); err != nil {
+		return false, 0, fmt.Errorf("failed to decode the rate limit bucket of %s: %w", key, err)
+	}
+
+	if !payload.Allowed {
+		return false, retryAfter(payload.Tokens, required, l.rate), nil
+	}
+
+	return true, 0, nil
+}
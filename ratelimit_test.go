@@ -0,0 +1,151 @@
+package short
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	t.Run("invalid arguments", func(t *testing.T) {
+		var verr *ValidationError
+
+		_, err := NewMemoryRateLimiter(0, 1)
+		require.ErrorAs(t, err, &verr)
+		_, err = NewMemoryRateLimiter(1, 0)
+		require.ErrorAs(t, err, &verr)
+
+		limiter, err := NewMemoryRateLimiter(1, 2)
+		require.Nil(t, err)
+		_, _, err = limiter.Allow(context.Background(), "a", 3)
+		require.ErrorAs(t, err, &verr)
+		_, _, err = limiter.Allow(context.Background(), "a", -1)
+		require.ErrorAs(t, err, &verr)
+	})
+
+	t.Run("token bucket", func(t *testing.T) {
+		now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+		l, err := NewMemoryRateLimiter(2, 3)
+		require.Nil(t, err)
+		limiter := l.(*memoryRateLimiter)
+		limiter.now = func() time.Time { return now }
+
+		allow := func(key string, n int) (bool, time.Duration) {
+			allowed, retryAfter, err := limiter.Allow(context.Background(), key, n)
+			require.Nil(t, err)
+			return allowed, retryAfter
+		}
+
+		for i := 0; i < 3; i++ {
+			allowed, _ := allow("a", 1)
+			require.True(t, allowed)
+		}
+
+		allowed, retryAfter := allow("a", 1)
+		require.False(t, allowed)
+		require.Equal(t, 500*time.Millisecond, retryAfter)
+
+		allowed, _ = allow("a", 0)
+		require.False(t, allowed)
+
+		// Other keys have their own buckets.
+		allowed, _ = allow("b", 3)
+		require.True(t, allowed)
+
+		now = now.Add(time.Second)
+		allowed, _ = allow("a", 0)
+		require.True(t, allowed)
+
+		// Two tokens were added, a request for three tokens takes none.
+		allowed, retryAfter = allow("a", 3)
+		require.False(t, allowed)
+		require.Equal(t, 500*time.Millisecond, retryAfter)
+		allowed, _ = allow("a", 2)
+		require.True(t, allowed)
+
+		// The bucket does not grow beyond the burst.
+		now = now.Add(time.Hour)
+		allowed, _ = allow("a", 3)
+		require.True(t, allowed)
+		allowed, _ = allow("a", 1)
+		require.False(t, allowed)
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+		l, err := NewMemoryRateLimiter(1, 1)
+		require.Nil(t, err)
+		limiter := l.(*memoryRateLimiter)
+		limiter.now = func() time.Time { return now }
+		limiter.sweepSize = 2
+
+		_, _, _ = limiter.Allow(context.Background(), "a", 1)
+		_, _, _ = limiter.Allow(context.Background(), "b", 1)
+		require.Len(t, limiter.buckets, 2)
+
+		now = now.Add(time.Second)
+		_, _, _ = limiter.Allow(context.Background(), "c", 1)
+		require.Len(t, limiter.buckets, 1)
+		require.Contains(t, limiter.buckets, "c")
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		limiter, err := NewMemoryRateLimiter(0.001, 50)
+		require.Nil(t, err)
+
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		allowedCount := 0
+
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				allowed, _, err := limiter.Allow(context.Background(), "a", 1)
+				require.Nil(t, err)
+				if allowed {
+					lock.Lock()
+					allowedCount++
+					lock.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		require.Equal(t, 50, allowedCount)
+	})
+}
+
+func TestMongoRateLimiter(t *testing.T) {
+	uri := getRandomMongoURIForTesting()
+
+	limiter, err := NewMongoRateLimiter(uri, "test", 1.0/60, 2)
+	require.Nil(t, err)
+
+	other, err := NewMongoRateLimiter(uri, "other", 1.0/60, 2)
+	require.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		allowed, _, err := limiter.Allow(context.Background(), "a", 1)
+		require.Nil(t, err)
+		require.True(t, allowed, fmt.Sprintf("request %d", i))
+	}
+
+	allowed, retryAfter, err := limiter.Allow(context.Background(), "a", 1)
+	require.Nil(t, err)
+	require.False(t, allowed)
+	require.InDelta(t, time.Minute.Seconds(), retryAfter.Seconds(), 1)
+
+	// Buckets are per key and per limiter name.
+	allowed, _, err = limiter.Allow(context.Background(), "b", 2)
+	require.Nil(t, err)
+	require.True(t, allowed)
+
+	allowed, _, err = other.Allow(context.Background(), "a", 2)
+	require.Nil(t, err)
+	require.True(t, allowed)
+}
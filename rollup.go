@@ -0,0 +1,177 @@
+package short
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Granularity is the size of the time buckets of a time series.
+type Granularity string
+
+const (
+	GranularityHour Granularity = "hour"
+	GranularityDay  Granularity = "day"
+)
+
+// maxRollupValueLength is the maximum length of a referrer or a user agent kept in a rollup document.
+const maxRollupValueLength = 256
+
+// topCountsLimit is the number of entries returned in the top referrers and top user agents.
+const topCountsLimit = 10
+
+// maxTimeSeriesBuckets is the maximum number of buckets of a time series (e.g. about 41 days of hourly buckets).
+const maxTimeSeriesBuckets = 1000
+
+func (g Granularity) validate() error {
+	switch g {
+	case GranularityHour, GranularityDay:
+		return nil
+	default:
+		return newValidationError("invalid granularity %s", g)
+	}
+}
+
+// bucketStart returns the start (UTC) of the time bucket that contains `t`.
+func (g Granularity) bucketStart(t time.Time) time.Time {
+	t = t.UTC()
+	if g == GranularityDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// buckets returns the number of time buckets in the time range [from, to).
+func (g Granularity) buckets(from time.Time, to time.Time) int64 {
+	size := time.Hour
+	if g == GranularityDay {
+		size = 24 * time.Hour
+	}
+	// Sub saturates, ranges of hundreds of years do not overflow.
+	d := to.Sub(g.bucketStart(from))
+	n := int64(d / size)
+	if d%size != 0 {
+		n++
+	}
+	return n
+}
+
+// next returns the start of the time bucket that follows the bucket that starts at `t`.
+func (g Granularity) next(t time.Time) time.Time {
+	if g == GranularityDay {
+		return t.AddDate(0, 0, 1)
+	}
+	return t.Add(time.Hour)
+}
+
+// rollupKey identifies a rollup document.
+type rollupKey struct {
+	id          string
+	granularity Granularity
+	start       int64
+}
+
+// rollup holds the counters that are added to a rollup document.
+type rollup struct {
+	clicks     int64
+	botClicks  int64
+	referrers  map[string]int64
+	userAgents map[string]int64
+	countries  map[string]int64
+	regions    map[string]int64
+	// visitors holds the hyperloglog registers of the unique visitors (register index => rank).
+	visitors map[int]uint8
+}
+
+// aggregateClickEvents groups click events into hourly and daily rollups.
+func aggregateClickEvents(events []ClickEvent) map[rollupKey]*rollup {
+	rollups := map[rollupKey]*rollup{}
+
+	for _, event := range events {
+		for _, granularity := range []Granularity{GranularityHour, GranularityDay} {
+			key := rollupKey{id: event.Id, granularity: granularity, start: granularity.bucketStart(event.Time).Unix()}
+
+			r, ok := rollups[key]
+			if !ok {
+				r = &rollup{
+					referrers:  map[string]int64{},
+					userAgents: map[string]int64{},
+					countries:  map[string]int64{},
+					regions:    map[string]int64{},
+					visitors:   map[int]uint8{},
+				}
+				rollups[key] = r
+			}
+
+			// Bot clicks are counted separately and are excluded from the other counters.
+			if event.Bot {
+				r.botClicks++
+				continue
+			}
+
+			r.clicks++
+			if event.Referrer != "" {
+				r.referrers[encodeRollupValue(event.Referrer)]++
+			}
+			if event.UserAgent != "" {
+				r.userAgents[encodeRollupValue(event.UserAgent)]++
+			}
+			if event.Country != "" {
+				r.countries[encodeRollupValue(event.Country)]++
+			}
+			if event.Region != "" {
+				r.regions[encodeRollupValue(event.Region)]++
+			}
+			if event.visitorHash != 0 {
+				index, rank := hllRegister(event.visitorHash)
+				if rank > r.visitors[index] {
+					r.visitors[index] = rank
+				}
+			}
+		}
+	}
+
+	return rollups
+}
+
+// encodeRollupValue encodes a value so it may be used as a MongoDB field name (field names may not contain `.` or start with `$`).
+func encodeRollupValue(value string) string {
+	if len(value) > maxRollupValueLength {
+		value = value[:maxRollupValueLength]
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+func decodeRollupValue(key string) (string, error) {
+	value, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode rollup key %s: %w", key, err)
+	}
+	return string(value), nil
+}
+
+// topCounts decodes the keys of `counts` and returns the `limit` entries with the highest counts.
+func topCounts(counts map[string]int64, limit int) ([]Count, error) {
+	top := make([]Count, 0, len(counts))
+	for key, count := range counts {
+		value, err := decodeRollupValue(key)
+		if err != nil {
+			return nil, err
+		}
+		top = append(top, Count{Value: value, Count: count})
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Value < top[j].Value
+	})
+
+	if len(top) > limit {
+		top = top[:limit]
+	}
+
+	return top, nil
+}
@@ -0,0 +1,108 @@
+package short
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGranularity(t *testing.T) {
+	tm := time.Date(2022, 10, 5, 13, 45, 10, 0, time.UTC)
+
+	t.Run("validate", func(t *testing.T) {
+		require.Nil(t, GranularityHour.validate())
+		require.Nil(t, GranularityDay.validate())
+		require.Error(t, Granularity("minute").validate())
+	})
+
+	t.Run("hour", func(t *testing.T) {
+		start := GranularityHour.bucketStart(tm)
+		require.Equal(t, time.Date(2022, 10, 5, 13, 0, 0, 0, time.UTC), start)
+		require.Equal(t, time.Date(2022, 10, 5, 14, 0, 0, 0, time.UTC), GranularityHour.next(start))
+	})
+
+	t.Run("day", func(t *testing.T) {
+		start := GranularityDay.bucketStart(tm)
+		require.Equal(t, time.Date(2022, 10, 5, 0, 0, 0, 0, time.UTC), start)
+		require.Equal(t, time.Date(2022, 10, 6, 0, 0, 0, 0, time.UTC), GranularityDay.next(start))
+	})
+
+	t.Run("buckets", func(t *testing.T) {
+		require.Equal(t, int64(2), GranularityHour.buckets(tm, tm.Add(time.Hour)))
+		require.Equal(t, int64(1), GranularityDay.buckets(tm, tm.Add(time.Hour)))
+		require.Equal(t, int64(7), GranularityDay.buckets(GranularityDay.bucketStart(tm), GranularityDay.bucketStart(tm).AddDate(0, 0, 7)))
+		require.Greater(t, GranularityHour.buckets(time.Time{}, tm), int64(maxTimeSeriesBuckets))
+	})
+}
+
+func TestAggregateClickEvents(t *testing.T) {
+	tm := time.Date(2022, 10, 5, 13, 45, 10, 0, time.UTC)
+
+	rollups := aggregateClickEvents([]ClickEvent{
+		{Id: "id", Time: tm, Referrer: "https://a.com", UserAgent: "ua", Country: "US", Region: "US-CA"},
+		{Id: "id", Time: tm.Add(time.Hour), Referrer: "https://a.com", Country: "US"},
+	})
+
+	require.Len(t, rollups, 3)
+
+	day := rollups[rollupKey{id: "id", granularity: GranularityDay, start: GranularityDay.bucketStart(tm).Unix()}]
+	require.NotNil(t, day)
+	require.Equal(t, int64(2), day.clicks)
+	require.Equal(t, int64(2), day.referrers[encodeRollupValue("https://a.com")])
+	require.Equal(t, int64(1), day.userAgents[encodeRollupValue("ua")])
+	require.Equal(t, int64(2), day.countries[encodeRollupValue("US")])
+	require.Equal(t, int64(1), day.regions[encodeRollupValue("US-CA")])
+
+	hour := rollups[rollupKey{id: "id", granularity: GranularityHour, start: GranularityHour.bucketStart(tm).Unix()}]
+	require.NotNil(t, hour)
+	require.Equal(t, int64(1), hour.clicks)
+}
+
+func TestAggregateBotClickEvents(t *testing.T) {
+	tm := time.Date(2022, 10, 5, 13, 45, 10, 0, time.UTC)
+
+	rollups := aggregateClickEvents([]ClickEvent{
+		{Id: "id", Time: tm, Referrer: "https://a.com", UserAgent: "Googlebot", Bot: true},
+		{Id: "id", Time: tm, Referrer: "https://a.com", UserAgent: "Mozilla/5.0"},
+	})
+
+	hour := rollups[rollupKey{id: "id", granularity: GranularityHour, start: GranularityHour.bucketStart(tm).Unix()}]
+	require.NotNil(t, hour)
+	require.Equal(t, int64(1), hour.clicks)
+	require.Equal(t, int64(1), hour.botClicks)
+	require.Equal(t, int64(1), hour.referrers[encodeRollupValue("https://a.com")])
+	require.NotContains(t, hour.userAgents, encodeRollupValue("Googlebot"))
+}
+
+func TestRollupValue(t *testing.T) {
+	t.Run("encode", func(t *testing.T) {
+		key := encodeRollupValue("https://a.com/$path.html")
+		require.NotContains(t, key, ".")
+		require.NotContains(t, key, "$")
+
+		value, err := decodeRollupValue(key)
+		require.Nil(t, err)
+		require.Equal(t, "https://a.com/$path.html", value)
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		value, err := decodeRollupValue(encodeRollupValue(strings.Repeat("a", 1000)))
+		require.Nil(t, err)
+		require.Len(t, value, maxRollupValueLength)
+	})
+}
+
+func TestTopCounts(t *testing.T) {
+	counts := map[string]int64{
+		encodeRollupValue("a"): 1,
+		encodeRollupValue("b"): 3,
+		encodeRollupValue("c"): 2,
+		encodeRollupValue("d"): 2,
+	}
+
+	top, err := topCounts(counts, 3)
+	require.Nil(t, err)
+	require.Equal(t, []Count{{Value: "b", Count: 3}, {Value: "c", Count: 2}, {Value: "d", Count: 2}}, top)
+}
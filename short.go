@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 )
 
 type ShortenedURL interface {
@@ -17,15 +20,118 @@ type Shortener interface {
 	CreateShortenedUrl(ctx context.Context, url string, config ...UrlConfig) (ShortenedURL, error)
 	// GetUrlFromShortenedUrl receives a shortened url `surl` and returns the original url.
 	// E.g.: https://short.com/abCD123
+	// A url that is not a shortened url of the shortener (see `Config.WithAliasDomains`) fails with a `ForeignHostError`.
 	GetUrlFromShortenedUrl(ctx context.Context, surl string) (string, error)
 	// GetUrlFromShortenedUrl receives a shortened url `id` and returns the original url.
 	// E.g.: abCD123
 	GetUrlFromShortenedUrlId(ctx context.Context, id string) (string, error)
+	// GetStats returns the click statistics of a shortened url `id`.
+	// E.g.: abCD123
+	GetStats(ctx context.Context, id string) (*Stats, error)
+	// Resolve receives a shortened url `id` and the metadata of the request that resolves it.
+	// It returns the original url and delivers a click event to the configured click sinks.
+	// A url that is not allowed by the url policy fails with a `BlockedUrlError` (see `Config.WithUrlPolicy`),
+	// and a password-protected url fails with a `PasswordRequiredError` unless the password is in the context (see `ContextWithPassword`).
+	// A url resolved before its activation date fails with a `NotYetActiveError` (see `UrlConfig.WithActivationDate`),
+	// an expired url with an `ExpiredError`, and a deleted or used up url with a `GoneError`.
+	// Every successful resolve takes one of the clicks of a click-limited url (see `UrlConfig.WithMaxClicks`),
+	// which fails with a `ClickLimitedError` for bots and HEAD requests, and extends a sliding expiration (see `UrlConfig.WithSlidingExpiration`).
+	// `md` may be nil.
+	Resolve(ctx context.Context, id string, md *RequestMetadata) (*Resolution, error)
+	// GetTimeSeries returns the clicks of a shortened url `id` in the time range [from, to).
+	// The clicks are grouped in hourly or daily buckets (see `granularity`), at most 1000 buckets per time series.
+	GetTimeSeries(ctx context.Context, id string, from time.Time, to time.Time, granularity Granularity) (*TimeSeries, error)
+	// GetShortenedUrlInfo returns the details of a shortened url `id`.
+	GetShortenedUrlInfo(ctx context.Context, id string) (*ShortenedUrlInfo, error)
+	// ListShortenedUrls returns a page of shortened urls ordered by id.
+	ListShortenedUrls(ctx context.Context, options ListOptions) (*ListResult, error)
+	// UpdateDestination changes the original url of an existing shortened url `id`.
+	UpdateDestination(ctx context.Context, id string, url string) error
+	// SetExpiration extends, shortens or removes (nil) the expiration date of an existing shortened url.
+	// The new expiration date is fixed, a sliding expiration stops.
+	SetExpiration(ctx context.Context, id string, expiration *time.Time) error
+	// DeleteShortenedUrl deletes a shortened url `id`.
+	DeleteShortenedUrl(ctx context.Context, id string) error
+	// CreateShortenedUrls creates multiple shortened urls.
+	// The result of each item is returned in the same position (an item failure does not fail the other items).
+	CreateShortenedUrls(ctx context.Context, items []BatchItem) []BatchResult
+	// CreateApiKey creates an api key with `scopes`.
+	// The returned key is not stored (only its hash is stored) and cannot be retrieved later.
+	CreateApiKey(ctx context.Context, name string, scopes ...Scope) (string, *ApiKey, error)
+	// AuthenticateApiKey returns the api key of `key`, or an `InvalidApiKeyError` if the key does not exist.
+	AuthenticateApiKey(ctx context.Context, key string) (*ApiKey, error)
+	// ListApiKeys returns all the api keys.
+	ListApiKeys(ctx context.Context) ([]*ApiKey, error)
+	// RevokeApiKey deletes the api key `id`.
+	RevokeApiKey(ctx context.Context, id string) error
+	// CreateTenant creates a tenant (see `ContextWithTenant`).
+	CreateTenant(ctx context.Context, tenant Tenant) (*Tenant, error)
+	// GetTenant returns the tenant `id`, or a `TenantNotFoundError` if the tenant does not exist.
+	GetTenant(ctx context.Context, id string) (*Tenant, error)
+	// GetTenantByDomain returns the tenant of `domain`, or a `TenantNotFoundError` if no tenant has the domain.
+	GetTenantByDomain(ctx context.Context, domain string) (*Tenant, error)
+	// UpdateTenant replaces the name, domains and quota of the tenant `tenant.Id`.
+	UpdateTenant(ctx context.Context, tenant Tenant) (*Tenant, error)
+	// ListTenants returns all the tenants.
+	ListTenants(ctx context.Context) ([]*Tenant, error)
+	// DeleteTenant deletes the tenant `id` with all its shortened urls, statistics and api keys.
+	DeleteTenant(ctx context.Context, id string) error
+	// Domains returns the host of the shortener followed by its additional domains (see `Config.WithDomains`).
+	Domains() []string
+	// PathPrefix returns the path of the shortened urls before the id (e.g. `/s`, empty for the root path, see `Config.WithBaseUrl`).
+	PathPrefix() string
+	// DroppedClickEvents returns the number of click events that were dropped because the click sinks did not keep up.
+	DroppedClickEvents() uint64
+	// Close delivers the pending click events and closes the click sinks and the geo resolver.
+	// It must be called once the shortener is no longer used: the click events are delivered by a goroutine
+	// that is started on the first resolve and runs until Close.
+	Close(ctx context.Context) error
+}
+
+// Resolution is the result of resolving a shortened url.
+type Resolution struct {
+	// Url is the original url.
+	Url string
+	// RedirectType is the http status code used to redirect to the original url (301, 302, 307 or 308).
+	// See `UrlConfig.WithRedirectType` and `Config.WithDefaultRedirectType`.
+	RedirectType int
+	// Bot is true if the request was classified as a bot request (see `Config.WithBotClassifier`).
+	// A bot may be served a preview (see `WritePreview`) instead of a redirect.
+	Bot bool
+	// Limited is true if the shortened url is click-limited, password-protected or expires
+	// (see `UrlConfig.WithMaxClicks`, `UrlConfig.WithPassword` and `UrlConfig.WithExpirationDate`),
+	// so a redirect to it must not be cached.
+	Limited bool
 }
 
 type shortner struct {
-	host  string
-	store Store
+	host   string
+	scheme string
+	// pathPrefix is the path of the shortened urls before the id (e.g. `/s`, empty for the root path).
+	pathPrefix string
+	mongoUri   string
+	store      Store
+	tenants    *tenantCache
+	// tenantStores are the stores of the tenants by tenant id.
+	tenantStores     map[string]Store
+	tenantStoresLock sync.Mutex
+	domains          []string
+	sharedAliases    bool
+	// domainStores are the stores of the domains by domain (see `Config.WithDomains`).
+	domainStores map[string]Store
+	// aliasDomains are hosts that are accepted as the host of the shortener (see `Config.WithAliasDomains`).
+	aliasDomains    map[string]bool
+	clickCounting   bool
+	clicks          *clickDispatcher
+	geoResolver     GeoResolver
+	visitorHashSalt string
+	botClassifier   BotClassifier
+	redirectType    int
+	urlPolicy       UrlPolicy
+	// selfLinks, shortenerDomains and shortenerDomainAction decide what happens to destinations that are shortened urls.
+	selfLinks             SelfLinkPolicy
+	shortenerDomains      []string
+	shortenerDomainAction ShortenerDomainAction
 }
 
 type shortenedUrl struct {
@@ -38,6 +144,7 @@ func (s *shortenedUrl) GetUrl() string {
 
 // NewShortener creates a new shortener.
 // If no configuration is passed uses the default configuration (see: `DefaultConfig()`)
+// The shortener must be closed with `Shortener.Close` once it is no longer used.
 func NewShortener(config ...Config) (Shortener, error) {
 	var c Config
 	var err error
@@ -60,37 +167,85 @@ func NewShortener(config ...Config) (Shortener, error) {
 	var s shortner
 
 	s.host = ci.host
+	s.scheme = ci.scheme
+	s.pathPrefix = ci.pathPrefix
+	s.mongoUri = ci.mongoUri
+	s.tenants = newTenantCache()
+	s.tenantStores = map[string]Store{}
+	s.clickCounting = ci.clickCounting
+	s.geoResolver = ci.geoResolver
+	s.visitorHashSalt = ci.visitorHashSalt
+	s.botClassifier = ci.botClassifier
+	s.redirectType = ci.redirectType
+	s.urlPolicy = ci.urlPolicy
+	s.selfLinks = ci.selfLinks
+	s.shortenerDomains = ci.shortenerDomains
+	s.shortenerDomainAction = ci.shortenerAction
 	s.store, err = newStore(ci.mongoUri, ci.host)
 	if err != nil {
 		return nil, err
 	}
 
+	s.aliasDomains = map[string]bool{}
+	for _, domain := range ci.aliasDomains {
+		s.aliasDomains[domain] = true
+	}
+
+	s.domains = ci.domains
+	s.sharedAliases = ci.sharedAliases
+	s.domainStores = map[string]Store{}
+	for _, domain := range ci.domains {
+		if domain == s.host {
+			continue
+		}
+		if ci.sharedAliases {
+			s.domainStores[domain] = s.store
+			continue
+		}
+		if s.domainStores[domain], err = newStore(ci.mongoUri, domain); err != nil {
+			return nil, err
+		}
+	}
+
+	sinks := ci.clickSinks
+	if ci.clickCounting {
+		sinks = append([]ClickSink{&storeClickSink{stores: s.clickStore}}, sinks...)
+	}
+
+	if len(sinks) > 0 {
+		s.clicks = newClickDispatcher(sinks, ci.geoResolver, ci.clickBatchSize, ci.clickFlushInterval)
+	}
+
 	return &s, nil
 }
 
-func newShortenedUrl(id string, host string) ShortenedURL {
-	scheme := "https://"
-	if strings.HasPrefix(host, "localhost") {
-		scheme = "http://"
+func (s *shortner) newShortenedUrl(id string, host string) ShortenedURL {
+	scheme := s.scheme
+	if scheme == "" {
+		scheme = "https"
+		if strings.HasPrefix(host, "localhost") {
+			scheme = "http"
+		}
 	}
 
 	return &shortenedUrl{
-		url: scheme + host + "/" + id,
+		url: scheme + "://" + host + s.pathPrefix + "/" + id,
 	}
 }
 
-func (s *shortner) insert(ctx context.Context, ic *insertConfig) (ShortenedURL, error) {
-	if err := s.store.Insert(ctx, ic); err != nil {
+func (s *shortner) insert(ctx context.Context, ns *namespace, ic *insertConfig) (ShortenedURL, error) {
+	if err := ns.store.Insert(ctx, ic); err != nil {
 		return nil, fmt.Errorf("failed to insert an entry for a shortened url: %w", err)
 	}
 
-	return newShortenedUrl(ic.id, s.host), nil
+	return s.newShortenedUrl(ic.id, ns.host), nil
 }
 
 // CreateShortenedUrl creates a shortened url.
 // If no configuration is passed uses the default configuration (see: `DefaultUrlConfig()`)
 func (s *shortner) CreateShortenedUrl(ctx context.Context, url string, config ...UrlConfig) (ShortenedURL, error) {
-	if err := validateUrl(url); err != nil {
+	url, flagged, err := s.destination(ctx, url)
+	if err != nil {
 		return nil, err
 	}
 
@@ -111,9 +266,46 @@ func (s *shortner) CreateShortenedUrl(ctx context.Context, url string, config ..
 		return nil, uci.err
 	}
 
+	expiration, err := uci.expiration(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var slidingTtl time.Duration
+	if uci.sliding {
+		slidingTtl = uci.ttl
+	}
+
+	if uci.activationDate != nil && expiration != nil && !uci.activationDate.Before(*expiration) {
+		return nil, newValidationError("the activation date must be before the expiration date")
+	}
+
+	if uci.domain != "" {
+		ctx = ContextWithDomain(ctx, uci.domain)
+	}
+
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var surl ShortenedURL
+	err = s.withQuota(ctx, ns, "links", ns.quota().MaxLinks, ns.store.CountLinks, func() error {
+		var err error
+		surl, err = s.createShortenedUrl(ctx, ns, url, flagged, uci, expiration, slidingTtl)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return surl, nil
+}
+
+func (s *shortner) createShortenedUrl(ctx context.Context, ns *namespace, url string, flagged bool, uci *urlConfig, expiration *time.Time, slidingTtl time.Duration) (ShortenedURL, error) {
 	if len(uci.alias) > 0 {
-		return s.insert(ctx, &insertConfig{
-			url: url, id: uci.alias, override: uci.overrideAlias, expiration: uci.expirationDate,
+		return s.insert(ctx, ns, &insertConfig{
+			url: url, id: uci.alias, override: uci.overrideAlias, overrideOwnedOnly: uci.overrideOwnedOnly, expiration: expiration, redirectType: uci.redirectType, owner: uci.owner, domain: ns.domain, flagged: flagged, passwordHash: uci.passwordHash, maxClicks: uci.maxClicks, activation: uci.activationDate, slidingTtl: slidingTtl,
 		})
 	}
 
@@ -123,8 +315,8 @@ func (s *shortner) CreateShortenedUrl(ctx context.Context, url string, config ..
 			return nil, err
 		}
 
-		shortenedUrl, err := s.insert(ctx, &insertConfig{
-			url: url, id: id, override: false, expiration: uci.expirationDate,
+		shortenedUrl, err := s.insert(ctx, ns, &insertConfig{
+			url: url, id: id, override: false, expiration: expiration, redirectType: uci.redirectType, owner: uci.owner, domain: ns.domain, flagged: flagged, passwordHash: uci.passwordHash, maxClicks: uci.maxClicks, activation: uci.activationDate, slidingTtl: slidingTtl,
 		})
 		if err != nil {
 			// In rare cases (statistically) a conflict may occur. Generate a new random id.
@@ -141,18 +333,183 @@ func (s *shortner) CreateShortenedUrl(ctx context.Context, url string, config ..
 func (s *shortner) GetUrlFromShortenedUrl(ctx context.Context, surl string) (string, error) {
 	su, err := url.ParseRequestURI(surl)
 	if err != nil {
-		return "", fmt.Errorf("invalid short url %s: %w", surl, err)
+		return "", newValidationError("invalid short url %s: %w", surl, err)
+	}
+	if su.Scheme != "http" && su.Scheme != "https" {
+		return "", newValidationError("the scheme of the short url %s must be http or https", surl)
+	}
+
+	ctx, err = s.contextForHost(ctx, su.Host)
+	if err != nil {
+		return "", err
+	}
+
+	if s.pathPrefix != "" && !strings.HasPrefix(su.Path, s.pathPrefix+"/") {
+		return "", &ForeignHostError{Host: su.Host + su.Path}
 	}
 
-	id := strings.Trim(su.Path, "/")
+	id := strings.Trim(strings.TrimPrefix(su.Path, s.pathPrefix), "/")
+
+	u, err := s.GetUrlFromShortenedUrlId(ctx, id)
+	var nerr *IdNotFoundError
+	if errors.As(err, &nerr) {
+		nerr.Host = su.Host
+	}
 
-	return s.GetUrlFromShortenedUrlId(ctx, id)
+	return u, err
 }
 
 func (s *shortner) GetUrlFromShortenedUrlId(ctx context.Context, id string) (string, error) {
-	if len(id) == 0 || !isAlphaNumeric(id) {
-		return "", fmt.Errorf("invalid short url path %s", id)
+	res, err := s.Resolve(ctx, id, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return res.Url, nil
+}
+
+func (s *shortner) Resolve(ctx context.Context, id string, md *RequestMetadata) (*Resolution, error) {
+	if err := validateId(id); err != nil {
+		return nil, err
+	}
+
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := ns.store.GetUrl(ctx, id)
+	if err != nil {
+		var nerr *IdNotFoundError
+		if errors.As(err, &nerr) {
+			nerr.Host = ns.host
+		}
+		return nil, err
+	}
+
+	if rec.ActivateAt != nil && time.Now().Unix() < *rec.ActivateAt {
+		return nil, &NotYetActiveError{Id: id, ActivationDate: time.Unix(*rec.ActivateAt, 0)}
+	}
+
+	// Urls may be blocked after they were shortened (e.g. by a threat list update).
+	if s.urlPolicy != nil {
+		if err := s.urlPolicy.Check(ctx, rec.Url); err != nil {
+			return nil, err
+		}
+	}
+
+	if rec.PasswordHash != "" {
+		if err := checkPassword(ctx, id, rec.PasswordHash); err != nil {
+			return nil, err
+		}
+	}
+
+	bot := s.botClassifier != nil && s.botClassifier.IsBot(md)
+
+	if rec.RemainingClicks != nil {
+		// Bots (e.g. the link unfurlers of chat apps) and HEAD requests must not use up a click-limited url before a person clicks it,
+		// and since they do not take a click they are not given the url.
+		if bot || (md != nil && md.Method == http.MethodHead) {
+			return nil, &ClickLimitedError{Id: id}
+		}
+		if err := ns.store.DecrementRemainingClicks(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if rec.SlidingTtl != 0 {
+		if err := ns.store.ExtendExpiration(ctx, id, time.Now().Add(time.Duration(rec.SlidingTtl)*time.Second)); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.clickCounting {
+		// The counter is analytics, a failing write must not fail the redirect.
+		_ = ns.store.IncrementClicks(ctx, id, bot)
+	}
+
+	if s.clicks != nil {
+		event := ClickEvent{Id: id, Host: ns.host, Tenant: ns.tenantId(), Time: time.Now(), Bot: bot}
+		if md != nil {
+			event.Referrer = md.Referrer
+			event.UserAgent = md.UserAgent
+			event.ClientIP = md.ClientIP
+			event.AcceptLanguage = md.AcceptLanguage
+			if md.ClientIP != "" || md.UserAgent != "" {
+				event.visitorHash = visitorHash(s.visitorHashSalt, md.ClientIP, md.UserAgent)
+			}
+		}
+		s.clicks.dispatch(event)
+	}
+
+	redirectType := rec.RedirectType
+	if redirectType == 0 {
+		redirectType = s.redirectType
+	}
+
+	limited := rec.RemainingClicks != nil || rec.PasswordHash != "" || rec.ExpireAt != nil
+
+	return &Resolution{Url: rec.Url, RedirectType: redirectType, Bot: bot, Limited: limited}, nil
+}
+
+func (s *shortner) GetStats(ctx context.Context, id string) (*Stats, error) {
+	if err := validateId(id); err != nil {
+		return nil, err
+	}
+
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.store.GetStats(ctx, id)
+}
+
+func (s *shortner) GetTimeSeries(ctx context.Context, id string, from time.Time, to time.Time, granularity Granularity) (*TimeSeries, error) {
+	if err := validateId(id); err != nil {
+		return nil, err
+	}
+
+	if err := granularity.validate(); err != nil {
+		return nil, err
+	}
+
+	if !from.Before(to) {
+		return nil, newValidationError("the time range start must be before its end")
+	}
+
+	if granularity.buckets(from, to) > maxTimeSeriesBuckets {
+		return nil, newValidationError("the time range must not exceed %d %s buckets", maxTimeSeriesBuckets, granularity)
+	}
+
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.store.GetTimeSeries(ctx, id, from, to, granularity)
+}
+
+func (s *shortner) PathPrefix() string {
+	return s.pathPrefix
+}
+
+func (s *shortner) DroppedClickEvents() uint64 {
+	if s.clicks == nil {
+		return 0
+	}
+
+	return s.clicks.droppedEvents()
+}
+
+func (s *shortner) Close(ctx context.Context) error {
+	if s.clicks == nil {
+		// Without click sinks there is no dispatcher to close the geo resolver.
+		if s.geoResolver != nil {
+			return s.geoResolver.Close()
+		}
+		return nil
 	}
 
-	return s.store.GetUrl(ctx, id)
+	return s.clicks.close(ctx)
 }
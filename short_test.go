@@ -2,6 +2,11 @@ package short
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -46,6 +51,7 @@ func TestShort(t *testing.T) {
 			var perr *IdNotFoundError
 			require.ErrorAs(t, err, &perr)
 			require.Contains(t, err.Error(), "aaaaaaa")
+			require.Equal(t, "host.com:12345", perr.Host)
 		})
 
 		t.Run("expired short url", func(t *testing.T) {
@@ -54,8 +60,875 @@ func TestShort(t *testing.T) {
 
 			_, err = shortner.GetUrlFromShortenedUrl(context.Background(), surl.GetUrl())
 			require.Error(t, err)
+			var eerr *ExpiredError
+			require.ErrorAs(t, err, &eerr)
+			require.WithinDuration(t, time.Now().Add(-time.Hour), eerr.ExpirationDate, 5*time.Second)
+		})
+	})
+
+	t.Run("GetStats", func(t *testing.T) {
+		url := "https://test.com/?sdfsdfsd"
+
+		t.Run("click counting enabled", func(t *testing.T) {
+			shortner, err := NewShortener(DefaultConfig().WithMongoUri(getRandomMongoURIForTesting()))
+			require.Nil(t, err)
+
+			surl, err := shortner.CreateShortenedUrl(context.Background(), url, DefaultUrlConfig().WithAlias("stats"))
+			require.Nil(t, err)
+
+			for i := 0; i < 2; i++ {
+				_, err = shortner.GetUrlFromShortenedUrl(context.Background(), surl.GetUrl())
+				require.Nil(t, err)
+			}
+
+			stats, err := shortner.GetStats(context.Background(), "stats")
+			require.Nil(t, err)
+			require.Equal(t, int64(2), stats.Clicks)
+			require.NotNil(t, stats.LastAccessed)
+		})
+
+		t.Run("click counting disabled", func(t *testing.T) {
+			shortner, err := NewShortener(DefaultConfig().WithMongoUri(getRandomMongoURIForTesting()).WithClickCounting(false))
+			require.Nil(t, err)
+
+			surl, err := shortner.CreateShortenedUrl(context.Background(), url, DefaultUrlConfig().WithAlias("stats"))
+			require.Nil(t, err)
+
+			_, err = shortner.GetUrlFromShortenedUrl(context.Background(), surl.GetUrl())
+			require.Nil(t, err)
+
+			stats, err := shortner.GetStats(context.Background(), "stats")
+			require.Nil(t, err)
+			require.Equal(t, int64(0), stats.Clicks)
+		})
+	})
+
+	t.Run("Resolve with click sinks", func(t *testing.T) {
+		sink := &memoryClickSink{}
+		shortner, err := NewShortener(DefaultConfig().WithHost("short.com").WithMongoUri(getRandomMongoURIForTesting()).WithClickSinks(sink))
+		require.Nil(t, err)
+
+		_, err = shortner.CreateShortenedUrl(context.Background(), "https://test.com", DefaultUrlConfig().WithAlias("clicks"))
+		require.Nil(t, err)
+
+		res, err := shortner.Resolve(context.Background(), "clicks", &RequestMetadata{
+			Referrer:       "https://ref.com",
+			UserAgent:      "Mozilla/5.0",
+			ClientIP:       "1.2.3.4",
+			AcceptLanguage: "en-US",
+		})
+		require.Nil(t, err)
+		require.Equal(t, "https://test.com", res.Url)
+
+		_, err = shortner.GetUrlFromShortenedUrlId(context.Background(), "clicks")
+		require.Nil(t, err)
+
+		require.Nil(t, shortner.Close(context.Background()))
+
+		events := sink.events()
+		require.Len(t, events, 2)
+		require.Equal(t, "clicks", events[0].Id)
+		require.Equal(t, "short.com", events[0].Host)
+		require.Equal(t, "https://ref.com", events[0].Referrer)
+		require.Equal(t, "Mozilla/5.0", events[0].UserAgent)
+		require.Equal(t, "1.2.3.4", events[0].ClientIP)
+		require.Equal(t, "en-US", events[0].AcceptLanguage)
+		require.Empty(t, events[1].UserAgent)
+	})
+
+	t.Run("GetTimeSeries", func(t *testing.T) {
+		shortner, err := NewShortener(DefaultConfig().WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		_, err = shortner.CreateShortenedUrl(context.Background(), "https://test.com", DefaultUrlConfig().WithAlias("series"))
+		require.Nil(t, err)
+
+		_, err = shortner.Resolve(context.Background(), "series", &RequestMetadata{Referrer: "https://ref.com", UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64)"})
+		require.Nil(t, err)
+
+		// Close flushes the pending click events to the rollups.
+		require.Nil(t, shortner.Close(context.Background()))
+
+		now := time.Now()
+
+		ts, err := shortner.GetTimeSeries(context.Background(), "series", now.Add(-time.Hour), now.Add(time.Hour), GranularityDay)
+		require.Nil(t, err)
+		var clicks int64
+		for _, bucket := range ts.Buckets {
+			clicks += bucket.Clicks
+		}
+		require.Equal(t, int64(1), clicks)
+		require.Equal(t, []Count{{Value: "https://ref.com", Count: 1}}, ts.TopReferrers)
+
+		_, err = shortner.GetTimeSeries(context.Background(), "series", now, now.Add(-time.Hour), GranularityDay)
+		require.Error(t, err)
+
+		_, err = shortner.GetTimeSeries(context.Background(), "series", now.Add(-time.Hour), now, Granularity("week"))
+		require.Error(t, err)
+
+		var verr *ValidationError
+		_, err = shortner.GetTimeSeries(context.Background(), "series", time.Time{}, now, GranularityHour)
+		require.ErrorAs(t, err, &verr)
+	})
+
+	t.Run("Resolve bots", func(t *testing.T) {
+		sink := &memoryClickSink{}
+		shortner, err := NewShortener(DefaultConfig().WithMongoUri(getRandomMongoURIForTesting()).WithClickSinks(sink))
+		require.Nil(t, err)
+
+		_, err = shortner.CreateShortenedUrl(context.Background(), "https://test.com", DefaultUrlConfig().WithAlias("bots"))
+		require.Nil(t, err)
+
+		res, err := shortner.Resolve(context.Background(), "bots", &RequestMetadata{UserAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"})
+		require.Nil(t, err)
+		require.True(t, res.Bot)
+
+		res, err = shortner.Resolve(context.Background(), "bots", &RequestMetadata{UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64)"})
+		require.Nil(t, err)
+		require.False(t, res.Bot)
+
+		require.Nil(t, shortner.Close(context.Background()))
+
+		stats, err := shortner.GetStats(context.Background(), "bots")
+		require.Nil(t, err)
+		require.Equal(t, int64(1), stats.Clicks)
+		require.Equal(t, int64(1), stats.BotClicks)
+
+		events := sink.events()
+		require.Len(t, events, 2)
+		require.True(t, events[0].Bot)
+		require.False(t, events[1].Bot)
+	})
+
+	t.Run("Resolve redirect type", func(t *testing.T) {
+		shortner, err := NewShortener(DefaultConfig().WithMongoUri(getRandomMongoURIForTesting()).WithDefaultRedirectType(http.StatusMovedPermanently))
+		require.Nil(t, err)
+
+		_, err = shortner.CreateShortenedUrl(context.Background(), "https://test.com", DefaultUrlConfig().WithAlias("default"))
+		require.Nil(t, err)
+		_, err = shortner.CreateShortenedUrl(context.Background(), "https://test.com", DefaultUrlConfig().WithAlias("temporary").WithRedirectType(http.StatusTemporaryRedirect))
+		require.Nil(t, err)
+
+		res, err := shortner.Resolve(context.Background(), "default", nil)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusMovedPermanently, res.RedirectType)
+		require.False(t, res.Limited)
+
+		res, err = shortner.Resolve(context.Background(), "temporary", nil)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusTemporaryRedirect, res.RedirectType)
+	})
+
+	t.Run("Manage shortened urls", func(t *testing.T) {
+		shortner, err := NewShortener(DefaultConfig().WithHost("short.com").WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		results := shortner.CreateShortenedUrls(context.Background(), []BatchItem{
+			{Url: "https://a.com", Config: DefaultUrlConfig().WithAlias("aaa")},
+			{Url: "https://b.com", Config: DefaultUrlConfig().WithAlias("bbb").WithRedirectType(http.StatusPermanentRedirect)},
+			{Url: "https://c.com", Config: DefaultUrlConfig().WithAlias("ccc")},
+			{Url: "invalid"},
+			{Url: "https://d.com", Config: DefaultUrlConfig().WithAlias("aaa")},
+		})
+		require.Len(t, results, 5)
+		for i := 0; i < 3; i++ {
+			require.Nil(t, results[i].Err)
+		}
+		require.Equal(t, "https://short.com/aaa", results[0].ShortenedUrl.GetUrl())
+		var verr *ValidationError
+		require.ErrorAs(t, results[3].Err, &verr)
+		var cerr *ConflictError
+		require.ErrorAs(t, results[4].Err, &cerr)
+
+		t.Run("info", func(t *testing.T) {
+			info, err := shortner.GetShortenedUrlInfo(context.Background(), "bbb")
+			require.Nil(t, err)
+			require.Equal(t, "bbb", info.Id)
+			require.Equal(t, "https://short.com/bbb", info.ShortUrl)
+			require.Equal(t, "https://b.com", info.Url)
+			require.Equal(t, http.StatusPermanentRedirect, info.RedirectType)
+			require.Nil(t, info.ExpirationDate)
+			require.NotNil(t, info.CreatedAt)
+		})
+
+		t.Run("list", func(t *testing.T) {
+			res, err := shortner.ListShortenedUrls(context.Background(), ListOptions{Limit: 2})
+			require.Nil(t, err)
+			require.Len(t, res.ShortenedUrls, 2)
+			require.Equal(t, "aaa", res.ShortenedUrls[0].Id)
+			require.Equal(t, "bbb", res.NextCursor)
+
+			res, err = shortner.ListShortenedUrls(context.Background(), ListOptions{Limit: 2, Cursor: res.NextCursor})
+			require.Nil(t, err)
+			require.Len(t, res.ShortenedUrls, 1)
+			require.Equal(t, "ccc", res.ShortenedUrls[0].Id)
+			require.Empty(t, res.NextCursor)
+
+			_, err = shortner.ListShortenedUrls(context.Background(), ListOptions{Limit: maxListLimit + 1})
+			require.ErrorAs(t, err, &verr)
+		})
+
+		t.Run("update", func(t *testing.T) {
+			require.Nil(t, shortner.UpdateDestination(context.Background(), "aaa", "https://updated.com"))
+
+			url, err := shortner.GetUrlFromShortenedUrlId(context.Background(), "aaa")
+			require.Nil(t, err)
+			require.Equal(t, "https://updated.com", url)
+
 			var perr *IdNotFoundError
+			require.ErrorAs(t, shortner.UpdateDestination(context.Background(), "zzz", "https://updated.com"), &perr)
+			require.ErrorAs(t, shortner.UpdateDestination(context.Background(), "aaa", "invalid"), &verr)
+		})
+
+		t.Run("delete", func(t *testing.T) {
+			require.Nil(t, shortner.DeleteShortenedUrl(context.Background(), "ccc"))
+
+			var gerr *GoneError
+			_, err := shortner.GetUrlFromShortenedUrlId(context.Background(), "ccc")
+			require.ErrorAs(t, err, &gerr)
+			require.Equal(t, LinkTombstoned, gerr.State)
+
+			var perr *IdNotFoundError
+			_, err = shortner.GetShortenedUrlInfo(context.Background(), "ccc")
 			require.ErrorAs(t, err, &perr)
+			require.ErrorAs(t, shortner.DeleteShortenedUrl(context.Background(), "ccc"), &perr)
+
+			// The id of a deleted shortened url may be reused.
+			_, err = shortner.CreateShortenedUrl(context.Background(), "https://reused.com", DefaultUrlConfig().WithAlias("ccc"))
+			require.Nil(t, err)
+			url, err := shortner.GetUrlFromShortenedUrlId(context.Background(), "ccc")
+			require.Nil(t, err)
+			require.Equal(t, "https://reused.com", url)
+		})
+	})
+
+	t.Run("Api keys", func(t *testing.T) {
+		shortner, err := NewShortener(DefaultConfig().WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		_, _, err = shortner.CreateApiKey(context.Background(), "no scopes")
+		var verr *ValidationError
+		require.ErrorAs(t, err, &verr)
+
+		_, _, err = shortner.CreateApiKey(context.Background(), "invalid scope", Scope("root"))
+		require.ErrorAs(t, err, &verr)
+
+		key, apiKey, err := shortner.CreateApiKey(context.Background(), "ci", ScopeCreate, ScopeReadStats)
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(key, apiKeyPrefix))
+		require.Equal(t, "ci", apiKey.Name)
+		require.Equal(t, []Scope{ScopeCreate, ScopeReadStats}, apiKey.Scopes)
+
+		res, err := shortner.AuthenticateApiKey(context.Background(), key)
+		require.Nil(t, err)
+		require.Equal(t, apiKey.Id, res.Id)
+
+		var aerr *InvalidApiKeyError
+		_, err = shortner.AuthenticateApiKey(context.Background(), key+"a")
+		require.ErrorAs(t, err, &aerr)
+
+		keys, err := shortner.ListApiKeys(context.Background())
+		require.Nil(t, err)
+		require.Len(t, keys, 1)
+		require.Equal(t, apiKey.Id, keys[0].Id)
+
+		t.Run("ownership", func(t *testing.T) {
+			_, err := shortner.CreateShortenedUrl(context.Background(), "https://test.com", DefaultUrlConfig().WithAlias("owned").WithOwner(apiKey.Id))
+			require.Nil(t, err)
+			_, err = shortner.CreateShortenedUrl(context.Background(), "https://test.com", DefaultUrlConfig().WithAlias("other"))
+			require.Nil(t, err)
+
+			info, err := shortner.GetShortenedUrlInfo(context.Background(), "owned")
+			require.Nil(t, err)
+			require.Equal(t, apiKey.Id, info.Owner)
+			require.True(t, apiKey.CanAccess(info))
+
+			list, err := shortner.ListShortenedUrls(context.Background(), ListOptions{Owner: apiKey.Id})
+			require.Nil(t, err)
+			require.Len(t, list.ShortenedUrls, 1)
+			require.Equal(t, "owned", list.ShortenedUrls[0].Id)
+		})
+
+		require.Nil(t, shortner.RevokeApiKey(context.Background(), apiKey.Id))
+		_, err = shortner.AuthenticateApiKey(context.Background(), key)
+		require.ErrorAs(t, err, &aerr)
+
+		var perr *IdNotFoundError
+		require.ErrorAs(t, shortner.RevokeApiKey(context.Background(), apiKey.Id), &perr)
+	})
+	t.Run("Tenants", func(t *testing.T) {
+		shortner, err := NewShortener(DefaultConfig().WithHost("short.com").WithAliasDomains("sho.rt").WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		ctx := context.Background()
+
+		var verr *ValidationError
+		_, err = shortner.CreateTenant(ctx, Tenant{Id: "ac-me"})
+		require.ErrorAs(t, err, &verr)
+		_, err = shortner.CreateTenant(ctx, Tenant{Id: "acme", Domains: []string{"go.acme.com/path"}})
+		require.ErrorAs(t, err, &verr)
+		for _, domain := range []string{"Short.com", "short.com:8080", "sho.rt"} {
+			_, err = shortner.CreateTenant(ctx, Tenant{Id: "acme", Domains: []string{domain}})
+			require.ErrorAs(t, err, &verr, domain)
+		}
+
+		acme, err := shortner.CreateTenant(ctx, Tenant{Id: "acme", Name: "Acme", Domains: []string{"Go.Acme.com"}, Quota: Quota{MaxLinks: 2, MaxApiKeys: 1}})
+		require.Nil(t, err)
+		require.Equal(t, []string{"go.acme.com"}, acme.Domains)
+		_, err = shortner.CreateTenant(ctx, Tenant{Id: "globex", Domains: []string{"glbx.io"}})
+		require.Nil(t, err)
+
+		var cerr *ConflictError
+		_, err = shortner.CreateTenant(ctx, Tenant{Id: "acme"})
+		require.ErrorAs(t, err, &cerr)
+		_, err = shortner.CreateTenant(ctx, Tenant{Id: "initech", Domains: []string{"go.acme.com"}})
+		require.ErrorAs(t, err, &cerr)
+
+		acmeCtx := ContextWithTenant(ctx, "acme")
+		globexCtx := ContextWithTenant(ctx, "globex")
+
+		t.Run("same alias", func(t *testing.T) {
+			surl, err := shortner.CreateShortenedUrl(acmeCtx, "https://acme.com", DefaultUrlConfig().WithAlias("docs"))
+			require.Nil(t, err)
+			require.Equal(t, "https://go.acme.com/docs", surl.GetUrl())
+
+			surl, err = shortner.CreateShortenedUrl(globexCtx, "https://globex.com", DefaultUrlConfig().WithAlias("docs"))
+			require.Nil(t, err)
+			require.Equal(t, "https://glbx.io/docs", surl.GetUrl())
+
+			_, err = shortner.CreateShortenedUrl(ctx, "https://short.com", DefaultUrlConfig().WithAlias("docs"))
+			require.Nil(t, err)
+
+			url, err := shortner.GetUrlFromShortenedUrl(ctx, "https://go.acme.com/docs")
+			require.Nil(t, err)
+			require.Equal(t, "https://acme.com", url)
+			url, err = shortner.GetUrlFromShortenedUrl(ctx, "https://glbx.io/docs")
+			require.Nil(t, err)
+			require.Equal(t, "https://globex.com", url)
+			url, err = shortner.GetUrlFromShortenedUrlId(ctx, "docs")
+			require.Nil(t, err)
+			require.Equal(t, "https://short.com", url)
+		})
+
+		t.Run("scoped list and stats", func(t *testing.T) {
+			list, err := shortner.ListShortenedUrls(acmeCtx, ListOptions{})
+			require.Nil(t, err)
+			require.Len(t, list.ShortenedUrls, 1)
+			require.Equal(t, "https://go.acme.com/docs", list.ShortenedUrls[0].ShortUrl)
+
+			_, err = shortner.Resolve(acmeCtx, "docs", nil)
+			require.Nil(t, err)
+
+			stats, err := shortner.GetStats(acmeCtx, "docs")
+			require.Nil(t, err)
+			require.Equal(t, int64(1), stats.Clicks)
+			stats, err = shortner.GetStats(globexCtx, "docs")
+			require.Nil(t, err)
+			require.Equal(t, int64(1), stats.Clicks)
+		})
+
+		t.Run("quota", func(t *testing.T) {
+			var qerr *QuotaExceededError
+
+			_, err := shortner.CreateShortenedUrl(acmeCtx, "https://acme.com/2")
+			require.Nil(t, err)
+			_, err = shortner.CreateShortenedUrl(acmeCtx, "https://acme.com/3")
+			require.ErrorAs(t, err, &qerr)
+
+			initech, err := shortner.CreateTenant(ctx, Tenant{Id: "initech", Quota: Quota{MaxLinks: 3}})
+			require.Nil(t, err)
+			initechCtx := ContextWithTenant(ctx, initech.Id)
+
+			var wg sync.WaitGroup
+			var created int32
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					if _, err := shortner.CreateShortenedUrl(initechCtx, fmt.Sprintf("https://initech.com/%d", i)); err == nil {
+						atomic.AddInt32(&created, 1)
+					}
+				}(i)
+			}
+			wg.Wait()
+			require.Equal(t, int32(3), created)
+
+			key, apiKey, err := shortner.CreateApiKey(acmeCtx, "ci", ScopeAdmin)
+			require.Nil(t, err)
+			require.Equal(t, "acme", apiKey.Tenant)
+			_, _, err = shortner.CreateApiKey(acmeCtx, "ci", ScopeAdmin)
+			require.ErrorAs(t, err, &qerr)
+
+			res, err := shortner.AuthenticateApiKey(ctx, key)
+			require.Nil(t, err)
+			require.Equal(t, "acme", res.Tenant)
+
+			keys, err := shortner.ListApiKeys(ctx)
+			require.Nil(t, err)
+			require.Empty(t, keys)
+		})
+
+		t.Run("manage", func(t *testing.T) {
+			tenant, err := shortner.GetTenantByDomain(ctx, "glbx.io")
+			require.Nil(t, err)
+			require.Equal(t, "globex", tenant.Id)
+
+			tenant, err = shortner.UpdateTenant(ctx, Tenant{Id: "globex", Name: "Globex", Domains: []string{"globex.link"}})
+			require.Nil(t, err)
+			require.Equal(t, "Globex", tenant.Name)
+
+			var nerr *TenantNotFoundError
+			_, err = shortner.GetTenantByDomain(ctx, "glbx.io")
+			require.ErrorAs(t, err, &nerr)
+
+			tenants, err := shortner.ListTenants(ctx)
+			require.Nil(t, err)
+			require.Len(t, tenants, 3)
+
+			require.Nil(t, shortner.DeleteTenant(ctx, "globex"))
+			_, err = shortner.GetTenant(ctx, "globex")
+			require.ErrorAs(t, err, &nerr)
+			_, err = shortner.GetUrlFromShortenedUrlId(globexCtx, "docs")
+			require.ErrorAs(t, err, &nerr)
+			require.ErrorAs(t, shortner.DeleteTenant(ctx, "globex"), &nerr)
 		})
 	})
+	t.Run("Domains", func(t *testing.T) {
+		ctx := context.Background()
+
+		t.Run("per-domain aliases", func(t *testing.T) {
+			shortner, err := NewShortener(DefaultConfig().WithHost("short.com").WithDomains("acme.link").WithMongoUri(getRandomMongoURIForTesting()))
+			require.Nil(t, err)
+
+			surl, err := shortner.CreateShortenedUrl(ctx, "https://short.com", DefaultUrlConfig().WithAlias("docs"))
+			require.Nil(t, err)
+			require.Equal(t, "https://short.com/docs", surl.GetUrl())
+
+			surl, err = shortner.CreateShortenedUrl(ctx, "https://acme.com", DefaultUrlConfig().WithAlias("docs").WithDomain("acme.link"))
+			require.Nil(t, err)
+			require.Equal(t, "https://acme.link/docs", surl.GetUrl())
+
+			var verr *ValidationError
+			_, err = shortner.CreateShortenedUrl(ctx, "https://acme.com", DefaultUrlConfig().WithDomain("other.com"))
+			require.ErrorAs(t, err, &verr)
+
+			url, err := shortner.GetUrlFromShortenedUrl(ctx, "https://acme.link/docs")
+			require.Nil(t, err)
+			require.Equal(t, "https://acme.com", url)
+
+			url, err = shortner.GetUrlFromShortenedUrlId(ctx, "docs")
+			require.Nil(t, err)
+			require.Equal(t, "https://short.com", url)
+
+			list, err := shortner.ListShortenedUrls(ContextWithDomain(ctx, "acme.link"), ListOptions{})
+			require.Nil(t, err)
+			require.Len(t, list.ShortenedUrls, 1)
+			require.Equal(t, "https://acme.link/docs", list.ShortenedUrls[0].ShortUrl)
+		})
+
+		t.Run("shared aliases", func(t *testing.T) {
+			shortner, err := NewShortener(DefaultConfig().WithHost("short.com").WithDomains("acme.link").WithSharedAliases(true).WithMongoUri(getRandomMongoURIForTesting()))
+			require.Nil(t, err)
+
+			surl, err := shortner.CreateShortenedUrl(ctx, "https://acme.com", DefaultUrlConfig().WithAlias("docs").WithDomain("acme.link"))
+			require.Nil(t, err)
+			require.Equal(t, "https://acme.link/docs", surl.GetUrl())
+
+			var cerr *ConflictError
+			_, err = shortner.CreateShortenedUrl(ctx, "https://short.com", DefaultUrlConfig().WithAlias("docs"))
+			require.ErrorAs(t, err, &cerr)
+
+			url, err := shortner.GetUrlFromShortenedUrl(ctx, "https://short.com/docs")
+			require.Nil(t, err)
+			require.Equal(t, "https://acme.com", url)
+
+			info, err := shortner.GetShortenedUrlInfo(ctx, "docs")
+			require.Nil(t, err)
+			require.Equal(t, "https://acme.link/docs", info.ShortUrl)
+		})
+	})
+	t.Run("Base url", func(t *testing.T) {
+		ctx := context.Background()
+
+		shortner, err := NewShortener(DefaultConfig().WithBaseUrl("http://intranet-host:8080/s").WithDomains("acme.link").WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		surl, err := shortner.CreateShortenedUrl(ctx, "https://test.com", DefaultUrlConfig().WithAlias("docs"))
+		require.Nil(t, err)
+		require.Equal(t, "http://intranet-host:8080/s/docs", surl.GetUrl())
+
+		url, err := shortner.GetUrlFromShortenedUrl(ctx, surl.GetUrl())
+		require.Nil(t, err)
+		require.Equal(t, "https://test.com", url)
+
+		surl, err = shortner.CreateShortenedUrl(ctx, "https://acme.com", DefaultUrlConfig().WithAlias("docs").WithDomain("acme.link"))
+		require.Nil(t, err)
+		require.Equal(t, "http://acme.link/s/docs", surl.GetUrl())
+
+		var ferr *ForeignHostError
+		_, err = shortner.GetUrlFromShortenedUrl(ctx, "http://intranet-host:8080/docs")
+		require.ErrorAs(t, err, &ferr)
+
+		_, err = shortner.GetUrlFromShortenedUrl(ctx, "http://other-host/s/docs")
+		require.ErrorAs(t, err, &ferr)
+		require.Contains(t, err.Error(), "other-host")
+	})
+
+	t.Run("Alias domains", func(t *testing.T) {
+		ctx := context.Background()
+
+		// The configured hosts are lower cased like the hosts of the shortened urls.
+		shortner, err := NewShortener(DefaultConfig().WithHost("Short.com").WithAliasDomains("www.short.com", "old.link").WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		surl, err := shortner.CreateShortenedUrl(ctx, "https://test.com", DefaultUrlConfig().WithAlias("docs"))
+		require.Nil(t, err)
+		require.Equal(t, "https://short.com/docs", surl.GetUrl())
+
+		for _, surl := range []string{"https://short.com/docs", "https://www.short.com/docs", "https://OLD.link/docs"} {
+			url, err := shortner.GetUrlFromShortenedUrl(ctx, surl)
+			require.Nil(t, err, surl)
+			require.Equal(t, "https://test.com", url)
+		}
+
+		var ferr *ForeignHostError
+		_, err = shortner.GetUrlFromShortenedUrl(ctx, "https://evil.com/docs")
+		require.ErrorAs(t, err, &ferr)
+		_, err = shortner.GetUrlFromShortenedUrl(ctx, "/docs")
+		require.ErrorAs(t, err, &ferr)
+
+		var verr *ValidationError
+		_, err = shortner.GetUrlFromShortenedUrl(ctx, "ftp://short.com/docs")
+		require.ErrorAs(t, err, &verr)
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com", DefaultUrlConfig().WithDomain("old.link"))
+		require.ErrorAs(t, err, &verr)
+	})
+
+	t.Run("Url policy", func(t *testing.T) {
+		ctx := context.Background()
+
+		list := NewThreatList()
+		policy, err := NewUrlPolicy(UrlPolicyOptions{DeniedDomains: []string{"evil.com"}, BlockPrivateAddresses: true, ThreatList: list})
+		require.Nil(t, err)
+
+		shortner, err := NewShortener(DefaultConfig().WithUrlPolicy(policy).WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		var berr *BlockedUrlError
+		_, err = shortner.CreateShortenedUrl(ctx, "https://www.evil.com")
+		require.ErrorAs(t, err, &berr)
+		_, err = shortner.CreateShortenedUrl(ctx, "http://169.254.169.254/latest/meta-data")
+		require.ErrorAs(t, err, &berr)
+
+		_, err = shortner.CreateShortenedUrl(ctx, "https://phishing.net/login", DefaultUrlConfig().WithAlias("login"))
+		require.Nil(t, err)
+		require.ErrorAs(t, shortner.UpdateDestination(ctx, "login", "https://evil.com"), &berr)
+
+		res, err := shortner.Resolve(ctx, "login", nil)
+		require.Nil(t, err)
+		require.Equal(t, "https://phishing.net/login", res.Url)
+
+		// The url is blocked when it is resolved after the threat list is updated.
+		require.Nil(t, list.ApplyUpdate(strings.NewReader(threatListUpdateJSON(t, "FULL_UPDATE", nil, "phishing.net/"))))
+		_, err = shortner.Resolve(ctx, "login", nil)
+		require.ErrorAs(t, err, &berr)
+	})
+
+	t.Run("Self links", func(t *testing.T) {
+		ctx := context.Background()
+
+		shortner, err := NewShortener(DefaultConfig().WithHost("short.com").WithAliasDomains("www.short.com").WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com/final", DefaultUrlConfig().WithAlias("a"))
+		require.Nil(t, err)
+
+		// b -> a is stored as b -> https://test.com/final, so c -> b is as well.
+		_, err = shortner.CreateShortenedUrl(ctx, "https://www.short.com/a", DefaultUrlConfig().WithAlias("b"))
+		require.Nil(t, err)
+		_, err = shortner.CreateShortenedUrl(ctx, "https://short.com/b?utm=x", DefaultUrlConfig().WithAlias("c"))
+		require.Nil(t, err)
+
+		for _, id := range []string{"b", "c"} {
+			info, err := shortner.GetShortenedUrlInfo(ctx, id)
+			require.Nil(t, err)
+			require.Equal(t, "https://test.com/final", info.Url)
+		}
+
+		// Pointing a back to c cannot create a loop.
+		require.Nil(t, shortner.UpdateDestination(ctx, "a", "https://short.com/c"))
+		info, err := shortner.GetShortenedUrlInfo(ctx, "a")
+		require.Nil(t, err)
+		require.Equal(t, "https://test.com/final", info.Url)
+
+		var berr *BlockedUrlError
+		_, err = shortner.CreateShortenedUrl(ctx, "https://short.com/missing")
+		require.ErrorAs(t, err, &berr)
+		require.ErrorAs(t, shortner.UpdateDestination(ctx, "a", "https://short.com/a"), &berr)
+
+		shortner, err = NewShortener(DefaultConfig().WithHost("short.com").WithSelfLinks(RejectSelfLinks).WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com/final", DefaultUrlConfig().WithAlias("a"))
+		require.Nil(t, err)
+		_, err = shortner.CreateShortenedUrl(ctx, "https://short.com/a")
+		require.ErrorAs(t, err, &berr)
+	})
+
+	t.Run("Shortener domains", func(t *testing.T) {
+		ctx := context.Background()
+
+		shortner, err := NewShortener(DefaultConfig().WithShortenerDomains(RejectShortenerDomains, KnownShortenerDomains...).WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		var berr *BlockedUrlError
+		_, err = shortner.CreateShortenedUrl(ctx, "https://bit.ly/abc")
+		require.ErrorAs(t, err, &berr)
+
+		shortner, err = NewShortener(DefaultConfig().WithShortenerDomains(FlagShortenerDomains, "bit.ly").WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		_, err = shortner.CreateShortenedUrl(ctx, "https://bit.ly/abc", DefaultUrlConfig().WithAlias("flagged"))
+		require.Nil(t, err)
+		info, err := shortner.GetShortenedUrlInfo(ctx, "flagged")
+		require.Nil(t, err)
+		require.True(t, info.Flagged)
+
+		require.Nil(t, shortner.UpdateDestination(ctx, "flagged", "https://test.com"))
+		info, err = shortner.GetShortenedUrlInfo(ctx, "flagged")
+		require.Nil(t, err)
+		require.False(t, info.Flagged)
+	})
+
+	t.Run("Password", func(t *testing.T) {
+		ctx := context.Background()
+
+		shortner, err := NewShortener(DefaultConfig().WithHost("short.com").WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com/internal", DefaultUrlConfig().WithAlias("docs").WithPassword("secret"))
+		require.Nil(t, err)
+
+		info, err := shortner.GetShortenedUrlInfo(ctx, "docs")
+		require.Nil(t, err)
+		require.True(t, info.PasswordProtected)
+
+		var perr *PasswordRequiredError
+		_, err = shortner.Resolve(ctx, "docs", nil)
+		require.ErrorAs(t, err, &perr)
+		_, err = shortner.GetUrlFromShortenedUrl(ContextWithPassword(ctx, "wrong"), "https://short.com/docs")
+		require.ErrorAs(t, err, &perr)
+
+		res, err := shortner.Resolve(ContextWithPassword(ctx, "secret"), "docs", nil)
+		require.Nil(t, err)
+		require.Equal(t, "https://test.com/internal", res.Url)
+
+		// The destination cannot be read through an unprotected shortened url.
+		var berr *BlockedUrlError
+		_, err = shortner.CreateShortenedUrl(ctx, "https://short.com/docs")
+		require.ErrorAs(t, err, &berr)
+	})
+
+	t.Run("Max clicks", func(t *testing.T) {
+		ctx := context.Background()
+
+		shortner, err := NewShortener(DefaultConfig().WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com/invite", DefaultUrlConfig().WithAlias("invite").WithMaxClicks(1))
+		require.Nil(t, err)
+
+		info, err := shortner.GetShortenedUrlInfo(ctx, "invite")
+		require.Nil(t, err)
+		require.Equal(t, int64(1), *info.RemainingClicks)
+
+		// Bots (e.g. link unfurlers, curl or an empty user agent) and HEAD requests neither take the clicks nor get the url.
+		for _, md := range []*RequestMetadata{
+			{UserAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"},
+			{UserAgent: "curl/8.4.0"},
+			{UserAgent: ""},
+			{UserAgent: "Mozilla/5.0", Method: http.MethodHead},
+		} {
+			res, err := shortner.Resolve(ctx, "invite", md)
+			require.ErrorIs(t, err, &ClickLimitedError{Id: "invite"})
+			require.Nil(t, res)
+		}
+		info, err = shortner.GetShortenedUrlInfo(ctx, "invite")
+		require.Nil(t, err)
+		require.Equal(t, int64(1), *info.RemainingClicks)
+
+		res, err := shortner.Resolve(ctx, "invite", nil)
+		require.Nil(t, err)
+		require.True(t, res.Limited)
+
+		var gerr *GoneError
+		_, err = shortner.Resolve(ctx, "invite", nil)
+		require.ErrorAs(t, err, &gerr)
+		require.Equal(t, LinkDisabled, gerr.State)
+
+		info, err = shortner.GetShortenedUrlInfo(ctx, "invite")
+		require.Nil(t, err)
+		require.Equal(t, LinkDisabled, info.State)
+
+		// Concurrent resolves cannot go over the limit.
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com/download", DefaultUrlConfig().WithAlias("download").WithMaxClicks(5))
+		require.Nil(t, err)
+
+		var wg sync.WaitGroup
+		var resolved int64
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := shortner.Resolve(ctx, "download", nil); err == nil {
+					atomic.AddInt64(&resolved, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int64(5), resolved)
+
+		stats, err := shortner.GetStats(ctx, "download")
+		require.Nil(t, err)
+		require.Equal(t, int64(5), stats.Clicks)
+	})
+
+	t.Run("Activation date", func(t *testing.T) {
+		ctx := context.Background()
+
+		shortner, err := NewShortener(DefaultConfig().WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		activationDate := time.Now().Add(time.Hour).Truncate(time.Second)
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com/launch", DefaultUrlConfig().WithAlias("launch").WithActivationDate(activationDate))
+		require.Nil(t, err)
+
+		var aerr *NotYetActiveError
+		_, err = shortner.GetUrlFromShortenedUrlId(ctx, "launch")
+		require.ErrorAs(t, err, &aerr)
+		require.True(t, activationDate.Equal(aerr.ActivationDate))
+
+		// The shortened url may be managed before it is active.
+		info, err := shortner.GetShortenedUrlInfo(ctx, "launch")
+		require.Nil(t, err)
+		require.True(t, activationDate.Equal(*info.ActivationDate))
+
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com/live", DefaultUrlConfig().WithAlias("live").WithActivationDate(time.Now().Add(-time.Minute)))
+		require.Nil(t, err)
+		url, err := shortner.GetUrlFromShortenedUrlId(ctx, "live")
+		require.Nil(t, err)
+		require.Equal(t, "https://test.com/live", url)
+
+		var verr *ValidationError
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com", DefaultUrlConfig().WithActivationDate(activationDate).WithExpirationDate(activationDate.Add(-time.Minute)))
+		require.ErrorAs(t, err, &verr)
+	})
+
+	t.Run("Ttl and SetExpiration", func(t *testing.T) {
+		ctx := context.Background()
+
+		shortner, err := NewShortener(DefaultConfig().WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com/ttl", DefaultUrlConfig().WithAlias("ttl").WithTtl(time.Hour))
+		require.Nil(t, err)
+		info, err := shortner.GetShortenedUrlInfo(ctx, "ttl")
+		require.Nil(t, err)
+		require.WithinDuration(t, time.Now().Add(time.Hour), *info.ExpirationDate, 5*time.Second)
+		require.Equal(t, time.Duration(0), info.SlidingTtl)
+
+		// Shorten the expiration to the past, the shortened url expires.
+		require.Nil(t, shortner.SetExpiration(ctx, "ttl", &time.Time{}))
+		var eerr *ExpiredError
+		_, err = shortner.GetUrlFromShortenedUrlId(ctx, "ttl")
+		require.ErrorAs(t, err, &eerr)
+		info, err = shortner.GetShortenedUrlInfo(ctx, "ttl")
+		require.Nil(t, err)
+		require.Equal(t, LinkExpired, info.State)
+
+		// Removing the expiration revives it.
+		require.Nil(t, shortner.SetExpiration(ctx, "ttl", nil))
+		info, err = shortner.GetShortenedUrlInfo(ctx, "ttl")
+		require.Nil(t, err)
+		require.Nil(t, info.ExpirationDate)
+
+		var nerr *IdNotFoundError
+		require.ErrorAs(t, shortner.SetExpiration(ctx, "missing", nil), &nerr)
+
+		// A sliding expiration is extended on each resolve.
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com/sliding", DefaultUrlConfig().WithAlias("sliding").WithTtl(time.Hour).WithSlidingExpiration(true))
+		require.Nil(t, err)
+		info, err = shortner.GetShortenedUrlInfo(ctx, "sliding")
+		require.Nil(t, err)
+		require.Equal(t, time.Hour, info.SlidingTtl)
+		created := *info.ExpirationDate
+
+		time.Sleep(1100 * time.Millisecond)
+		_, err = shortner.GetUrlFromShortenedUrlId(ctx, "sliding")
+		require.Nil(t, err)
+		info, err = shortner.GetShortenedUrlInfo(ctx, "sliding")
+		require.Nil(t, err)
+		require.True(t, info.ExpirationDate.After(created))
+
+		// Setting an expiration date stops sliding.
+		require.Nil(t, shortner.SetExpiration(ctx, "sliding", &created))
+		info, err = shortner.GetShortenedUrlInfo(ctx, "sliding")
+		require.Nil(t, err)
+		require.Equal(t, time.Duration(0), info.SlidingTtl)
+	})
+
+	t.Run("Override", func(t *testing.T) {
+		ctx := context.Background()
+
+		shortner, err := NewShortener(DefaultConfig().WithMongoUri(getRandomMongoURIForTesting()))
+		require.Nil(t, err)
+
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com/old", DefaultUrlConfig().WithAlias("promo").
+			WithPassword("secret").WithMaxClicks(1).WithTtl(time.Hour).WithSlidingExpiration(true))
+		require.Nil(t, err)
+		_, err = shortner.Resolve(ContextWithPassword(ctx, "secret"), "promo", nil)
+		require.Nil(t, err)
+
+		// The override does not keep the password, the used up clicks or the expiration of the previous url.
+		_, err = shortner.CreateShortenedUrl(ctx, "https://test.com/new", DefaultUrlConfig().WithAlias("promo").WithOverrideAlias(true))
+		require.Nil(t, err)
+
+		url, err := shortner.GetUrlFromShortenedUrlId(ctx, "promo")
+		require.Nil(t, err)
+		require.Equal(t, "https://test.com/new", url)
+
+		info, err := shortner.GetShortenedUrlInfo(ctx, "promo")
+		require.Nil(t, err)
+		require.False(t, info.PasswordProtected)
+		require.Nil(t, info.RemainingClicks)
+		require.Nil(t, info.ExpirationDate)
+		require.Equal(t, time.Duration(0), info.SlidingTtl)
+		require.NotNil(t, info.CreatedAt)
+
+		// An owned-only override does not take over a shortened url of another owner.
+		_, err = shortner.CreateShortenedUrl(ctx, "https://alice.com", DefaultUrlConfig().WithAlias("owned").WithOwner("alice"))
+		require.Nil(t, err)
+		var cerr *ConflictError
+		_, err = shortner.CreateShortenedUrl(ctx, "https://bob.com", DefaultUrlConfig().WithAlias("owned").WithOwner("bob").WithOverrideAlias(true).WithOverrideOwnedOnly(true))
+		require.ErrorAs(t, err, &cerr)
+		_, err = shortner.CreateShortenedUrl(ctx, "https://alice.com/new", DefaultUrlConfig().WithAlias("owned").WithOwner("alice").WithOverrideAlias(true).WithOverrideOwnedOnly(true))
+		require.Nil(t, err)
+	})
+}
+
+func TestShortenerClose(t *testing.T) {
+	resolver := &staticGeoResolver{}
+	s := &shortner{geoResolver: resolver}
+
+	require.Nil(t, s.Close(context.Background()))
+	require.True(t, resolver.closed)
+}
+
+func TestNewShortenedUrl(t *testing.T) {
+	s := &shortner{}
+	require.Equal(t, "https://short.com/abc", s.newShortenedUrl("abc", "short.com").GetUrl())
+	require.Equal(t, "http://localhost:8080/abc", s.newShortenedUrl("abc", "localhost:8080").GetUrl())
+
+	s = &shortner{scheme: "http", pathPrefix: "/s"}
+	require.Equal(t, "http://short.com/s/abc", s.newShortenedUrl("abc", "short.com").GetUrl())
 }
@@ -0,0 +1,141 @@
+package shortgrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/TomerHeber/go-short-url"
+	"github.com/TomerHeber/go-short-url/shortgrpc/shortpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type apiKeyContextKey struct{}
+
+// ApiKeyAuthenticator authenticates api keys (implemented by `short.Shortener`).
+type ApiKeyAuthenticator interface {
+	AuthenticateApiKey(ctx context.Context, key string) (*short.ApiKey, error)
+}
+
+// ContextWithApiKey returns a copy of `ctx` that carries the api key of a call.
+func ContextWithApiKey(ctx context.Context, key *short.ApiKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// ApiKeyFromContext returns the api key carried by `ctx` (see `ContextWithApiKey`).
+func ApiKeyFromContext(ctx context.Context) (*short.ApiKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(*short.ApiKey)
+	return key, ok
+}
+
+// RequireApiKey returns a unary interceptor that rejects calls without a valid api key with UNAUTHENTICATED.
+// The key is read from the `authorization: Bearer <key>` or the `x-api-key` metadata.
+//
+// The api key is added to the call context. The server returned by `NewServer` then enforces
+// the scopes of the key and restricts keys without the admin scope to the links they own.
+// The calls of a tenant key run on the links of the tenant (see `short.ContextWithTenant`).
+// Without this interceptor the server allows all the operations.
+func RequireApiKey(authenticator ApiKeyAuthenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		key := callApiKey(ctx)
+		if key == "" {
+			return nil, status.Error(codes.Unauthenticated, "missing api key")
+		}
+
+		apiKey, err := authenticator.AuthenticateApiKey(ctx, key)
+		if err != nil {
+			return nil, toStatus(err).Err()
+		}
+
+		ctx = ContextWithApiKey(ctx, apiKey)
+		if apiKey.Tenant != "" {
+			// The calls of a tenant key run on the links of the tenant.
+			ctx = short.ContextWithTenant(ctx, apiKey.Tenant)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func callApiKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if auth := md.Get("authorization"); len(auth) > 0 {
+		if scheme, key, ok := strings.Cut(auth[0], " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(key)
+		}
+		return ""
+	}
+
+	if key := md.Get("x-api-key"); len(key) > 0 {
+		return key[0]
+	}
+
+	return ""
+}
+
+// authorize checks that the api key of the call (if any) has one of `scopes`.
+func authorize(ctx context.Context, scopes ...short.Scope) (*short.ApiKey, error) {
+	key, ok := ApiKeyFromContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+
+	for _, scope := range scopes {
+		if key.HasScope(scope) {
+			return key, nil
+		}
+	}
+
+	return nil, status.Error(codes.PermissionDenied, "the api key does not have the required scope")
+}
+
+// authorizeLink checks that the api key of the call (if any) has one of `scopes` and can access the link `id`.
+// Links that the key cannot access are reported as not found.
+func (s *server) authorizeLink(ctx context.Context, id string, scopes ...short.Scope) error {
+	key, err := authorize(ctx, scopes...)
+	if err != nil {
+		return err
+	}
+	if key == nil || key.HasScope(short.ScopeAdmin) {
+		return nil
+	}
+
+	info, err := s.shortener.GetShortenedUrlInfo(ctx, id)
+	if err != nil {
+		return toStatus(err).Err()
+	}
+
+	if !key.CanAccess(info) {
+		return toStatus(&short.IdNotFoundError{Id: id}).Err()
+	}
+
+	return nil
+}
+
+// ownLink sets the owner of a link created by `key` (may be nil).
+// A key without the admin scope must not take over an alias owned by another key.
+func (s *server) ownLink(ctx context.Context, key *short.ApiKey, req *shortpb.CreateLinkRequest, config short.UrlConfig) (short.UrlConfig, error) {
+	if key == nil {
+		return config, nil
+	}
+
+	config = config.WithOwner(key.Id)
+
+	// The store checks the owner again when it overrides, so a concurrent change of owner is not taken over either.
+	if req.GetAlias() != "" && req.GetOverride() && !key.HasScope(short.ScopeAdmin) {
+		info, err := s.shortener.GetShortenedUrlInfo(ctx, req.GetAlias())
+		if err != nil && !errors.Is(err, &short.IdNotFoundError{}) {
+			return nil, err
+		}
+		if err == nil && !key.CanAccess(info) {
+			return nil, &short.ConflictError{Id: req.GetAlias()}
+		}
+		config = config.WithOverrideOwnedOnly(true)
+	}
+
+	return config, nil
+}
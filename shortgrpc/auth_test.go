@@ -0,0 +1,110 @@
+package shortgrpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/TomerHeber/go-short-url"
+	"github.com/TomerHeber/go-short-url/shortgrpc/shortpb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestApiKeyFromContext(t *testing.T) {
+	_, ok := ApiKeyFromContext(context.Background())
+	require.False(t, ok)
+
+	key := &short.ApiKey{Id: "abc"}
+	res, ok := ApiKeyFromContext(ContextWithApiKey(context.Background(), key))
+	require.True(t, ok)
+	require.Equal(t, key, res)
+}
+
+func TestRequireApiKey(t *testing.T) {
+	s := &memoryShortener{
+		links: map[string]*short.ShortenedUrlInfo{
+			"alice1": {Id: "alice1", Url: "https://alice.com", Owner: "alice"},
+			"bob1":   {Id: "bob1", Url: "https://bob.com", Owner: "bob"},
+		},
+		apiKeys: map[string]*short.ApiKey{
+			"sk_admin": {Id: "admin", Scopes: []short.Scope{short.ScopeAdmin}},
+			"sk_alice": {Id: "alice", Scopes: []short.Scope{short.ScopeCreate}},
+			"sk_stats": {Id: "stats", Scopes: []short.Scope{short.ScopeReadStats}},
+		},
+	}
+	client := newTestClientWithShortener(t, s, grpc.UnaryInterceptor(RequireApiKey(s)))
+
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+key)
+	}
+
+	t.Run("missing api key", func(t *testing.T) {
+		_, err := client.GetLink(context.Background(), &shortpb.GetLinkRequest{Id: "alice1"})
+		requireCode(t, codes.Unauthenticated, err)
+
+		_, err = client.GetLink(metadata.AppendToOutgoingContext(context.Background(), "authorization", "Basic YTpi"), &shortpb.GetLinkRequest{Id: "alice1"})
+		requireCode(t, codes.Unauthenticated, err)
+	})
+
+	t.Run("invalid api key", func(t *testing.T) {
+		_, err := client.GetLink(withKey("sk_unknown"), &shortpb.GetLinkRequest{Id: "alice1"})
+		requireCode(t, codes.Unauthenticated, err)
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		_, err := client.GetLink(withKey("sk_alice"), &shortpb.GetLinkRequest{Id: "alice1"})
+		require.Nil(t, err)
+
+		_, err = client.GetLink(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "sk_alice"), &shortpb.GetLinkRequest{Id: "alice1"})
+		require.Nil(t, err)
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		_, err := client.CreateLink(withKey("sk_stats"), &shortpb.CreateLinkRequest{Url: "https://test.com"})
+		requireCode(t, codes.PermissionDenied, err)
+
+		_, err = client.BatchCreateLinks(withKey("sk_stats"), &shortpb.BatchCreateLinksRequest{Links: []*shortpb.CreateLinkRequest{{Url: "https://test.com"}}})
+		requireCode(t, codes.PermissionDenied, err)
+
+		_, err = client.GetStats(withKey("sk_alice"), &shortpb.GetStatsRequest{Id: "alice1"})
+		requireCode(t, codes.PermissionDenied, err)
+
+		_, err = client.DeleteLink(withKey("sk_stats"), &shortpb.DeleteLinkRequest{Id: "bob1"})
+		requireCode(t, codes.PermissionDenied, err)
+	})
+
+	t.Run("ownership", func(t *testing.T) {
+		_, err := client.GetLink(withKey("sk_alice"), &shortpb.GetLinkRequest{Id: "bob1"})
+		requireCode(t, codes.NotFound, err)
+
+		_, err = client.UpdateLink(withKey("sk_alice"), &shortpb.UpdateLinkRequest{Id: "bob1", Url: "https://evil.com"})
+		requireCode(t, codes.NotFound, err)
+		require.Equal(t, "https://bob.com", s.links["bob1"].Url)
+
+		_, err = client.SetLinkExpiration(withKey("sk_alice"), &shortpb.SetLinkExpirationRequest{Id: "bob1"})
+		requireCode(t, codes.NotFound, err)
+
+		_, err = client.DeleteLink(withKey("sk_alice"), &shortpb.DeleteLinkRequest{Id: "bob1"})
+		requireCode(t, codes.NotFound, err)
+
+		_, err = client.CreateLink(withKey("sk_alice"), &shortpb.CreateLinkRequest{Url: "https://evil.com", Alias: "bob1", Override: true})
+		requireCode(t, codes.AlreadyExists, err)
+
+		link, err := client.UpdateLink(withKey("sk_admin"), &shortpb.UpdateLinkRequest{Id: "bob1", Url: "https://bob2.com"})
+		require.Nil(t, err)
+		require.Equal(t, "https://bob2.com", link.Url)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		res, err := client.ListLinks(withKey("sk_alice"), &shortpb.ListLinksRequest{Limit: 10})
+		require.Nil(t, err)
+		require.Len(t, res.Links, 1)
+		require.Equal(t, "alice1", res.Links[0].Id)
+
+		res, err = client.ListLinks(withKey("sk_admin"), &shortpb.ListLinksRequest{Limit: 10})
+		require.Nil(t, err)
+		require.Len(t, res.Links, len(s.links))
+	})
+}
@@ -0,0 +1,49 @@
+package shortgrpc
+
+import (
+	"strings"
+
+	"github.com/TomerHeber/go-short-url"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain is the domain of the `google.rpc.ErrorInfo` details of the errors.
+const errorDomain = "github.com/TomerHeber/go-short-url"
+
+// statusCodes maps the error codes of the shortener to gRPC codes.
+var statusCodes = map[short.ErrorCode]codes.Code{
+	short.CodeInvalidArgument:    codes.InvalidArgument,
+	short.CodeNotFound:           codes.NotFound,
+	short.CodeGone:               codes.NotFound,
+	short.CodeAlreadyExists:      codes.AlreadyExists,
+	short.CodeUnauthenticated:    codes.Unauthenticated,
+	short.CodePermissionDenied:   codes.PermissionDenied,
+	short.CodeFailedPrecondition: codes.FailedPrecondition,
+	short.CodeRateLimited:        codes.ResourceExhausted,
+	short.CodeQuotaExceeded:      codes.ResourceExhausted,
+}
+
+// toStatus maps an error returned by the shortener to a gRPC status.
+// The status has a `google.rpc.ErrorInfo` detail whose reason is the upper case `short.ErrorCode` (e.g. GONE),
+// which tells apart errors of the same gRPC code. Internal error messages are not exposed.
+func toStatus(err error) *status.Status {
+	code := short.ErrorCodeOf(err)
+
+	st := status.New(codes.Internal, "internal error")
+	if c, ok := statusCodes[code]; ok {
+		st = status.New(c, err.Error())
+	}
+
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: errorReason(code), Domain: errorDomain}); err == nil {
+		st = detailed
+	}
+
+	return st
+}
+
+// errorReason returns the reason of the `google.rpc.ErrorInfo` detail of a code.
+func errorReason(code short.ErrorCode) string {
+	return strings.ToUpper(string(code))
+}
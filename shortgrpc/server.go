@@ -0,0 +1,309 @@
+// Package shortgrpc implements the gRPC service of the shortener (see `shortpb/short.proto`).
+//
+//	s, _ := short.NewShortener()
+//	server := grpc.NewServer()
+//	shortpb.RegisterShortenerServiceServer(server, shortgrpc.NewServer(s, shortgrpc.Options{}))
+//
+// The server does not authenticate the calls by itself. Install the `RequireApiKey` interceptor
+// (e.g. `grpc.NewServer(grpc.UnaryInterceptor(shortgrpc.RequireApiKey(s)))`) unless the service is only reachable by trusted clients.
+package shortgrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative shortpb/short.proto
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/TomerHeber/go-short-url"
+	"github.com/TomerHeber/go-short-url/shortgrpc/shortpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const defaultMaxBatchSize = 100
+
+// Options may be used to customize the server returned by `NewServer`.
+type Options struct {
+	// MaxBatchSize is the maximum number of links in a `BatchCreateLinks` request (default 100).
+	MaxBatchSize int
+}
+
+type server struct {
+	shortpb.UnimplementedShortenerServiceServer
+	shortener short.Shortener
+	options   Options
+}
+
+// NewServer returns a `shortpb.ShortenerServiceServer` that serves the shortener `s`.
+func NewServer(s short.Shortener, options Options) shortpb.ShortenerServiceServer {
+	if options.MaxBatchSize <= 0 {
+		options.MaxBatchSize = defaultMaxBatchSize
+	}
+
+	return &server{
+		shortener: s,
+		options:   options,
+	}
+}
+
+func (s *server) CreateLink(ctx context.Context, req *shortpb.CreateLinkRequest) (*shortpb.Link, error) {
+	key, err := authorize(ctx, short.ScopeCreate)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := s.createLink(ctx, key, req)
+	if err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	return link, nil
+}
+
+// createLink creates a link owned by `key` (may be nil).
+func (s *server) createLink(ctx context.Context, key *short.ApiKey, req *shortpb.CreateLinkRequest) (*shortpb.Link, error) {
+	if req.GetDomain() != "" {
+		ctx = short.ContextWithDomain(ctx, req.GetDomain())
+	}
+
+	config, err := s.ownLink(ctx, key, req, newUrlConfig(req))
+	if err != nil {
+		return nil, err
+	}
+
+	surl, err := s.shortener.CreateShortenedUrl(ctx, req.GetUrl(), config)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getLink(ctx, path.Base(surl.GetUrl()))
+}
+
+func (s *server) getLink(ctx context.Context, id string) (*shortpb.Link, error) {
+	info, err := s.shortener.GetShortenedUrlInfo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLink(info), nil
+}
+
+func (s *server) BatchCreateLinks(ctx context.Context, req *shortpb.BatchCreateLinksRequest) (*shortpb.BatchCreateLinksResponse, error) {
+	key, err := authorize(ctx, short.ScopeCreate)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.GetLinks()) == 0 || len(req.GetLinks()) > s.options.MaxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "the number of links must be between 1 and %d", s.options.MaxBatchSize)
+	}
+
+	res := shortpb.BatchCreateLinksResponse{
+		Results: make([]*shortpb.BatchCreateLinkResult, len(req.GetLinks())),
+	}
+
+	for i, linkReq := range req.GetLinks() {
+		link, err := s.createLink(ctx, key, linkReq)
+		if err != nil {
+			st := toStatus(err)
+			res.Results[i] = &shortpb.BatchCreateLinkResult{Error: &shortpb.Error{
+				Code:    int32(st.Code()),
+				Message: st.Message(),
+				Reason:  errorReason(short.ErrorCodeOf(err)),
+			}}
+			continue
+		}
+		res.Results[i] = &shortpb.BatchCreateLinkResult{Link: link}
+	}
+
+	return &res, nil
+}
+
+func (s *server) ResolveLink(ctx context.Context, req *shortpb.ResolveLinkRequest) (*shortpb.Resolution, error) {
+	if req.GetPassword() != "" {
+		ctx = short.ContextWithPassword(ctx, req.GetPassword())
+	}
+
+	res, err := s.shortener.Resolve(ctx, req.GetId(), &short.RequestMetadata{
+		Referrer:       req.GetReferrer(),
+		UserAgent:      req.GetUserAgent(),
+		ClientIP:       req.GetClientIp(),
+		AcceptLanguage: req.GetAcceptLanguage(),
+	})
+	if err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	return &shortpb.Resolution{
+		Url:          res.Url,
+		RedirectType: int32(res.RedirectType),
+		Bot:          res.Bot,
+	}, nil
+}
+
+func (s *server) GetLink(ctx context.Context, req *shortpb.GetLinkRequest) (*shortpb.Link, error) {
+	if err := s.authorizeLink(ctx, req.GetId(), short.ScopeCreate, short.ScopeReadStats); err != nil {
+		return nil, err
+	}
+
+	link, err := s.getLink(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	return link, nil
+}
+
+func (s *server) UpdateLink(ctx context.Context, req *shortpb.UpdateLinkRequest) (*shortpb.Link, error) {
+	if err := s.authorizeLink(ctx, req.GetId(), short.ScopeCreate); err != nil {
+		return nil, err
+	}
+
+	if err := s.shortener.UpdateDestination(ctx, req.GetId(), req.GetUrl()); err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	link, err := s.getLink(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	return link, nil
+}
+
+func (s *server) SetLinkExpiration(ctx context.Context, req *shortpb.SetLinkExpirationRequest) (*shortpb.Link, error) {
+	if err := s.authorizeLink(ctx, req.GetId(), short.ScopeCreate); err != nil {
+		return nil, err
+	}
+
+	var expiration *time.Time
+	if req.GetExpirationDate() != nil {
+		expirationDate := req.GetExpirationDate().AsTime()
+		expiration = &expirationDate
+	}
+
+	if err := s.shortener.SetExpiration(ctx, req.GetId(), expiration); err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	link, err := s.getLink(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	return link, nil
+}
+
+func (s *server) DeleteLink(ctx context.Context, req *shortpb.DeleteLinkRequest) (*emptypb.Empty, error) {
+	if err := s.authorizeLink(ctx, req.GetId(), short.ScopeCreate); err != nil {
+		return nil, err
+	}
+
+	if err := s.shortener.DeleteShortenedUrl(ctx, req.GetId()); err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *server) ListLinks(ctx context.Context, req *shortpb.ListLinksRequest) (*shortpb.ListLinksResponse, error) {
+	key, err := authorize(ctx, short.ScopeCreate, short.ScopeReadStats)
+	if err != nil {
+		return nil, err
+	}
+
+	options := short.ListOptions{Limit: int(req.GetLimit()), Cursor: req.GetCursor()}
+	if key != nil && !key.HasScope(short.ScopeAdmin) {
+		options.Owner = key.Id
+	}
+
+	res, err := s.shortener.ListShortenedUrls(ctx, options)
+	if err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	links := make([]*shortpb.Link, len(res.ShortenedUrls))
+	for i, info := range res.ShortenedUrls {
+		links[i] = newLink(info)
+	}
+
+	return &shortpb.ListLinksResponse{Links: links, NextCursor: res.NextCursor}, nil
+}
+
+func (s *server) GetStats(ctx context.Context, req *shortpb.GetStatsRequest) (*shortpb.Stats, error) {
+	if err := s.authorizeLink(ctx, req.GetId(), short.ScopeReadStats); err != nil {
+		return nil, err
+	}
+
+	stats, err := s.shortener.GetStats(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err).Err()
+	}
+
+	res := shortpb.Stats{
+		Clicks:         stats.Clicks,
+		BotClicks:      stats.BotClicks,
+		UniqueVisitors: stats.UniqueVisitors,
+	}
+	if stats.LastAccessed != nil {
+		res.LastAccessed = timestamppb.New(*stats.LastAccessed)
+	}
+
+	return &res, nil
+}
+
+func newUrlConfig(req *shortpb.CreateLinkRequest) short.UrlConfig {
+	c := short.DefaultUrlConfig()
+	if req.GetAlias() != "" {
+		c = c.WithAlias(req.GetAlias()).WithOverrideAlias(req.GetOverride())
+	}
+	if req.GetExpirationDate() != nil {
+		c = c.WithExpirationDate(req.GetExpirationDate().AsTime())
+	}
+	if req.GetRedirectType() != 0 {
+		c = c.WithRedirectType(int(req.GetRedirectType()))
+	}
+	if req.GetPassword() != "" {
+		c = c.WithPassword(req.GetPassword())
+	}
+	if req.GetMaxClicks() != 0 {
+		c = c.WithMaxClicks(req.GetMaxClicks())
+	}
+	if req.GetActivationDate() != nil {
+		c = c.WithActivationDate(req.GetActivationDate().AsTime())
+	}
+	if req.GetTtlSeconds() != 0 {
+		c = c.WithTtl(time.Duration(req.GetTtlSeconds()) * time.Second)
+	}
+	if req.GetSlidingExpiration() {
+		c = c.WithSlidingExpiration(true)
+	}
+	return c
+}
+
+func newLink(info *short.ShortenedUrlInfo) *shortpb.Link {
+	link := shortpb.Link{
+		Id:                info.Id,
+		ShortUrl:          info.ShortUrl,
+		Url:               info.Url,
+		RedirectType:      int32(info.RedirectType),
+		Flagged:           info.Flagged,
+		PasswordProtected: info.PasswordProtected,
+		RemainingClicks:   info.RemainingClicks,
+		SlidingTtlSeconds: int64(info.SlidingTtl.Seconds()),
+		State:             info.State.String(),
+	}
+	if info.ExpirationDate != nil {
+		link.ExpirationDate = timestamppb.New(*info.ExpirationDate)
+	}
+	if info.ActivationDate != nil {
+		link.ActivationDate = timestamppb.New(*info.ActivationDate)
+	}
+	if info.CreatedAt != nil {
+		link.CreatedAt = timestamppb.New(*info.CreatedAt)
+	}
+	return &link
+}
@@ -0,0 +1,248 @@
+package shortgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TomerHeber/go-short-url"
+	"github.com/TomerHeber/go-short-url/shortgrpc/shortpb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type shortenedUrl string
+
+func (s shortenedUrl) GetUrl() string {
+	return string(s)
+}
+
+// memoryShortener is an in-memory Shortener with the subset of methods used by the server.
+type memoryShortener struct {
+	short.Shortener
+	links   map[string]*short.ShortenedUrlInfo
+	apiKeys map[string]*short.ApiKey
+	nextId  int
+}
+
+func (s *memoryShortener) AuthenticateApiKey(ctx context.Context, key string) (*short.ApiKey, error) {
+	apiKey, ok := s.apiKeys[key]
+	if !ok {
+		return nil, &short.InvalidApiKeyError{}
+	}
+	return apiKey, nil
+}
+
+func (s *memoryShortener) CreateShortenedUrl(ctx context.Context, url string, config ...short.UrlConfig) (short.ShortenedURL, error) {
+	if strings.Contains(url, "broken") {
+		return nil, errors.New("database is down")
+	}
+	if !strings.HasPrefix(url, "https://") {
+		return nil, &short.ValidationError{}
+	}
+	if strings.Contains(url, "conflict") {
+		return nil, &short.ConflictError{}
+	}
+	s.nextId++
+	id := fmt.Sprintf("id%d", s.nextId)
+	s.links[id] = &short.ShortenedUrlInfo{Id: id, ShortUrl: "https://short.com/" + id, Url: url, State: short.LinkActive}
+	return shortenedUrl("https://short.com/" + id), nil
+}
+
+func (s *memoryShortener) GetShortenedUrlInfo(ctx context.Context, id string) (*short.ShortenedUrlInfo, error) {
+	info, ok := s.links[id]
+	if !ok {
+		return nil, &short.IdNotFoundError{}
+	}
+	return info, nil
+}
+
+func (s *memoryShortener) Resolve(ctx context.Context, id string, md *short.RequestMetadata) (*short.Resolution, error) {
+	info, ok := s.links[id]
+	if !ok {
+		return nil, &short.IdNotFoundError{}
+	}
+	return &short.Resolution{Url: info.Url, RedirectType: 302, Bot: strings.Contains(md.UserAgent, "bot")}, nil
+}
+
+func (s *memoryShortener) ListShortenedUrls(ctx context.Context, options short.ListOptions) (*short.ListResult, error) {
+	ids := make([]string, 0, len(s.links))
+	for id, info := range s.links {
+		if id > options.Cursor && (options.Owner == "" || info.Owner == options.Owner) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var result short.ListResult
+	if len(ids) > options.Limit {
+		ids = ids[:options.Limit]
+		result.NextCursor = ids[len(ids)-1]
+	}
+	for _, id := range ids {
+		result.ShortenedUrls = append(result.ShortenedUrls, s.links[id])
+	}
+	return &result, nil
+}
+
+func (s *memoryShortener) UpdateDestination(ctx context.Context, id string, url string) error {
+	info, ok := s.links[id]
+	if !ok {
+		return &short.IdNotFoundError{}
+	}
+	info.Url = url
+	return nil
+}
+
+func (s *memoryShortener) DeleteShortenedUrl(ctx context.Context, id string) error {
+	if _, ok := s.links[id]; !ok {
+		return &short.IdNotFoundError{}
+	}
+	delete(s.links, id)
+	return nil
+}
+
+func (s *memoryShortener) GetStats(ctx context.Context, id string) (*short.Stats, error) {
+	if _, ok := s.links[id]; !ok {
+		return nil, &short.IdNotFoundError{}
+	}
+	lastAccessed := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	return &short.Stats{Clicks: 5, BotClicks: 1, UniqueVisitors: 3, LastAccessed: &lastAccessed}, nil
+}
+
+func newTestClient(t *testing.T, opts ...grpc.ServerOption) shortpb.ShortenerServiceClient {
+	return newTestClientWithShortener(t, &memoryShortener{links: map[string]*short.ShortenedUrlInfo{}}, opts...)
+}
+
+func newTestClientWithShortener(t *testing.T, shortener short.Shortener, opts ...grpc.ServerOption) shortpb.ShortenerServiceClient {
+	listener := bufconn.Listen(1 << 20)
+
+	s := grpc.NewServer(opts...)
+	shortpb.RegisterShortenerServiceServer(s, NewServer(shortener, Options{MaxBatchSize: 2}))
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return shortpb.NewShortenerServiceClient(conn)
+}
+
+func requireCode(t *testing.T, code codes.Code, err error) {
+	t.Helper()
+	require.Error(t, err)
+	require.Equal(t, code, status.Code(err))
+}
+
+func TestServer(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	t.Run("create", func(t *testing.T) {
+		link, err := client.CreateLink(ctx, &shortpb.CreateLinkRequest{Url: "https://test.com"})
+		require.Nil(t, err)
+		require.Equal(t, "id1", link.Id)
+		require.Equal(t, "https://short.com/id1", link.ShortUrl)
+		require.Equal(t, "https://test.com", link.Url)
+
+		_, err = client.CreateLink(ctx, &shortpb.CreateLinkRequest{Url: "ftp://test.com"})
+		requireCode(t, codes.InvalidArgument, err)
+
+		_, err = client.CreateLink(ctx, &shortpb.CreateLinkRequest{Url: "https://conflict.com"})
+		requireCode(t, codes.AlreadyExists, err)
+		details := status.Convert(err).Details()
+		require.Len(t, details, 1)
+		require.Equal(t, "ALREADY_EXISTS", details[0].(*errdetails.ErrorInfo).Reason)
+
+		_, err = client.CreateLink(ctx, &shortpb.CreateLinkRequest{Url: "https://broken.com"})
+		requireCode(t, codes.Internal, err)
+		require.NotContains(t, err.Error(), "database")
+	})
+
+	t.Run("batch create", func(t *testing.T) {
+		res, err := client.BatchCreateLinks(ctx, &shortpb.BatchCreateLinksRequest{Links: []*shortpb.CreateLinkRequest{
+			{Url: "https://a.com"},
+			{Url: "https://conflict.com"},
+		}})
+		require.Nil(t, err)
+		require.Len(t, res.Results, 2)
+		require.Equal(t, "https://a.com", res.Results[0].Link.Url)
+		require.Nil(t, res.Results[0].Error)
+		require.Nil(t, res.Results[1].Link)
+		require.Equal(t, int32(codes.AlreadyExists), res.Results[1].Error.Code)
+		require.Equal(t, "ALREADY_EXISTS", res.Results[1].Error.Reason)
+
+		_, err = client.BatchCreateLinks(ctx, &shortpb.BatchCreateLinksRequest{Links: make([]*shortpb.CreateLinkRequest, 3)})
+		requireCode(t, codes.InvalidArgument, err)
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		res, err := client.ResolveLink(ctx, &shortpb.ResolveLinkRequest{Id: "id1", UserAgent: "Googlebot"})
+		require.Nil(t, err)
+		require.Equal(t, "https://test.com", res.Url)
+		require.Equal(t, int32(302), res.RedirectType)
+		require.True(t, res.Bot)
+
+		_, err = client.ResolveLink(ctx, &shortpb.ResolveLinkRequest{Id: "missing"})
+		requireCode(t, codes.NotFound, err)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		link, err := client.UpdateLink(ctx, &shortpb.UpdateLinkRequest{Id: "id1", Url: "https://updated.com"})
+		require.Nil(t, err)
+		require.Equal(t, "https://updated.com", link.Url)
+
+		link, err = client.GetLink(ctx, &shortpb.GetLinkRequest{Id: "id1"})
+		require.Nil(t, err)
+		require.Equal(t, "https://updated.com", link.Url)
+
+		_, err = client.UpdateLink(ctx, &shortpb.UpdateLinkRequest{Id: "missing", Url: "https://updated.com"})
+		requireCode(t, codes.NotFound, err)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		res, err := client.ListLinks(ctx, &shortpb.ListLinksRequest{Limit: 1})
+		require.Nil(t, err)
+		require.Len(t, res.Links, 1)
+		require.Equal(t, "id1", res.NextCursor)
+
+		res, err = client.ListLinks(ctx, &shortpb.ListLinksRequest{Limit: 1, Cursor: res.NextCursor})
+		require.Nil(t, err)
+		require.Len(t, res.Links, 1)
+		require.Equal(t, "id2", res.Links[0].Id)
+		require.Empty(t, res.NextCursor)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		stats, err := client.GetStats(ctx, &shortpb.GetStatsRequest{Id: "id1"})
+		require.Nil(t, err)
+		require.Equal(t, int64(5), stats.Clicks)
+		require.Equal(t, int64(1), stats.BotClicks)
+		require.Equal(t, uint64(3), stats.UniqueVisitors)
+		require.True(t, time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC).Equal(stats.LastAccessed.AsTime()))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		_, err := client.DeleteLink(ctx, &shortpb.DeleteLinkRequest{Id: "id1"})
+		require.Nil(t, err)
+
+		_, err = client.DeleteLink(ctx, &shortpb.DeleteLinkRequest{Id: "id1"})
+		requireCode(t, codes.NotFound, err)
+	})
+}
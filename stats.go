@@ -0,0 +1,47 @@
+package short
+
+import "time"
+
+// Stats holds the click statistics of a shortened url.
+type Stats struct {
+	// Clicks is the total number of times the shortened url was resolved (bot clicks excluded).
+	Clicks int64
+	// BotClicks is the total number of times the shortened url was resolved by bots.
+	BotClicks int64
+	// LastAccessed is the last time the shortened url was resolved (nil if it was never resolved).
+	LastAccessed *time.Time
+	// UniqueVisitors is the approximate number of unique visitors (by client ip and user agent).
+	UniqueVisitors uint64
+}
+
+// TimeSeries holds the clicks of a shortened url in a time range.
+type TimeSeries struct {
+	Granularity Granularity
+	// UniqueVisitors is the approximate number of unique visitors in the time range.
+	UniqueVisitors uint64
+	// Buckets are ordered by time. Buckets without clicks are included.
+	Buckets []TimeBucket
+	// TopReferrers are the referrers with the most clicks in the time range.
+	TopReferrers []Count
+	// TopUserAgents are the user agents with the most clicks in the time range.
+	TopUserAgents []Count
+	// Countries are the clicks per country in the time range, ordered by clicks (requires a GeoResolver).
+	Countries []Count
+	// Regions are the clicks per country subdivision in the time range, ordered by clicks (requires a GeoResolver).
+	Regions []Count
+}
+
+// TimeBucket holds the number of clicks in a time bucket.
+type TimeBucket struct {
+	Start     time.Time
+	Clicks    int64
+	BotClicks int64
+	// UniqueVisitors is the approximate number of unique visitors in the time bucket.
+	UniqueVisitors uint64
+}
+
+// Count is the number of clicks of a value (e.g. a referrer).
+type Count struct {
+	Value string
+	Count int64
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,20 +20,173 @@ type Store interface {
 	// Insert adds a record to the storage.
 	// url, id and override are passed via an insertConfig struct.
 	Insert(ctx context.Context, ic *insertConfig) error
-	// GetUrl returns the url given an id.
-	GetUrl(ctx context.Context, id string) (string, error)
+	// GetUrl returns the url record given an id.
+	// It fails with an `IdNotFoundError` if the id is missing, an `ExpiredError` if it expired
+	// and a `GoneError` if it was deleted or used up its clicks.
+	GetUrl(ctx context.Context, id string) (*record, error)
+	// GetUrlState returns the record of an id and its state, without failing on ids that do not resolve.
+	// The record is nil if the id is missing and holds only the id and the deletion time if it was deleted.
+	GetUrlState(ctx context.Context, id string) (*record, LinkState, error)
+	// DecrementRemainingClicks atomically takes one of the remaining clicks of a click-limited id.
+	// It fails with a `GoneError` if no clicks remain.
+	DecrementRemainingClicks(ctx context.Context, id string) error
+	// IncrementClicks atomically increments the click counter (or the bot click counter) of an id and updates its last accessed time.
+	IncrementClicks(ctx context.Context, id string, bot bool) error
+	// GetStats returns the click statistics of an id.
+	GetStats(ctx context.Context, id string) (*Stats, error)
+	// RecordClicks adds click events to the hourly and daily rollups.
+	RecordClicks(ctx context.Context, events []ClickEvent) error
+	// GetTimeSeries returns the clicks of an id in the time range [from, to) from the rollups.
+	GetTimeSeries(ctx context.Context, id string, from time.Time, to time.Time, granularity Granularity) (*TimeSeries, error)
+	// Update sets the url of an existing id and whether it is flagged.
+	Update(ctx context.Context, id string, url string, flagged bool) error
+	// SetExpiration sets the fixed expiration of an existing id (nil removes it) and stops a sliding expiration.
+	SetExpiration(ctx context.Context, id string, expiration *time.Time) error
+	// ExtendExpiration moves the expiration of an existing id to `expiration` unless it is already later.
+	ExtendExpiration(ctx context.Context, id string, expiration time.Time) error
+	// Delete replaces an id with a tombstone (see `LinkTombstoned`) and removes its rollups.
+	Delete(ctx context.Context, id string) error
+	// List returns up to `limit` records with an id greater than `after`, ordered by id (tombstones are skipped).
+	// If `owner` is set only the records of the owner are returned.
+	List(ctx context.Context, lq *listQuery) ([]*record, error)
+	// InsertApiKey adds an api key to the storage.
+	InsertApiKey(ctx context.Context, key *apiKeyRecord) error
+	// GetApiKey returns the api key record given the hash of the key.
+	GetApiKey(ctx context.Context, hash string) (*apiKeyRecord, error)
+	// ListApiKeys returns all the api key records.
+	ListApiKeys(ctx context.Context) ([]*apiKeyRecord, error)
+	// DeleteApiKey removes the api key `id`.
+	DeleteApiKey(ctx context.Context, id string) error
+	// CountLinks returns the number of records (tombstones are not counted).
+	CountLinks(ctx context.Context) (int64, error)
+	// CountApiKeys returns the number of api keys.
+	CountApiKeys(ctx context.Context) (int64, error)
+	// Drop removes the records, the rollups and the api keys of the store.
+	Drop(ctx context.Context) error
+	// InsertTenant adds a tenant to the storage.
+	InsertTenant(ctx context.Context, tenant *tenantRecord) error
+	// GetTenant returns the tenant `id`.
+	GetTenant(ctx context.Context, id string) (*tenantRecord, error)
+	// GetTenantByDomain returns the tenant that owns `domain`.
+	GetTenantByDomain(ctx context.Context, domain string) (*tenantRecord, error)
+	// UpdateTenant replaces the name, the domains and the quota of an existing tenant.
+	UpdateTenant(ctx context.Context, tenant *tenantRecord) error
+	// ListTenants returns all the tenants ordered by id.
+	ListTenants(ctx context.Context) ([]*tenantRecord, error)
+	// DeleteTenant removes the tenant `id`.
+	DeleteTenant(ctx context.Context, id string) error
+	// LockTenantQuota takes the quota lock of `resource` of the tenant `id` until `until`.
+	// It returns false if the lock is held by another caller and its lease did not end at `now`.
+	LockTenantQuota(ctx context.Context, id string, resource string, now time.Time, until time.Time) (bool, error)
+	// UnlockTenantQuota releases the quota lock of `resource` of the tenant `id` taken until `until`
+	// (a lock taken by another caller after the lease ended is not released).
+	UnlockTenantQuota(ctx context.Context, id string, resource string, until time.Time) error
 }
 
 type insertConfig struct {
-	url        string
-	id         string
-	override   bool
-	expiration *time.Time
+	url          string
+	id           string
+	override     bool
+	expiration   *time.Time
+	redirectType int
+	owner        string
+	// domain is recorded when the store is shared by multiple domains (empty otherwise).
+	domain string
+	// flagged marks a url on a third-party shortener domain.
+	flagged bool
+	// passwordHash is the bcrypt hash of the password of the record (empty if not protected).
+	passwordHash string
+	// maxClicks is the number of resolves of a click-limited record (0 if unlimited).
+	maxClicks int64
+	// activation is the time the record starts to resolve (nil if active immediately).
+	activation *time.Time
+	// slidingTtl extends the expiration on each resolve (0 if the expiration is fixed).
+	slidingTtl time.Duration
+	// overrideOwnedOnly overrides only a record of the owner or without an owner.
+	overrideOwnedOnly bool
+}
+
+type listQuery struct {
+	owner string
+	after string
+	limit int
+}
+
+// record is a shortened url as stored in the store.
+type record struct {
+	Id        string `bson:"id"`
+	Url       string `bson:"url"`
+	CreatedAt int64  `bson:"createdAt,omitempty"`
+	ExpireAt  *int64 `bson:"expireAt,omitempty"`
+	// RedirectType is the http status code used to redirect to the url (0 if not set).
+	RedirectType int `bson:"redirectType,omitempty"`
+	// Owner is the id of the api key that owns the record (empty if not owned).
+	Owner string `bson:"owner,omitempty"`
+	// Domain is the domain of the record in a store that is shared by multiple domains (empty if not set).
+	Domain string `bson:"domain,omitempty"`
+	// Flagged is true if the url is on a third-party shortener domain.
+	Flagged bool `bson:"flagged,omitempty"`
+	// PasswordHash is the bcrypt hash of the password (empty if not protected).
+	PasswordHash string `bson:"passwordHash,omitempty"`
+	// RemainingClicks is the number of resolves left of a click-limited record (nil if unlimited).
+	RemainingClicks *int64 `bson:"remainingClicks,omitempty"`
+	// ActivateAt is the unix time the record starts to resolve (nil if active immediately).
+	ActivateAt *int64 `bson:"activateAt,omitempty"`
+	// SlidingTtl is the number of seconds the expiration is extended to on each resolve (0 if the expiration is fixed).
+	SlidingTtl int64 `bson:"slidingTtl,omitempty"`
+	// DeletedAt is the unix time the record was deleted (nil unless the record is a tombstone).
+	DeletedAt *int64 `bson:"deletedAt,omitempty"`
+}
+
+// optionalRecordFields are the fields of a record that are set by `Insert` only when the insertConfig sets them.
+var optionalRecordFields = []string{
+	"expireAt", "redirectType", "owner", "domain", "flagged", "passwordHash", "remainingClicks", "activateAt", "slidingTtl",
+}
+
+// state returns the state of the record at `now`.
+func (r *record) state(now time.Time) LinkState {
+	switch {
+	case r.DeletedAt != nil:
+		return LinkTombstoned
+	case r.ExpireAt != nil && now.Unix() > *r.ExpireAt:
+		return LinkExpired
+	case r.RemainingClicks != nil && *r.RemainingClicks <= 0:
+		return LinkDisabled
+	default:
+		return LinkActive
+	}
+}
+
+// liveFilter matches the record of an id unless it is a tombstone.
+func liveFilter(id string) bson.M {
+	return bson.M{"id": id, "deletedAt": bson.M{"$exists": false}}
+}
+
+// apiKeyRecord is an api key as stored in the store.
+type apiKeyRecord struct {
+	Id        string   `bson:"id"`
+	Name      string   `bson:"name"`
+	Hash      string   `bson:"hash"`
+	Scopes    []string `bson:"scopes"`
+	CreatedAt int64    `bson:"createdAt"`
+}
+
+// tenantRecord is a tenant as stored in the store.
+type tenantRecord struct {
+	Id         string   `bson:"id"`
+	Name       string   `bson:"name"`
+	Domains    []string `bson:"domains,omitempty"`
+	MaxLinks   int64    `bson:"maxLinks,omitempty"`
+	MaxApiKeys int64    `bson:"maxApiKeys,omitempty"`
+	CreatedAt  int64    `bson:"createdAt"`
 }
 
 type store struct {
 	name       string
 	collection *mongo.Collection
+	rollups    *mongo.Collection
+	apiKeys    *mongo.Collection
+	tenants    *mongo.Collection
 }
 
 const collectionsMapName = "collections_map"
@@ -104,6 +258,10 @@ func getMongoCollection(ctx context.Context, database *mongo.Database, name stri
 		{
 			Keys:    bson.M{"expireAt": 1},
 			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "id", Value: 1}},
+			Options: options.Index().SetSparse(true),
 		}}); err != nil {
 		return nil, fmt.Errorf("failed to create an index for collection %s: %w", payload.CollectionName, err)
 	}
@@ -111,10 +269,66 @@ func getMongoCollection(ctx context.Context, database *mongo.Database, name stri
 	return collection, nil
 }
 
-func newStore(mongoUri string, name string) (Store, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// getMongoRollupsCollection returns the collection that holds the click rollups of `collection`.
+func getMongoRollupsCollection(ctx context.Context, collection *mongo.Collection) (*mongo.Collection, error) {
+	name := collection.Name() + "_rollups"
+
+	rollups := collection.Database().Collection(name)
+	// Index the collection.
+	if _, err := rollups.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}, {Key: "granularity", Value: 1}, {Key: "start", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create an index for collection %s: %w", name, err)
+	}
+
+	return rollups, nil
+}
+
+// getMongoApiKeysCollection returns the collection that holds the api keys of `collection`.
+func getMongoApiKeysCollection(ctx context.Context, collection *mongo.Collection) (*mongo.Collection, error) {
+	name := collection.Name() + "_api_keys"
+
+	apiKeys := collection.Database().Collection(name)
+	// Index the collection.
+	if _, err := apiKeys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"id": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.M{"hash": 1},
+			Options: options.Index().SetUnique(true),
+		}}); err != nil {
+		return nil, fmt.Errorf("failed to create an index for collection %s: %w", name, err)
+	}
+
+	return apiKeys, nil
+}
+
+// getMongoTenantsCollection returns the collection that holds the tenants of `collection`.
+func getMongoTenantsCollection(ctx context.Context, collection *mongo.Collection) (*mongo.Collection, error) {
+	name := collection.Name() + "_tenants"
+
+	tenants := collection.Database().Collection(name)
+	// Index the collection. A domain belongs to a single tenant.
+	if _, err := tenants.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"id": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.M{"domains": 1},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		}}); err != nil {
+		return nil, fmt.Errorf("failed to create an index for collection %s: %w", name, err)
+	}
+
+	return tenants, nil
+}
 
+// getMongoDatabase returns the database in `mongoUri` (defaults to `short` if the URI does not contain a database name).
+func getMongoDatabase(ctx context.Context, mongoUri string) (*mongo.Database, error) {
 	client, err := getMongoClient(ctx, mongoUri)
 	if err != nil {
 		return nil, err
@@ -132,16 +346,44 @@ func newStore(mongoUri string, name string) (Store, error) {
 		cs.Database = "short"
 	}
 
-	database := client.Database(cs.Database)
+	return client.Database(cs.Database), nil
+}
+
+func newStore(mongoUri string, name string) (Store, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	database, err := getMongoDatabase(ctx, mongoUri)
+	if err != nil {
+		return nil, err
+	}
 
 	collection, err := getMongoCollection(ctx, database, name)
 	if err != nil {
 		return nil, err
 	}
 
+	rollups, err := getMongoRollupsCollection(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := getMongoApiKeysCollection(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants, err := getMongoTenantsCollection(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+
 	return &store{
 		name:       name,
 		collection: collection,
+		rollups:    rollups,
+		apiKeys:    apiKeys,
+		tenants:    tenants,
 	}, nil
 }
 
@@ -150,22 +392,73 @@ func (s *store) Insert(ctx context.Context, ic *insertConfig) error {
 	if ic.expiration != nil {
 		toSet["expireAt"] = ic.expiration.Unix()
 	}
+	if ic.redirectType != 0 {
+		toSet["redirectType"] = ic.redirectType
+	}
+	if ic.owner != "" {
+		toSet["owner"] = ic.owner
+	}
+	if ic.domain != "" {
+		toSet["domain"] = ic.domain
+	}
+	if ic.flagged {
+		toSet["flagged"] = true
+	}
+	if ic.passwordHash != "" {
+		toSet["passwordHash"] = ic.passwordHash
+	}
+	if ic.maxClicks != 0 {
+		toSet["remainingClicks"] = ic.maxClicks
+	}
+	if ic.activation != nil {
+		toSet["activateAt"] = ic.activation.Unix()
+	}
+	if ic.slidingTtl != 0 {
+		toSet["slidingTtl"] = int64(ic.slidingTtl.Seconds())
+	}
+
+	// The id of a deleted record may be reused.
+	if _, err := s.collection.DeleteOne(ctx, bson.M{"id": ic.id, "deletedAt": bson.M{"$exists": true}}); err != nil {
+		return fmt.Errorf("failed to delete the tombstone of id %s: %w", ic.id, err)
+	}
+
+	now := time.Now().Unix()
 
 	if ic.override {
-		if _, err := s.collection.UpdateOne(
-			ctx,
-			bson.M{"id": ic.id},
-			bson.M{"$set": toSet},
-			options.Update().SetUpsert(true),
-		); err != nil {
+		update := bson.M{"$set": toSet, "$setOnInsert": bson.M{"createdAt": now}}
+
+		// The overridden record must not keep the settings of the previous one (e.g. its password or its remaining clicks).
+		toUnset := bson.M{}
+		for _, field := range optionalRecordFields {
+			if _, ok := toSet[field]; !ok {
+				toUnset[field] = ""
+			}
+		}
+		if len(toUnset) > 0 {
+			update["$unset"] = toUnset
+		}
+
+		filter := bson.M{"id": ic.id}
+		if ic.overrideOwnedOnly {
+			// The owner is checked by the update itself, a concurrent change of owner cannot slip in between.
+			// A record of another owner does not match and the upsert fails with a duplicate key.
+			filter["$or"] = bson.A{bson.M{"owner": ic.owner}, bson.M{"owner": bson.M{"$exists": false}}}
+		}
+
+		if _, err := s.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return &ConflictError{Id: ic.id}
+			}
 			return fmt.Errorf("failed to update or insert id %s: %w", ic.id, err)
 		}
 		return nil
 	}
 
+	toSet["createdAt"] = now
+
 	if _, err := s.collection.InsertOne(ctx, toSet); err != nil {
 		if mongo.IsDuplicateKeyError(err) {
-			return &ConflictError{}
+			return &ConflictError{Id: ic.id}
 		}
 		return fmt.Errorf("failed to insert id %s: %w", ic.id, err)
 	}
@@ -173,28 +466,564 @@ func (s *store) Insert(ctx context.Context, ic *insertConfig) error {
 	return nil
 }
 
-func (s *store) GetUrl(ctx context.Context, id string) (string, error) {
+func (s *store) GetUrl(ctx context.Context, id string) (*record, error) {
+	rec, state, err := s.GetUrlState(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch state {
+	case LinkMissing:
+		return nil, &IdNotFoundError{Id: id}
+	case LinkExpired:
+		return nil, &ExpiredError{Id: id, ExpirationDate: time.Unix(*rec.ExpireAt, 0)}
+	case LinkDisabled, LinkTombstoned:
+		return nil, &GoneError{Id: id, State: state}
+	}
+
+	return rec, nil
+}
+
+func (s *store) GetUrlState(ctx context.Context, id string) (*record, LinkState, error) {
 	res := s.collection.FindOne(ctx, bson.M{"id": id})
 	if res.Err() != nil {
 		if errors.Is(res.Err(), mongo.ErrNoDocuments) {
-			return "", &IdNotFoundError{id: id}
+			return nil, LinkMissing, nil
 		}
 
-		return "", fmt.Errorf("error when calling FindOne in the store %s: %w", s.name, res.Err())
+		return nil, LinkMissing, fmt.Errorf("error when calling FindOne in the store %s: %w", s.name, res.Err())
+	}
+
+	var payload record
+
+	if err := res.Decode(&payload); err != nil {
+		return nil, LinkMissing, fmt.Errorf("failed to decode record: %w", err)
+	}
+
+	return &payload, payload.state(time.Now()), nil
+}
+
+func (s *store) DecrementRemainingClicks(ctx context.Context, id string) error {
+	// The filter makes the decrement atomic, concurrent resolves cannot take more clicks than remain.
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"id": id, "remainingClicks": bson.M{"$gt": 0}, "deletedAt": bson.M{"$exists": false}},
+		bson.M{"$inc": bson.M{"remainingClicks": -1}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to decrement the remaining clicks of id %s: %w", id, err)
+	}
+
+	if res.MatchedCount == 0 {
+		return &GoneError{Id: id, State: LinkDisabled}
+	}
+
+	return nil
+}
+
+func (s *store) IncrementClicks(ctx context.Context, id string, bot bool) error {
+	counter := "clicks"
+	if bot {
+		counter = "botClicks"
+	}
+
+	res, err := s.collection.UpdateOne(
+		ctx,
+		liveFilter(id),
+		bson.M{
+			"$inc": bson.M{counter: 1},
+			"$set": bson.M{"lastAccessedAt": time.Now().Unix()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to increment clicks of id %s: %w", id, err)
+	}
+
+	if res.MatchedCount == 0 {
+		return &IdNotFoundError{Id: id}
+	}
+
+	return nil
+}
+
+func (s *store) GetStats(ctx context.Context, id string) (*Stats, error) {
+	res := s.collection.FindOne(ctx, liveFilter(id))
+	if res.Err() != nil {
+		if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+			return nil, &IdNotFoundError{Id: id}
+		}
+
+		return nil, fmt.Errorf("error when calling FindOne in the store %s: %w", s.name, res.Err())
 	}
 
 	var payload struct {
-		Url      string `bson:"url"`
-		ExpireAt *int64 `bson:"expireAt,omitempty"`
+		Clicks         int64            `bson:"clicks"`
+		BotClicks      int64            `bson:"botClicks"`
+		LastAccessedAt *int64           `bson:"lastAccessedAt,omitempty"`
+		Visitors       map[string]int32 `bson:"hll"`
 	}
 
 	if err := res.Decode(&payload); err != nil {
-		return "", fmt.Errorf("failed to decode record: %w", err)
+		return nil, fmt.Errorf("failed to decode record: %w", err)
 	}
 
-	if payload.ExpireAt != nil && time.Now().Unix() > *payload.ExpireAt {
-		return "", &IdNotFoundError{id: id}
+	stats := Stats{Clicks: payload.Clicks, BotClicks: payload.BotClicks, UniqueVisitors: newHyperLogLogFromRegisters(payload.Visitors).estimate()}
+	if payload.LastAccessedAt != nil {
+		lastAccessed := time.Unix(*payload.LastAccessedAt, 0)
+		stats.LastAccessed = &lastAccessed
+	}
+
+	return &stats, nil
+}
+
+func (s *store) RecordClicks(ctx context.Context, events []ClickEvent) error {
+	rollups := aggregateClickEvents(events)
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(rollups))
+	var linkModels []mongo.WriteModel
+
+	for key, r := range rollups {
+		toInc := bson.M{"clicks": r.clicks, "botClicks": r.botClicks}
+		for referrer, count := range r.referrers {
+			toInc["referrers."+referrer] = count
+		}
+		for userAgent, count := range r.userAgents {
+			toInc["userAgents."+userAgent] = count
+		}
+		for country, count := range r.countries {
+			toInc["countries."+country] = count
+		}
+		for region, count := range r.regions {
+			toInc["regions."+region] = count
+		}
+
+		update := bson.M{"$inc": toInc}
+		if len(r.visitors) > 0 {
+			toMax := bson.M{}
+			for index, rank := range r.visitors {
+				toMax[hllRegisterKey(index)] = rank
+			}
+			update["$max"] = toMax
+
+			// The unique visitors of the link are the union of its daily unique visitors.
+			if key.granularity == GranularityDay {
+				linkModels = append(linkModels, mongo.NewUpdateOneModel().
+					SetFilter(liveFilter(key.id)).
+					SetUpdate(bson.M{"$max": toMax}))
+			}
+		}
+
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"id": key.id, "granularity": key.granularity, "start": key.start}).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+
+	if _, err := s.rollups.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to update the rollups in the store %s: %w", s.name, err)
+	}
+
+	if len(linkModels) > 0 {
+		if _, err := s.collection.BulkWrite(ctx, linkModels, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("failed to update the unique visitors in the store %s: %w", s.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *store) GetTimeSeries(ctx context.Context, id string, from time.Time, to time.Time, granularity Granularity) (*TimeSeries, error) {
+	start := granularity.bucketStart(from)
+
+	cursor, err := s.rollups.Find(
+		ctx,
+		bson.M{"id": id, "granularity": granularity, "start": bson.M{"$gte": start.Unix(), "$lt": to.Unix()}},
+		options.Find().SetSort(bson.M{"start": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error when calling Find in the store %s: %w", s.name, err)
+	}
+
+	var payload []struct {
+		Start      int64            `bson:"start"`
+		Clicks     int64            `bson:"clicks"`
+		BotClicks  int64            `bson:"botClicks"`
+		Referrers  map[string]int64 `bson:"referrers"`
+		UserAgents map[string]int64 `bson:"userAgents"`
+		Countries  map[string]int64 `bson:"countries"`
+		Regions    map[string]int64 `bson:"regions"`
+		Visitors   map[string]int32 `bson:"hll"`
+	}
+
+	if err := cursor.All(ctx, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode rollups: %w", err)
+	}
+
+	clicks := map[int64]int64{}
+	botClicks := map[int64]int64{}
+	visitors := map[int64]*hyperLogLog{}
+	referrers := map[string]int64{}
+	userAgents := map[string]int64{}
+	countries := map[string]int64{}
+	regions := map[string]int64{}
+	allVisitors := &hyperLogLog{}
+
+	for _, p := range payload {
+		clicks[p.Start] += p.Clicks
+		botClicks[p.Start] += p.BotClicks
+		visitors[p.Start] = newHyperLogLogFromRegisters(p.Visitors)
+		allVisitors.merge(visitors[p.Start])
+		for referrer, count := range p.Referrers {
+			referrers[referrer] += count
+		}
+		for userAgent, count := range p.UserAgents {
+			userAgents[userAgent] += count
+		}
+		for country, count := range p.Countries {
+			countries[country] += count
+		}
+		for region, count := range p.Regions {
+			regions[region] += count
+		}
+	}
+
+	ts := TimeSeries{Granularity: granularity, UniqueVisitors: allVisitors.estimate()}
+
+	for t := start; t.Before(to); t = granularity.next(t) {
+		bucket := TimeBucket{Start: t, Clicks: clicks[t.Unix()], BotClicks: botClicks[t.Unix()]}
+		if v, ok := visitors[t.Unix()]; ok {
+			bucket.UniqueVisitors = v.estimate()
+		}
+		ts.Buckets = append(ts.Buckets, bucket)
 	}
 
-	return payload.Url, nil
+	if ts.TopReferrers, err = topCounts(referrers, topCountsLimit); err != nil {
+		return nil, err
+	}
+	if ts.TopUserAgents, err = topCounts(userAgents, topCountsLimit); err != nil {
+		return nil, err
+	}
+	if ts.Countries, err = topCounts(countries, len(countries)); err != nil {
+		return nil, err
+	}
+	if ts.Regions, err = topCounts(regions, len(regions)); err != nil {
+		return nil, err
+	}
+
+	return &ts, nil
+}
+
+func (s *store) Update(ctx context.Context, id string, url string, flagged bool) error {
+	update := bson.M{"$set": bson.M{"url": url}, "$unset": bson.M{"flagged": ""}}
+	if flagged {
+		update = bson.M{"$set": bson.M{"url": url, "flagged": true}}
+	}
+
+	res, err := s.collection.UpdateOne(ctx, liveFilter(id), update)
+	if err != nil {
+		return fmt.Errorf("failed to update id %s: %w", id, err)
+	}
+
+	if res.MatchedCount == 0 {
+		return &IdNotFoundError{Id: id}
+	}
+
+	return nil
+}
+
+func (s *store) SetExpiration(ctx context.Context, id string, expiration *time.Time) error {
+	update := bson.M{"$unset": bson.M{"expireAt": "", "slidingTtl": ""}}
+	if expiration != nil {
+		update = bson.M{"$set": bson.M{"expireAt": expiration.Unix()}, "$unset": bson.M{"slidingTtl": ""}}
+	}
+
+	res, err := s.collection.UpdateOne(ctx, liveFilter(id), update)
+	if err != nil {
+		return fmt.Errorf("failed to set the expiration of id %s: %w", id, err)
+	}
+
+	if res.MatchedCount == 0 {
+		return &IdNotFoundError{Id: id}
+	}
+
+	return nil
+}
+
+func (s *store) ExtendExpiration(ctx context.Context, id string, expiration time.Time) error {
+	// $max never moves the expiration back, e.g. when concurrent resolves are applied out of order.
+	res, err := s.collection.UpdateOne(ctx, liveFilter(id), bson.M{"$max": bson.M{"expireAt": expiration.Unix()}})
+	if err != nil {
+		return fmt.Errorf("failed to extend the expiration of id %s: %w", id, err)
+	}
+
+	if res.MatchedCount == 0 {
+		return &IdNotFoundError{Id: id}
+	}
+
+	return nil
+}
+
+func (s *store) Delete(ctx context.Context, id string) error {
+	// The tombstone tells a deleted id apart from a missing one (see `GetUrlState`).
+	res, err := s.collection.ReplaceOne(ctx, liveFilter(id), bson.M{"id": id, "deletedAt": time.Now().Unix()})
+	if err != nil {
+		return fmt.Errorf("failed to delete id %s: %w", id, err)
+	}
+
+	if res.MatchedCount == 0 {
+		return &IdNotFoundError{Id: id}
+	}
+
+	// A new shortened url with the same id must not inherit the rollups.
+	if _, err := s.rollups.DeleteMany(ctx, bson.M{"id": id}); err != nil {
+		return fmt.Errorf("failed to delete the rollups of id %s: %w", id, err)
+	}
+
+	return nil
+}
+
+func (s *store) List(ctx context.Context, lq *listQuery) ([]*record, error) {
+	filter := bson.M{"deletedAt": bson.M{"$exists": false}}
+	if lq.after != "" {
+		filter["id"] = bson.M{"$gt": lq.after}
+	}
+	if lq.owner != "" {
+		filter["owner"] = lq.owner
+	}
+
+	cursor, err := s.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"id": 1}).SetLimit(int64(lq.limit)))
+	if err != nil {
+		return nil, fmt.Errorf("error when calling Find in the store %s: %w", s.name, err)
+	}
+
+	var records []*record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode records: %w", err)
+	}
+
+	return records, nil
+}
+
+func (s *store) InsertApiKey(ctx context.Context, key *apiKeyRecord) error {
+	if _, err := s.apiKeys.InsertOne(ctx, key); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return &ConflictError{Id: key.Id}
+		}
+		return fmt.Errorf("failed to insert api key %s: %w", key.Id, err)
+	}
+
+	return nil
+}
+
+func (s *store) GetApiKey(ctx context.Context, hash string) (*apiKeyRecord, error) {
+	res := s.apiKeys.FindOne(ctx, bson.M{"hash": hash})
+	if res.Err() != nil {
+		if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+			return nil, &InvalidApiKeyError{}
+		}
+
+		return nil, fmt.Errorf("error when calling FindOne in the store %s: %w", s.name, res.Err())
+	}
+
+	var payload apiKeyRecord
+
+	if err := res.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("failed to decode api key: %w", err)
+	}
+
+	return &payload, nil
+}
+
+func (s *store) ListApiKeys(ctx context.Context) ([]*apiKeyRecord, error) {
+	cursor, err := s.apiKeys.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("error when calling Find in the store %s: %w", s.name, err)
+	}
+
+	var keys []*apiKeyRecord
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (s *store) DeleteApiKey(ctx context.Context, id string) error {
+	res, err := s.apiKeys.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete api key %s: %w", id, err)
+	}
+
+	if res.DeletedCount == 0 {
+		return &IdNotFoundError{Id: id}
+	}
+
+	return nil
+}
+
+func (s *store) CountLinks(ctx context.Context) (int64, error) {
+	count, err := s.collection.CountDocuments(ctx, bson.M{"deletedAt": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the records of the store %s: %w", s.name, err)
+	}
+
+	return count, nil
+}
+
+func (s *store) CountApiKeys(ctx context.Context) (int64, error) {
+	count, err := s.apiKeys.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the api keys of the store %s: %w", s.name, err)
+	}
+
+	return count, nil
+}
+
+func (s *store) Drop(ctx context.Context) error {
+	for _, collection := range []*mongo.Collection{s.collection, s.rollups, s.apiKeys, s.tenants} {
+		if err := collection.Drop(ctx); err != nil {
+			return fmt.Errorf("failed to drop collection %s: %w", collection.Name(), err)
+		}
+	}
+
+	if _, err := s.collection.Database().Collection(collectionsMapName).DeleteOne(ctx, bson.M{"name": s.name}); err != nil {
+		return fmt.Errorf("failed to delete the collection mapping of %s: %w", s.name, err)
+	}
+
+	return nil
+}
+
+func (s *store) InsertTenant(ctx context.Context, tenant *tenantRecord) error {
+	if _, err := s.tenants.InsertOne(ctx, tenant); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return &ConflictError{}
+		}
+		return fmt.Errorf("failed to insert tenant %s: %w", tenant.Id, err)
+	}
+
+	return nil
+}
+
+func (s *store) findTenant(ctx context.Context, filter bson.M, id string) (*tenantRecord, error) {
+	res := s.tenants.FindOne(ctx, filter)
+	if res.Err() != nil {
+		if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+			return nil, &TenantNotFoundError{Tenant: id}
+		}
+
+		return nil, fmt.Errorf("error when calling FindOne in the store %s: %w", s.name, res.Err())
+	}
+
+	var payload tenantRecord
+
+	if err := res.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("failed to decode tenant: %w", err)
+	}
+
+	return &payload, nil
+}
+
+func (s *store) GetTenant(ctx context.Context, id string) (*tenantRecord, error) {
+	return s.findTenant(ctx, bson.M{"id": id}, id)
+}
+
+func (s *store) GetTenantByDomain(ctx context.Context, domain string) (*tenantRecord, error) {
+	return s.findTenant(ctx, bson.M{"domains": domain}, domain)
+}
+
+func (s *store) UpdateTenant(ctx context.Context, tenant *tenantRecord) error {
+	update := bson.M{"$set": bson.M{
+		"name":       tenant.Name,
+		"domains":    tenant.Domains,
+		"maxLinks":   tenant.MaxLinks,
+		"maxApiKeys": tenant.MaxApiKeys,
+	}}
+	// The domains index is sparse, a tenant without domains must not have the field.
+	if len(tenant.Domains) == 0 {
+		update = bson.M{
+			"$set":   bson.M{"name": tenant.Name, "maxLinks": tenant.MaxLinks, "maxApiKeys": tenant.MaxApiKeys},
+			"$unset": bson.M{"domains": ""},
+		}
+	}
+
+	res, err := s.tenants.UpdateOne(ctx, bson.M{"id": tenant.Id}, update)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return &ConflictError{}
+		}
+		return fmt.Errorf("failed to update tenant %s: %w", tenant.Id, err)
+	}
+
+	if res.MatchedCount == 0 {
+		return &TenantNotFoundError{Tenant: tenant.Id}
+	}
+
+	return nil
+}
+
+func (s *store) ListTenants(ctx context.Context) ([]*tenantRecord, error) {
+	cursor, err := s.tenants.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("error when calling Find in the store %s: %w", s.name, err)
+	}
+
+	var tenants []*tenantRecord
+	if err := cursor.All(ctx, &tenants); err != nil {
+		return nil, fmt.Errorf("failed to decode tenants: %w", err)
+	}
+
+	return tenants, nil
+}
+
+func (s *store) DeleteTenant(ctx context.Context, id string) error {
+	res, err := s.tenants.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete tenant %s: %w", id, err)
+	}
+
+	if res.DeletedCount == 0 {
+		return &TenantNotFoundError{Tenant: id}
+	}
+
+	return nil
+}
+
+// quotaLockField returns the field of the tenant record that holds the quota lock of `resource` (e.g. "api keys").
+func quotaLockField(resource string) string {
+	return "quotaLocks." + strings.ReplaceAll(resource, " ", "_")
+}
+
+func (s *store) LockTenantQuota(ctx context.Context, id string, resource string, now time.Time, until time.Time) (bool, error) {
+	field := quotaLockField(resource)
+	filter := bson.M{"id": id, "$or": bson.A{
+		bson.M{field: bson.M{"$exists": false}},
+		bson.M{field: bson.M{"$lte": now.UnixNano()}},
+	}}
+
+	res, err := s.tenants.UpdateOne(ctx, filter, bson.M{"$set": bson.M{field: until.UnixNano()}})
+	if err != nil {
+		return false, fmt.Errorf("failed to lock the %s quota of tenant %s: %w", resource, id, err)
+	}
+	if res.MatchedCount > 0 {
+		return true, nil
+	}
+
+	// The lock is held, unless the tenant does not exist.
+	if _, err := s.GetTenant(ctx, id); err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+func (s *store) UnlockTenantQuota(ctx context.Context, id string, resource string, until time.Time) error {
+	field := quotaLockField(resource)
+	if _, err := s.tenants.UpdateOne(ctx, bson.M{"id": id, field: until.UnixNano()}, bson.M{"$unset": bson.M{field: ""}}); err != nil {
+		return fmt.Errorf("failed to unlock the %s quota of tenant %s: %w", resource, id, err)
+	}
+
+	return nil
 }
@@ -2,6 +2,7 @@ package short
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"testing"
@@ -31,12 +32,14 @@ func getRandomMongoURIForTesting() string {
 	return mongoServer.URIWithRandomDB()
 }
 
+func getDatabaseNameForTesting(t *testing.T, uri string) string {
+	t.Helper()
+	cs, err := connstring.Parse(uri)
+	require.Nil(t, err)
+	return cs.Database
+}
+
 func TestStore(t *testing.T) {
-	getDatabaseName := func(t *testing.T, uri string) string {
-		cs, err := connstring.Parse(uri)
-		require.Nil(t, err)
-		return cs.Database
-	}
 
 	t.Run("NewStore", func(t *testing.T) {
 		uri := getRandomMongoURIForTesting()
@@ -48,10 +51,11 @@ func TestStore(t *testing.T) {
 		require.Nil(t, err)
 
 		client := mongoDbClientMap[uri]
-		databaseName := getDatabaseName(t, uri)
+		databaseName := getDatabaseNameForTesting(t, uri)
 		database := client.Database(databaseName)
 		collections, _ := database.ListCollectionNames(context.Background(), bson.D{{}})
-		require.Len(t, collections, 3)
+		// collections map + (links + rollups + api keys + tenants) per name.
+		require.Len(t, collections, 9)
 
 		collectionsMapCollection := database.Collection(collectionsMapName)
 		documentsCount, _ := collectionsMapCollection.CountDocuments(context.Background(), bson.D{{}})
@@ -139,4 +143,158 @@ func TestStore(t *testing.T) {
 			validateDocumentHelper(t, s, "id", "https://test222.com")
 		})
 	})
+
+	t.Run("States", func(t *testing.T) {
+		ctx := context.Background()
+		s, err := newStore(getRandomMongoURIForTesting(), "col1")
+		require.Nil(t, err)
+
+		past := time.Now().Add(-time.Hour)
+		require.Nil(t, s.Insert(ctx, &insertConfig{url: "https://test.com", id: "active"}))
+		require.Nil(t, s.Insert(ctx, &insertConfig{url: "https://test.com", id: "expired", expiration: &past}))
+		require.Nil(t, s.Insert(ctx, &insertConfig{url: "https://test.com", id: "disabled", maxClicks: 1}))
+		require.Nil(t, s.DecrementRemainingClicks(ctx, "disabled"))
+		require.Nil(t, s.Insert(ctx, &insertConfig{url: "https://test.com", id: "deleted"}))
+		require.Nil(t, s.Delete(ctx, "deleted"))
+
+		for id, expected := range map[string]LinkState{
+			"active":   LinkActive,
+			"expired":  LinkExpired,
+			"disabled": LinkDisabled,
+			"deleted":  LinkTombstoned,
+			"missing":  LinkMissing,
+		} {
+			_, state, err := s.GetUrlState(ctx, id)
+			require.Nil(t, err)
+			require.Equal(t, expected, state, id)
+		}
+
+		var eerr *ExpiredError
+		_, err = s.GetUrl(ctx, "expired")
+		require.ErrorAs(t, err, &eerr)
+		require.Equal(t, past.Unix(), eerr.ExpirationDate.Unix())
+
+		var gerr *GoneError
+		_, err = s.GetUrl(ctx, "deleted")
+		require.ErrorAs(t, err, &gerr)
+		require.Equal(t, LinkTombstoned, gerr.State)
+		require.ErrorAs(t, s.DecrementRemainingClicks(ctx, "disabled"), &gerr)
+		require.Equal(t, LinkDisabled, gerr.State)
+
+		var nerr *IdNotFoundError
+		_, err = s.GetUrl(ctx, "missing")
+		require.ErrorAs(t, err, &nerr)
+		require.ErrorAs(t, s.Update(ctx, "deleted", "https://test.com", false), &nerr)
+		require.ErrorAs(t, s.Delete(ctx, "deleted"), &nerr)
+
+		// Tombstones are not listed or counted.
+		records, err := s.List(ctx, &listQuery{limit: 10})
+		require.Nil(t, err)
+		require.Len(t, records, 3)
+		count, err := s.CountLinks(ctx)
+		require.Nil(t, err)
+		require.Equal(t, int64(3), count)
+	})
+
+	t.Run("Clicks", func(t *testing.T) {
+		s, err := newStore(getRandomMongoURIForTesting(), "col1")
+		require.Nil(t, err)
+
+		t.Run("not found", func(t *testing.T) {
+			err := s.IncrementClicks(context.Background(), "id", false)
+			var perr *IdNotFoundError
+			require.ErrorAs(t, err, &perr)
+
+			_, err = s.GetStats(context.Background(), "id")
+			require.ErrorAs(t, err, &perr)
+		})
+
+		t.Run("increment", func(t *testing.T) {
+			err := s.Insert(context.Background(), &insertConfig{
+				url: "https://test.com",
+				id:  "id",
+			})
+			require.Nil(t, err)
+
+			stats, err := s.GetStats(context.Background(), "id")
+			require.Nil(t, err)
+			require.Equal(t, int64(0), stats.Clicks)
+			require.Nil(t, stats.LastAccessed)
+
+			for i := 0; i < 3; i++ {
+				require.Nil(t, s.IncrementClicks(context.Background(), "id", false))
+			}
+
+			require.Nil(t, s.IncrementClicks(context.Background(), "id", true))
+
+			stats, err = s.GetStats(context.Background(), "id")
+			require.Nil(t, err)
+			require.Equal(t, int64(3), stats.Clicks)
+			require.Equal(t, int64(1), stats.BotClicks)
+			require.NotNil(t, stats.LastAccessed)
+		})
+	})
+
+	t.Run("Rollups", func(t *testing.T) {
+		s, err := newStore(getRandomMongoURIForTesting(), "col1")
+		require.Nil(t, err)
+
+		day := time.Date(2022, 10, 5, 0, 0, 0, 0, time.UTC)
+
+		err = s.RecordClicks(context.Background(), []ClickEvent{
+			{Id: "id", Time: day.Add(time.Hour), Referrer: "https://a.com", UserAgent: "ua1"},
+			{Id: "id", Time: day.Add(time.Hour + time.Minute), Referrer: "https://b.com", UserAgent: "ua1"},
+			{Id: "id", Time: day.Add(3 * time.Hour), Referrer: "https://a.com", UserAgent: "ua2"},
+			{Id: "other", Time: day.Add(time.Hour)},
+		})
+		require.Nil(t, err)
+		err = s.RecordClicks(context.Background(), []ClickEvent{
+			{Id: "id", Time: day.Add(25 * time.Hour), Referrer: "https://a.com"},
+		})
+		require.Nil(t, err)
+
+		t.Run("unique visitors", func(t *testing.T) {
+			err := s.Insert(context.Background(), &insertConfig{url: "https://test.com", id: "visitors"})
+			require.Nil(t, err)
+
+			var events []ClickEvent
+			for i := 0; i < 10; i++ {
+				// Each visitor clicks twice.
+				hash := visitorHash("", fmt.Sprintf("1.2.3.%d", i), "ua")
+				events = append(events,
+					ClickEvent{Id: "visitors", Time: day, visitorHash: hash},
+					ClickEvent{Id: "visitors", Time: day.AddDate(0, 0, 1), visitorHash: hash})
+			}
+			require.Nil(t, s.RecordClicks(context.Background(), events))
+
+			ts, err := s.GetTimeSeries(context.Background(), "visitors", day, day.AddDate(0, 0, 2), GranularityDay)
+			require.Nil(t, err)
+			require.Equal(t, uint64(10), ts.UniqueVisitors)
+			require.Equal(t, uint64(10), ts.Buckets[0].UniqueVisitors)
+			require.Equal(t, uint64(10), ts.Buckets[1].UniqueVisitors)
+
+			stats, err := s.GetStats(context.Background(), "visitors")
+			require.Nil(t, err)
+			require.Equal(t, uint64(10), stats.UniqueVisitors)
+		})
+
+		t.Run("hourly", func(t *testing.T) {
+			ts, err := s.GetTimeSeries(context.Background(), "id", day, day.Add(4*time.Hour), GranularityHour)
+			require.Nil(t, err)
+			require.Len(t, ts.Buckets, 4)
+			require.Equal(t, []int64{0, 2, 0, 1}, []int64{ts.Buckets[0].Clicks, ts.Buckets[1].Clicks, ts.Buckets[2].Clicks, ts.Buckets[3].Clicks})
+			require.True(t, day.Add(time.Hour).Equal(ts.Buckets[1].Start))
+			require.Equal(t, []Count{{Value: "https://a.com", Count: 2}, {Value: "https://b.com", Count: 1}}, ts.TopReferrers)
+			require.Equal(t, []Count{{Value: "ua1", Count: 2}, {Value: "ua2", Count: 1}}, ts.TopUserAgents)
+		})
+
+		t.Run("daily", func(t *testing.T) {
+			ts, err := s.GetTimeSeries(context.Background(), "id", day, day.AddDate(0, 0, 2), GranularityDay)
+			require.Nil(t, err)
+			require.Len(t, ts.Buckets, 2)
+			require.Equal(t, int64(3), ts.Buckets[0].Clicks)
+			require.Equal(t, int64(1), ts.Buckets[1].Clicks)
+			require.Equal(t, []Count{{Value: "https://a.com", Count: 3}, {Value: "https://b.com", Count: 1}}, ts.TopReferrers)
+		})
+	})
 }
@@ -0,0 +1,478 @@
+package short
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tenantCacheTTL is the time a tenant (or a domain without a tenant) is cached.
+// Tenants are read on every resolve, changes made by other instances are seen after at most this time.
+const tenantCacheTTL = 30 * time.Second
+
+// Tenant is a workspace with its own shortened urls, api keys, domains and quota.
+// The same alias may be used by different tenants.
+// To run an operation on the shortened urls of a tenant use `ContextWithTenant`.
+type Tenant struct {
+	// Id is alphanumeric.
+	Id   string
+	Name string
+	// Domains are the hosts of the shortened urls of the tenant (e.g. `go.acme.com`).
+	// The first domain is used to generate shortened urls, all the domains are resolved by `Handler`.
+	// A domain belongs to a single tenant.
+	Domains []string
+	Quota   Quota
+	// CreatedAt is set by `CreateTenant`.
+	CreatedAt time.Time
+}
+
+// Quota limits the resources of a tenant (0 is unlimited).
+type Quota struct {
+	MaxLinks   int64
+	MaxApiKeys int64
+}
+
+func (t *Tenant) hasDomain(domain string) bool {
+	for _, d := range t.Domains {
+		if d == domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+type tenantContextKey struct{}
+
+// ContextWithTenant returns a copy of `ctx` that runs the operations of the shortener on the tenant `id`.
+// Without a tenant the operations run on the default namespace of the shortener (see `Config.WithHost`).
+func ContextWithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, id)
+}
+
+// TenantFromContext returns the tenant set by `ContextWithTenant` (empty if not set).
+func TenantFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(tenantContextKey{}).(string)
+	return id
+}
+
+// namespace is the store and the host of the shortened urls of a tenant (or of the default namespace).
+type namespace struct {
+	// tenant is nil for the default namespace.
+	tenant *Tenant
+	store  Store
+	host   string
+	// domain is recorded with the shortened urls of a store that is shared by multiple domains (empty otherwise).
+	domain string
+}
+
+func (ns *namespace) tenantId() string {
+	if ns.tenant == nil {
+		return ""
+	}
+	return ns.tenant.Id
+}
+
+// quota returns the quota of the tenant (the default namespace is unlimited).
+func (ns *namespace) quota() Quota {
+	if ns.tenant == nil {
+		return Quota{}
+	}
+	return ns.tenant.Quota
+}
+
+type cachedTenant struct {
+	// tenant is nil if the tenant (or the tenant of a domain) does not exist.
+	tenant  *Tenant
+	expires time.Time
+}
+
+// tenantCache caches tenants by id and by domain.
+type tenantCache struct {
+	lock     sync.Mutex
+	now      func() time.Time
+	byId     map[string]cachedTenant
+	byDomain map[string]cachedTenant
+}
+
+func newTenantCache() *tenantCache {
+	return &tenantCache{
+		now:      time.Now,
+		byId:     map[string]cachedTenant{},
+		byDomain: map[string]cachedTenant{},
+	}
+}
+
+func (c *tenantCache) get(m map[string]cachedTenant, key string) (*Tenant, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ct, ok := m[key]
+	if !ok || c.now().After(ct.expires) {
+		return nil, false
+	}
+
+	return ct.tenant, true
+}
+
+func (c *tenantCache) set(m map[string]cachedTenant, key string, tenant *Tenant) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	m[key] = cachedTenant{tenant: tenant, expires: c.now().Add(tenantCacheTTL)}
+}
+
+func (c *tenantCache) invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.byId = map[string]cachedTenant{}
+	c.byDomain = map[string]cachedTenant{}
+}
+
+func newTenant(rec *tenantRecord) *Tenant {
+	return &Tenant{
+		Id:        rec.Id,
+		Name:      rec.Name,
+		Domains:   rec.Domains,
+		Quota:     Quota{MaxLinks: rec.MaxLinks, MaxApiKeys: rec.MaxApiKeys},
+		CreatedAt: time.Unix(rec.CreatedAt, 0),
+	}
+}
+
+func newTenantRecord(tenant *Tenant) *tenantRecord {
+	return &tenantRecord{
+		Id:         tenant.Id,
+		Name:       tenant.Name,
+		Domains:    tenant.Domains,
+		MaxLinks:   tenant.Quota.MaxLinks,
+		MaxApiKeys: tenant.Quota.MaxApiKeys,
+		CreatedAt:  tenant.CreatedAt.Unix(),
+	}
+}
+
+// normalizeDomain lower cases a domain and validates that it is a host (with an optional port).
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+
+	host := domainHost(domain)
+
+	if host == "" || strings.ContainsAny(host, "/?#@ ") {
+		return "", newValidationError("invalid domain %s", domain)
+	}
+
+	return domain, nil
+}
+
+// validateTenant validates and normalizes a tenant.
+func validateTenant(tenant *Tenant) error {
+	if len(tenant.Id) == 0 || !isAlphaNumeric(tenant.Id) {
+		return newValidationError("invalid tenant id %s", tenant.Id)
+	}
+
+	if tenant.Quota.MaxLinks < 0 || tenant.Quota.MaxApiKeys < 0 {
+		return newValidationError("the quota of tenant %s must not be negative", tenant.Id)
+	}
+
+	domains := make([]string, 0, len(tenant.Domains))
+	seen := map[string]bool{}
+	for _, domain := range tenant.Domains {
+		normalized, err := normalizeDomain(domain)
+		if err != nil {
+			return err
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			domains = append(domains, normalized)
+		}
+	}
+	tenant.Domains = domains
+
+	return nil
+}
+
+// checkTenantDomains rejects the domains of a tenant that are domains of the shortener
+// (its host, the domains of `Config.WithDomains` and the alias domains), with or without a port.
+func (s *shortner) checkTenantDomains(tenant *Tenant) error {
+	reserved := map[string]bool{domainHost(s.host): true}
+	for _, domain := range s.domains {
+		reserved[domainHost(domain)] = true
+	}
+	for domain := range s.aliasDomains {
+		reserved[domainHost(domain)] = true
+	}
+
+	for _, domain := range tenant.Domains {
+		if reserved[domainHost(domain)] {
+			return newValidationError("the domain %s of tenant %s is a domain of the shortener", domain, tenant.Id)
+		}
+	}
+
+	return nil
+}
+
+// domainHost returns the host of a domain without its port.
+func domainHost(domain string) string {
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		return h
+	}
+	return domain
+}
+
+// tenantStore returns the store of the tenant `id` (the store is created on first use).
+func (s *shortner) tenantStore(id string) (Store, error) {
+	if id == "" {
+		return s.store, nil
+	}
+
+	s.tenantStoresLock.Lock()
+	defer s.tenantStoresLock.Unlock()
+
+	if store, ok := s.tenantStores[id]; ok {
+		return store, nil
+	}
+
+	// The collections of a tenant are mapped like the collections of a host.
+	store, err := newStore(s.mongoUri, fmt.Sprintf("%s#%s", s.host, id))
+	if err != nil {
+		return nil, err
+	}
+	s.tenantStores[id] = store
+
+	return store, nil
+}
+
+// namespace returns the namespace of the tenant of `ctx` (see `ContextWithTenant`).
+func (s *shortner) namespace(ctx context.Context) (*namespace, error) {
+	id := TenantFromContext(ctx)
+	domain := DomainFromContext(ctx)
+	if id == "" {
+		return s.domainNamespace(domain)
+	}
+
+	tenant, err := s.GetTenant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := s.tenantStore(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ns := namespace{tenant: tenant, store: store, host: s.host}
+	if len(tenant.Domains) > 0 {
+		ns.host = tenant.Domains[0]
+	}
+
+	if domain != "" {
+		if !tenant.hasDomain(domain) {
+			return nil, newValidationError("the domain %s is not a domain of tenant %s", domain, id)
+		}
+		ns.host = domain
+	}
+
+	return &ns, nil
+}
+
+const (
+	// quotaLockLease is the longest time a quota lock is held, so a crashed instance does not block the tenant.
+	quotaLockLease = 10 * time.Second
+	// quotaLockRetryInterval is the time between the attempts to take a quota lock that is held.
+	quotaLockRetryInterval = 10 * time.Millisecond
+)
+
+// withQuota runs `create` unless the namespace already holds `limit` resources (0 is unlimited).
+// The quota check and `create` hold the quota lock of the tenant (see `Store.LockTenantQuota`),
+// so concurrent creations (on any instance) cannot go over the quota.
+func (s *shortner) withQuota(ctx context.Context, ns *namespace, resource string, limit int64, count func(ctx context.Context) (int64, error), create func() error) error {
+	if limit == 0 {
+		return create()
+	}
+
+	until, err := s.lockQuota(ctx, ns.tenant.Id, resource)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// The lock is released even if ctx is done, otherwise it is held until the lease ends.
+		unlockCtx, cancel := context.WithTimeout(context.Background(), quotaLockLease)
+		defer cancel()
+		_ = s.store.UnlockTenantQuota(unlockCtx, ns.tenant.Id, resource, until)
+	}()
+
+	if err := ns.checkQuota(ctx, resource, limit, count); err != nil {
+		return err
+	}
+
+	return create()
+}
+
+// lockQuota waits for the quota lock of `resource` of the tenant `id` and returns the end of its lease.
+func (s *shortner) lockQuota(ctx context.Context, id string, resource string) (time.Time, error) {
+	for {
+		now := time.Now()
+		until := now.Add(quotaLockLease)
+		locked, err := s.store.LockTenantQuota(ctx, id, resource, now, until)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if locked {
+			return until, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		case <-time.After(quotaLockRetryInterval):
+		}
+	}
+}
+
+// checkQuota returns a `QuotaExceededError` if the namespace already holds `limit` resources.
+func (ns *namespace) checkQuota(ctx context.Context, resource string, limit int64, count func(ctx context.Context) (int64, error)) error {
+	if limit == 0 {
+		return nil
+	}
+
+	n, err := count(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n >= limit {
+		return &QuotaExceededError{Tenant: ns.tenant.Id, Resource: resource, Limit: limit}
+	}
+
+	return nil
+}
+
+func (s *shortner) CreateTenant(ctx context.Context, tenant Tenant) (*Tenant, error) {
+	if err := validateTenant(&tenant); err != nil {
+		return nil, err
+	}
+	if err := s.checkTenantDomains(&tenant); err != nil {
+		return nil, err
+	}
+
+	tenant.CreatedAt = time.Unix(time.Now().Unix(), 0)
+
+	if err := s.store.InsertTenant(ctx, newTenantRecord(&tenant)); err != nil {
+		return nil, err
+	}
+
+	s.tenants.invalidate()
+
+	return &tenant, nil
+}
+
+func (s *shortner) GetTenant(ctx context.Context, id string) (*Tenant, error) {
+	if tenant, ok := s.tenants.get(s.tenants.byId, id); ok && tenant != nil {
+		return tenant, nil
+	}
+
+	if len(id) == 0 || !isAlphaNumeric(id) {
+		return nil, newValidationError("invalid tenant id %s", id)
+	}
+
+	rec, err := s.store.GetTenant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant := newTenant(rec)
+	s.tenants.set(s.tenants.byId, id, tenant)
+
+	return tenant, nil
+}
+
+func (s *shortner) GetTenantByDomain(ctx context.Context, domain string) (*Tenant, error) {
+	domain = strings.ToLower(domain)
+
+	if tenant, ok := s.tenants.get(s.tenants.byDomain, domain); ok {
+		if tenant == nil {
+			return nil, &TenantNotFoundError{Tenant: domain}
+		}
+		return tenant, nil
+	}
+
+	rec, err := s.store.GetTenantByDomain(ctx, domain)
+	if err != nil {
+		if _, ok := err.(*TenantNotFoundError); ok {
+			// Most requests are for domains without a tenant (e.g. the host of the shortener).
+			s.tenants.set(s.tenants.byDomain, domain, nil)
+		}
+		return nil, err
+	}
+
+	tenant := newTenant(rec)
+	s.tenants.set(s.tenants.byDomain, domain, tenant)
+
+	return tenant, nil
+}
+
+func (s *shortner) UpdateTenant(ctx context.Context, tenant Tenant) (*Tenant, error) {
+	if err := validateTenant(&tenant); err != nil {
+		return nil, err
+	}
+	if err := s.checkTenantDomains(&tenant); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.UpdateTenant(ctx, newTenantRecord(&tenant)); err != nil {
+		return nil, err
+	}
+
+	s.tenants.invalidate()
+
+	rec, err := s.store.GetTenant(ctx, tenant.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTenant(rec), nil
+}
+
+func (s *shortner) ListTenants(ctx context.Context) ([]*Tenant, error) {
+	recs, err := s.store.ListTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]*Tenant, len(recs))
+	for i, rec := range recs {
+		tenants[i] = newTenant(rec)
+	}
+
+	return tenants, nil
+}
+
+func (s *shortner) DeleteTenant(ctx context.Context, id string) error {
+	if _, err := s.GetTenant(ctx, id); err != nil {
+		return err
+	}
+
+	store, err := s.tenantStore(id)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Drop(ctx); err != nil {
+		return err
+	}
+
+	s.tenantStoresLock.Lock()
+	delete(s.tenantStores, id)
+	s.tenantStoresLock.Unlock()
+
+	if err := s.store.DeleteTenant(ctx, id); err != nil {
+		return err
+	}
+
+	s.tenants.invalidate()
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package short
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenant(t *testing.T) {
+	t.Run("context", func(t *testing.T) {
+		require.Equal(t, "", TenantFromContext(context.Background()))
+		require.Equal(t, "acme", TenantFromContext(ContextWithTenant(context.Background(), "acme")))
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		tenant := Tenant{Id: "acme", Domains: []string{"Go.Acme.com", "go.acme.com", "localhost:8080"}}
+		require.Nil(t, validateTenant(&tenant))
+		require.Equal(t, []string{"go.acme.com", "localhost:8080"}, tenant.Domains)
+
+		var verr *ValidationError
+		for _, invalid := range []Tenant{
+			{Id: ""},
+			{Id: "ac-me"},
+			{Id: "acme", Domains: []string{""}},
+			{Id: "acme", Domains: []string{"go.acme.com/path"}},
+			{Id: "acme", Domains: []string{"user@go.acme.com"}},
+			{Id: "acme", Quota: Quota{MaxLinks: -1}},
+		} {
+			invalid := invalid
+			require.ErrorAs(t, validateTenant(&invalid), &verr, invalid)
+		}
+	})
+
+	t.Run("shortener domains", func(t *testing.T) {
+		s := &shortner{host: "short.com", domains: []string{"go.short.com"}, aliasDomains: map[string]bool{"sho.rt": true}}
+		require.Nil(t, s.checkTenantDomains(&Tenant{Id: "acme", Domains: []string{"go.acme.com", "short.com.evil.com"}}))
+
+		var verr *ValidationError
+		for _, domain := range []string{"short.com", "short.com:8080", "go.short.com", "sho.rt"} {
+			require.ErrorAs(t, s.checkTenantDomains(&Tenant{Id: "acme", Domains: []string{domain}}), &verr, domain)
+		}
+	})
+
+	t.Run("quota", func(t *testing.T) {
+		s := &shortner{store: &quotaLockStore{locks: map[string]time.Time{}}}
+		ns := &namespace{tenant: &Tenant{Id: "acme"}}
+
+		var n int64
+		count := func(ctx context.Context) (int64, error) { return atomic.LoadInt64(&n), nil }
+		create := func() error {
+			// A slow insert lets the other creations check the quota before it is done.
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&n, 1)
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		var exceeded int32
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, ok := s.withQuota(context.Background(), ns, "links", 3, count, create).(*QuotaExceededError); ok {
+					atomic.AddInt32(&exceeded, 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		require.Equal(t, int64(3), n)
+		require.Equal(t, int32(7), exceeded)
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		now := time.Now()
+		c := newTenantCache()
+		c.now = func() time.Time { return now }
+
+		_, ok := c.get(c.byId, "acme")
+		require.False(t, ok)
+
+		tenant := &Tenant{Id: "acme"}
+		c.set(c.byId, "acme", tenant)
+		c.set(c.byDomain, "short.com", nil)
+
+		res, ok := c.get(c.byId, "acme")
+		require.True(t, ok)
+		require.Equal(t, tenant, res)
+
+		res, ok = c.get(c.byDomain, "short.com")
+		require.True(t, ok)
+		require.Nil(t, res)
+
+		now = now.Add(tenantCacheTTL + time.Second)
+		_, ok = c.get(c.byId, "acme")
+		require.False(t, ok)
+
+		c.set(c.byId, "acme", tenant)
+		c.invalidate()
+		_, ok = c.get(c.byId, "acme")
+		require.False(t, ok)
+	})
+}
+
+// quotaLockStore is a store that only implements the quota locks.
+type quotaLockStore struct {
+	Store
+	lock  sync.Mutex
+	locks map[string]time.Time
+}
+
+func (s *quotaLockStore) LockTenantQuota(ctx context.Context, id string, resource string, now time.Time, until time.Time) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := id + "/" + resource
+	if lease, ok := s.locks[key]; ok && lease.After(now) {
+		return false, nil
+	}
+	s.locks[key] = until
+
+	return true, nil
+}
+
+func (s *quotaLockStore) UnlockTenantQuota(ctx context.Context, id string, resource string, until time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := id + "/" + resource
+	if s.locks[key].Equal(until) {
+		delete(s.locks, key)
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package short
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ThreatList is a local hash-prefix list in the Google Safe Browsing v4 update format.
+// The list is updated with `fetch` responses of the `threatListUpdates` API (see `ApplyUpdate`),
+// a url is a threat if the hash of one of its host suffix / path prefix expressions starts with a prefix of the list.
+// The full hashes are not verified with the API, so a prefix match is treated as a threat.
+type ThreatList struct {
+	lock  sync.RWMutex
+	lists map[string]*hashPrefixList
+}
+
+// hashPrefixList is a single list (threat type, platform type and threat entry type) of a ThreatList.
+type hashPrefixList struct {
+	// prefixes are sorted, removals are indices into this order.
+	prefixes []string
+	// sizes are the prefix sizes in the list.
+	sizes       map[int]bool
+	set         map[string]bool
+	clientState string
+}
+
+type threatListUpdate struct {
+	ListUpdateResponses []struct {
+		ThreatType      string `json:"threatType"`
+		ThreatEntryType string `json:"threatEntryType"`
+		PlatformType    string `json:"platformType"`
+		ResponseType    string `json:"responseType"`
+		Additions       []struct {
+			CompressionType string `json:"compressionType"`
+			RawHashes       *struct {
+				PrefixSize int    `json:"prefixSize"`
+				RawHashes  []byte `json:"rawHashes"`
+			} `json:"rawHashes"`
+		} `json:"additions"`
+		Removals []struct {
+			CompressionType string `json:"compressionType"`
+			RawIndices      *struct {
+				Indices []int `json:"indices"`
+			} `json:"rawIndices"`
+		} `json:"removals"`
+		NewClientState string `json:"newClientState"`
+		Checksum       struct {
+			Sha256 []byte `json:"sha256"`
+		} `json:"checksum"`
+	} `json:"listUpdateResponses"`
+}
+
+// NewThreatList returns an empty threat list.
+func NewThreatList() *ThreatList {
+	return &ThreatList{lists: map[string]*hashPrefixList{}}
+}
+
+// LoadThreatList returns a threat list with the update stored in the JSON file `path`.
+func LoadThreatList(path string) (*ThreatList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	l := NewThreatList()
+	if err := l.ApplyUpdate(f); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// ApplyUpdate applies a JSON `threatListUpdates:fetch` response (full and partial updates with RAW compression).
+// The checksum of each list is verified, the list is left unchanged if the update fails.
+func (l *ThreatList) ApplyUpdate(r io.Reader) error {
+	var update threatListUpdate
+	if err := json.NewDecoder(r).Decode(&update); err != nil {
+		return fmt.Errorf("failed to decode the threat list update: %w", err)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	updated := map[string]*hashPrefixList{}
+
+	for _, res := range update.ListUpdateResponses {
+		key := res.ThreatType + "/" + res.PlatformType + "/" + res.ThreatEntryType
+
+		var prefixes []string
+		if current, ok := updated[key]; ok {
+			prefixes = current.prefixes
+		} else if current, ok := l.lists[key]; ok && res.ResponseType != "FULL_UPDATE" {
+			prefixes = current.prefixes
+		}
+		if res.ResponseType == "FULL_UPDATE" {
+			prefixes = nil
+		}
+
+		removed := map[int]bool{}
+		for _, removal := range res.Removals {
+			if removal.CompressionType != "" && removal.CompressionType != "RAW" {
+				return fmt.Errorf("unsupported compression type %s of list %s", removal.CompressionType, key)
+			}
+			if removal.RawIndices == nil {
+				continue
+			}
+			for _, i := range removal.RawIndices.Indices {
+				if i < 0 || i >= len(prefixes) {
+					return fmt.Errorf("invalid removal index %d of list %s", i, key)
+				}
+				removed[i] = true
+			}
+		}
+
+		next := make([]string, 0, len(prefixes))
+		for i, prefix := range prefixes {
+			if !removed[i] {
+				next = append(next, prefix)
+			}
+		}
+
+		for _, addition := range res.Additions {
+			if addition.CompressionType != "" && addition.CompressionType != "RAW" {
+				return fmt.Errorf("unsupported compression type %s of list %s", addition.CompressionType, key)
+			}
+			if addition.RawHashes == nil {
+				continue
+			}
+			size := addition.RawHashes.PrefixSize
+			raw := addition.RawHashes.RawHashes
+			if size < 4 || size > sha256.Size || len(raw)%size != 0 {
+				return fmt.Errorf("invalid hash prefixes of list %s", key)
+			}
+			for i := 0; i < len(raw); i += size {
+				next = append(next, string(raw[i:i+size]))
+			}
+		}
+
+		sort.Strings(next)
+
+		if len(res.Checksum.Sha256) > 0 {
+			sum := sha256.Sum256([]byte(strings.Join(next, "")))
+			if !bytes.Equal(sum[:], res.Checksum.Sha256) {
+				return fmt.Errorf("the checksum of list %s does not match", key)
+			}
+		}
+
+		list := hashPrefixList{prefixes: next, sizes: map[int]bool{}, set: map[string]bool{}, clientState: res.NewClientState}
+		for _, prefix := range next {
+			list.sizes[len(prefix)] = true
+			list.set[prefix] = true
+		}
+		updated[key] = &list
+	}
+
+	for key, list := range updated {
+		l.lists[key] = list
+	}
+
+	return nil
+}
+
+// ClientStates returns the client state of each list (`threatType/platformType/threatEntryType`) for the next update request.
+func (l *ThreatList) ClientStates() map[string]string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	states := make(map[string]string, len(l.lists))
+	for key, list := range l.lists {
+		states[key] = list.clientState
+	}
+
+	return states
+}
+
+// Contains returns true if a hash prefix of the list matches the url.
+func (l *ThreatList) Contains(u string) bool {
+	expressions := threatExpressions(u)
+
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	for _, expression := range expressions {
+		hash := sha256.Sum256([]byte(expression))
+		for _, list := range l.lists {
+			for size := range list.sizes {
+				if list.set[string(hash[:size])] {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// threatExpressions returns the host suffix / path prefix expressions of a url (see the Safe Browsing url hashing rules).
+// The canonicalization is simplified: the host is lower cased, the path is cleaned and the fragment is dropped.
+func threatExpressions(u string) []string {
+	pu, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return nil
+	}
+
+	host := strings.Trim(strings.ToLower(pu.Hostname()), ".")
+	if host == "" {
+		return nil
+	}
+
+	p := pu.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	trailingSlash := strings.HasSuffix(p, "/")
+	p = path.Clean(p)
+	if trailingSlash && p != "/" {
+		p += "/"
+	}
+
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		components := strings.Split(host, ".")
+		if len(components) > 5 {
+			components = components[len(components)-5:]
+		}
+		for i := 0; i < len(components)-1; i++ {
+			suffix := strings.Join(components[i:], ".")
+			if suffix != host {
+				hosts = append(hosts, suffix)
+			}
+		}
+	}
+
+	paths := []string{}
+	if pu.RawQuery != "" {
+		paths = append(paths, p+"?"+pu.RawQuery)
+	}
+	paths = append(paths, p)
+	// Up to 4 directory prefixes, starting with the root.
+	prefix := "/"
+	paths = append(paths, prefix)
+	var directories []string
+	if trimmed := strings.Trim(p, "/"); trimmed != "" {
+		directories = strings.Split(trimmed, "/")
+		if !strings.HasSuffix(p, "/") {
+			directories = directories[:len(directories)-1]
+		}
+	}
+	for i := 0; i < len(directories) && i < 3; i++ {
+		prefix += directories[i] + "/"
+		paths = append(paths, prefix)
+	}
+
+	seen := map[string]bool{}
+	var expressions []string
+	for _, h := range hosts {
+		for _, pp := range paths {
+			expression := h + pp
+			if !seen[expression] {
+				seen[expression] = true
+				expressions = append(expressions, expression)
+			}
+		}
+	}
+
+	return expressions
+}
@@ -0,0 +1,138 @@
+package short
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// threatListUpdateJSON returns a threat list update that adds the 4 byte hash prefixes of `expressions`.
+// The checksum is computed from `current`, the prefixes of the list after the update.
+func threatListUpdateJSON(t *testing.T, responseType string, removals []int, expressions ...string) string {
+	var raw []byte
+	for _, expression := range expressions {
+		hash := sha256.Sum256([]byte(expression))
+		raw = append(raw, hash[:4]...)
+	}
+
+	res := map[string]interface{}{
+		"threatType":      "MALWARE",
+		"platformType":    "ANY_PLATFORM",
+		"threatEntryType": "URL",
+		"responseType":    responseType,
+		"additions":       []interface{}{map[string]interface{}{"compressionType": "RAW", "rawHashes": map[string]interface{}{"prefixSize": 4, "rawHashes": raw}}},
+		"newClientState":  responseType + "-state",
+	}
+	if len(removals) > 0 {
+		res["removals"] = []interface{}{map[string]interface{}{"compressionType": "RAW", "rawIndices": map[string]interface{}{"indices": removals}}}
+	}
+
+	b, err := json.Marshal(map[string]interface{}{"listUpdateResponses": []interface{}{res}})
+	require.Nil(t, err)
+
+	return string(b)
+}
+
+// threatListChecksum returns the checksum of a list with the hash prefixes of `expressions`.
+func threatListChecksum(expressions ...string) []byte {
+	var prefixes []string
+	for _, expression := range expressions {
+		hash := sha256.Sum256([]byte(expression))
+		prefixes = append(prefixes, string(hash[:4]))
+	}
+	sort.Strings(prefixes)
+	sum := sha256.Sum256([]byte(strings.Join(prefixes, "")))
+
+	return sum[:]
+}
+
+func withChecksum(t *testing.T, update string, checksum []byte) string {
+	var m map[string][]map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(update), &m))
+	m["listUpdateResponses"][0]["checksum"] = map[string]interface{}{"sha256": checksum}
+
+	b, err := json.Marshal(m)
+	require.Nil(t, err)
+
+	return string(b)
+}
+
+func TestThreatList(t *testing.T) {
+	t.Run("full and partial updates", func(t *testing.T) {
+		list := NewThreatList()
+		update := threatListUpdateJSON(t, "FULL_UPDATE", nil, "evil.com/", "bad.org/malware/")
+		require.Nil(t, list.ApplyUpdate(strings.NewReader(withChecksum(t, update, threatListChecksum("evil.com/", "bad.org/malware/")))))
+		require.Equal(t, map[string]string{"MALWARE/ANY_PLATFORM/URL": "FULL_UPDATE-state"}, list.ClientStates())
+
+		require.True(t, list.Contains("https://evil.com"))
+		require.True(t, list.Contains("http://a.b.EVIL.com/path/page.html?q=1#top"))
+		require.True(t, list.Contains("https://bad.org/malware/x.exe"))
+		require.False(t, list.Contains("https://bad.org/other/x.exe"))
+		require.False(t, list.Contains("https://test.com"))
+
+		// Remove the first prefix (in sorted order) and add another one.
+		var sorted []string
+		for _, expression := range []string{"evil.com/", "bad.org/malware/"} {
+			hash := sha256.Sum256([]byte(expression))
+			sorted = append(sorted, string(hash[:4]))
+		}
+		removed := "evil.com/"
+		kept := "bad.org/malware/"
+		if sorted[1] < sorted[0] {
+			removed, kept = kept, removed
+		}
+
+		update = threatListUpdateJSON(t, "PARTIAL_UPDATE", []int{0}, "phishing.net/")
+		require.Nil(t, list.ApplyUpdate(strings.NewReader(withChecksum(t, update, threatListChecksum(kept, "phishing.net/")))))
+		require.Equal(t, map[string]string{"MALWARE/ANY_PLATFORM/URL": "PARTIAL_UPDATE-state"}, list.ClientStates())
+
+		require.True(t, list.Contains("https://phishing.net/login"))
+		require.False(t, list.Contains("https://"+strings.TrimSuffix(removed, "/")))
+		require.True(t, list.Contains("https://"+kept))
+	})
+
+	t.Run("bad checksum", func(t *testing.T) {
+		list := NewThreatList()
+		update := threatListUpdateJSON(t, "FULL_UPDATE", nil, "evil.com/")
+		require.NotNil(t, list.ApplyUpdate(strings.NewReader(withChecksum(t, update, threatListChecksum("other.com/")))))
+		require.False(t, list.Contains("https://evil.com"))
+	})
+
+	t.Run("invalid removal", func(t *testing.T) {
+		list := NewThreatList()
+		require.NotNil(t, list.ApplyUpdate(strings.NewReader(threatListUpdateJSON(t, "PARTIAL_UPDATE", []int{3}, "evil.com/"))))
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		require.NotNil(t, NewThreatList().ApplyUpdate(strings.NewReader("{")))
+	})
+
+	t.Run("load", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "threats.json")
+		require.Nil(t, os.WriteFile(path, []byte(threatListUpdateJSON(t, "FULL_UPDATE", nil, "evil.com/")), 0600))
+
+		list, err := LoadThreatList(path)
+		require.Nil(t, err)
+		require.True(t, list.Contains("https://evil.com/a"))
+
+		_, err = LoadThreatList(filepath.Join(t.TempDir(), "missing.json"))
+		require.NotNil(t, err)
+	})
+}
+
+func TestThreatExpressions(t *testing.T) {
+	require.Equal(t, []string{
+		"a.b.c/1/2.html?param=1", "a.b.c/1/2.html", "a.b.c/", "a.b.c/1/",
+		"b.c/1/2.html?param=1", "b.c/1/2.html", "b.c/", "b.c/1/",
+	}, threatExpressions("http://a.b.c/1/2.html?param=1"))
+
+	require.Equal(t, []string{"1.2.3.4/1/", "1.2.3.4/"}, threatExpressions("http://1.2.3.4/1/"))
+	require.Equal(t, []string{"evil.com/"}, threatExpressions("https://EVIL.com"))
+	require.Nil(t, threatExpressions("not a url"))
+}
@@ -1,7 +1,7 @@
 package short
 
 import (
-	"fmt"
+	"strings"
 	"time"
 )
 
@@ -22,12 +22,58 @@ type UrlConfig interface {
 	// WithExpirationDate sets an expiration date for the shortened url.
 	// Once the expiration date has expired the url becomes invalid or allocated for other urls.
 	WithExpirationDate(expriationDate time.Time) UrlConfig
+
+	// WithRedirectType sets the http status code used to redirect to the url (301, 302, 307 or 308).
+	// Permanent redirects (301 and 308) may be cached by browsers, following clicks are not seen by the shortener.
+	// If not set the shortener default is used (see `Config.WithDefaultRedirectType`).
+	WithRedirectType(statusCode int) UrlConfig
+
+	// WithOwner sets the id of the api key that owns the shortened url (see `ApiKey`).
+	WithOwner(owner string) UrlConfig
+
+	// WithDomain sets the domain of the shortened url (see `Config.WithDomains`).
+	// If not set the domain of the context is used (see `ContextWithDomain`), or else the host of the shortener.
+	WithDomain(domain string) UrlConfig
+
+	// WithPassword protects the shortened url with a password, only a bcrypt hash of the password is stored.
+	// Resolving the shortened url fails with a `PasswordRequiredError` unless the password is set with `ContextWithPassword`.
+	WithPassword(password string) UrlConfig
+
+	// WithMaxClicks limits the shortened url to `n` successful resolves (e.g. 1 for a one-time link).
+	// Once the clicks are used up resolving the shortened url fails with a `GoneError` (`LinkDisabled`).
+	WithMaxClicks(n int64) UrlConfig
+
+	// WithActivationDate sets the time the shortened url starts to resolve (e.g. the launch of a campaign).
+	// Before it resolving the shortened url fails with a `NotYetActiveError`.
+	WithActivationDate(activationDate time.Time) UrlConfig
+
+	// WithTtl sets the expiration date of the shortened url to `ttl` after it is created.
+	// It may not be used together with `WithExpirationDate`.
+	WithTtl(ttl time.Duration) UrlConfig
+
+	// WithSlidingExpiration extends the expiration date to the ttl after each successful resolve,
+	// so the shortened url expires once it is not used for the ttl (requires `WithTtl`).
+	WithSlidingExpiration(sliding bool) UrlConfig
+
+	// WithOverrideOwnedOnly restricts `WithOverrideAlias` to shortened urls that are owned by the owner (see `WithOwner`) or not owned.
+	// Overriding a shortened url of another owner fails with a `ConflictError`.
+	WithOverrideOwnedOnly(ownedOnly bool) UrlConfig
 }
 
 type urlConfig struct {
 	alias          string
 	overrideAlias  bool
 	expirationDate *time.Time
+	redirectType   int
+	owner          string
+	domain         string
+	passwordHash   string
+	maxClicks      int64
+	activationDate *time.Time
+	ttl            time.Duration
+	sliding        bool
+	// overrideOwnedOnly restricts the override to shortened urls of the owner or without an owner.
+	overrideOwnedOnly bool
 
 	err error
 }
@@ -36,6 +82,15 @@ type urlConfig struct {
 // default alias: "" (empty string).
 // default overrideAlias: false.
 // default expirationDate: no expiration.
+// default redirectType: the shortener default.
+// default owner: none.
+// default domain: the domain of the context.
+// default password: none.
+// default maxClicks: unlimited.
+// default activationDate: active immediately.
+// default ttl: none.
+// default sliding expiration: false.
+// default overrideOwnedOnly: false.
 func DefaultUrlConfig() UrlConfig {
 	return &urlConfig{}
 }
@@ -46,7 +101,7 @@ func (u *urlConfig) getConfig() *urlConfig {
 
 func (u urlConfig) WithAlias(alias string) UrlConfig {
 	if !isAlphaNumeric(alias) {
-		u.err = fmt.Errorf("alias %s contains non-alphanumeric characters", alias)
+		u.err = newValidationError("alias %s contains non-alphanumeric characters", alias)
 	} else {
 		u.alias = alias
 	}
@@ -63,3 +118,90 @@ func (u urlConfig) WithExpirationDate(expriationDate time.Time) UrlConfig {
 	u.expirationDate = &expriationDate
 	return &u
 }
+
+func (u urlConfig) WithRedirectType(statusCode int) UrlConfig {
+	if err := validateRedirectType(statusCode); err != nil {
+		u.err = err
+	} else {
+		u.redirectType = statusCode
+	}
+
+	return &u
+}
+
+func (u urlConfig) WithOwner(owner string) UrlConfig {
+	u.owner = owner
+
+	return &u
+}
+
+func (u urlConfig) WithDomain(domain string) UrlConfig {
+	u.domain = strings.ToLower(domain)
+
+	return &u
+}
+
+func (u urlConfig) WithPassword(password string) UrlConfig {
+	hash, err := hashPassword(password)
+	if err != nil {
+		u.err = err
+	} else {
+		u.passwordHash = hash
+	}
+
+	return &u
+}
+
+func (u urlConfig) WithMaxClicks(n int64) UrlConfig {
+	if n <= 0 {
+		u.err = newValidationError("the maximum number of clicks must be positive")
+	} else {
+		u.maxClicks = n
+	}
+
+	return &u
+}
+
+func (u urlConfig) WithActivationDate(activationDate time.Time) UrlConfig {
+	u.activationDate = &activationDate
+	return &u
+}
+
+func (u urlConfig) WithTtl(ttl time.Duration) UrlConfig {
+	// Expiration dates are stored in seconds.
+	if ttl < time.Second {
+		u.err = newValidationError("the ttl must be at least one second")
+	} else {
+		u.ttl = ttl
+	}
+
+	return &u
+}
+
+func (u urlConfig) WithSlidingExpiration(sliding bool) UrlConfig {
+	u.sliding = sliding
+	return &u
+}
+
+// expiration returns the expiration date of a shortened url created at `now`.
+func (u *urlConfig) expiration(now time.Time) (*time.Time, error) {
+	if u.ttl == 0 {
+		if u.sliding {
+			return nil, newValidationError("sliding expiration requires a ttl")
+		}
+		return u.expirationDate, nil
+	}
+
+	if u.expirationDate != nil {
+		return nil, newValidationError("the ttl and the expiration date may not be used together")
+	}
+
+	expirationDate := now.Add(u.ttl)
+
+	return &expirationDate, nil
+}
+
+func (u urlConfig) WithOverrideOwnedOnly(ownedOnly bool) UrlConfig {
+	u.overrideOwnedOnly = ownedOnly
+	return &u
+}
@@ -1,7 +1,11 @@
 package short
 
 import (
+	"context"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -25,4 +29,69 @@ func TestUrlConfig(t *testing.T) {
 		require.NotNil(t, c.getConfig().err)
 		require.Equal(t, "", c.getConfig().alias)
 	})
+
+	t.Run("with redirect type", func(t *testing.T) {
+		c := DefaultUrlConfig().WithRedirectType(http.StatusTemporaryRedirect)
+		require.Nil(t, c.getConfig().err)
+		require.Equal(t, http.StatusTemporaryRedirect, c.getConfig().redirectType)
+	})
+
+	t.Run("with invalid redirect type", func(t *testing.T) {
+		c := DefaultUrlConfig().WithRedirectType(http.StatusOK)
+		require.NotNil(t, c.getConfig().err)
+		require.Equal(t, 0, c.getConfig().redirectType)
+	})
+	t.Run("with domain", func(t *testing.T) {
+		c := DefaultUrlConfig().WithDomain("EU.acme.link")
+		require.Nil(t, c.getConfig().err)
+		require.Equal(t, "eu.acme.link", c.getConfig().domain)
+	})
+
+	t.Run("with password", func(t *testing.T) {
+		c := DefaultUrlConfig().WithPassword("secret")
+		require.Nil(t, c.getConfig().err)
+		require.Nil(t, checkPassword(ContextWithPassword(context.Background(), "secret"), "id", c.getConfig().passwordHash))
+	})
+
+	t.Run("with invalid password", func(t *testing.T) {
+		require.NotNil(t, DefaultUrlConfig().WithPassword("").getConfig().err)
+		require.NotNil(t, DefaultUrlConfig().WithPassword(strings.Repeat("a", 73)).getConfig().err)
+	})
+
+	t.Run("with max clicks", func(t *testing.T) {
+		c := DefaultUrlConfig().WithMaxClicks(1)
+		require.Nil(t, c.getConfig().err)
+		require.Equal(t, int64(1), c.getConfig().maxClicks)
+
+		require.NotNil(t, DefaultUrlConfig().WithMaxClicks(0).getConfig().err)
+	})
+
+	t.Run("with activation date", func(t *testing.T) {
+		activationDate := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		c := DefaultUrlConfig().WithActivationDate(activationDate)
+		require.Nil(t, c.getConfig().err)
+		require.Equal(t, activationDate, *c.getConfig().activationDate)
+	})
+
+	t.Run("with ttl", func(t *testing.T) {
+		now := time.Now()
+
+		c := DefaultUrlConfig().WithTtl(time.Hour).WithSlidingExpiration(true)
+		require.Nil(t, c.getConfig().err)
+		expiration, err := c.getConfig().expiration(now)
+		require.Nil(t, err)
+		require.Equal(t, now.Add(time.Hour), *expiration)
+
+		require.NotNil(t, DefaultUrlConfig().WithTtl(time.Millisecond).getConfig().err)
+
+		_, err = DefaultUrlConfig().WithTtl(time.Hour).WithExpirationDate(now).getConfig().expiration(now)
+		require.NotNil(t, err)
+
+		_, err = DefaultUrlConfig().WithSlidingExpiration(true).getConfig().expiration(now)
+		require.NotNil(t, err)
+
+		expiration, err = DefaultUrlConfig().WithExpirationDate(now).getConfig().expiration(now)
+		require.Nil(t, err)
+		require.Equal(t, now, *expiration)
+	})
 }
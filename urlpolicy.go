@@ -0,0 +1,216 @@
+package short
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// UrlPolicy decides whether a url may be shortened and resolved (see `Config.WithUrlPolicy`).
+// The policy is checked when a shortened url is created or updated, and again when it is resolved,
+// so urls that are blocked after they were shortened (e.g. by a threat list update) stop redirecting.
+type UrlPolicy interface {
+	// Check returns a `BlockedUrlError` if `u` is not allowed.
+	Check(ctx context.Context, u string) error
+}
+
+// UrlPolicyOptions configures the policy returned by `NewUrlPolicy`.
+type UrlPolicyOptions struct {
+	// AllowedDomains allows only the urls of these domains and their subdomains (all domains if empty).
+	AllowedDomains []string
+	// DeniedDomains blocks the urls of these domains and their subdomains.
+	DeniedDomains []string
+	// BlockPrivateAddresses blocks urls whose host is a private, shared (100.64.0.0/10), loopback, link-local or unspecified ip address (or `localhost`).
+	// Numeric ipv4 hosts are read the way browsers read them (e.g. `http://2130706433` and `http://0x7f.1` are 127.0.0.1).
+	BlockPrivateAddresses bool
+	// ResolveHosts also blocks host names that resolve to such addresses (requires `BlockPrivateAddresses`).
+	// Host names that cannot be resolved are allowed.
+	ResolveHosts bool
+	// MaxLength is the maximum length of a url (0 is unlimited).
+	MaxLength int
+	// ThreatList blocks the urls that match a local Safe Browsing hash-prefix list (see `ThreatList`).
+	ThreatList *ThreatList
+}
+
+type urlPolicy struct {
+	options  UrlPolicyOptions
+	lookupIP func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// NewUrlPolicy returns a policy that checks the domain, the address and the length of urls, and a threat list.
+func NewUrlPolicy(options UrlPolicyOptions) (UrlPolicy, error) {
+	var err error
+
+	if options.AllowedDomains, err = normalizeDomains(options.AllowedDomains); err != nil {
+		return nil, err
+	}
+	if options.DeniedDomains, err = normalizeDomains(options.DeniedDomains); err != nil {
+		return nil, err
+	}
+	if options.MaxLength < 0 {
+		return nil, newValidationError("the maximum url length must not be negative")
+	}
+
+	return &urlPolicy{options: options, lookupIP: net.DefaultResolver.LookupIPAddr}, nil
+}
+
+func normalizeDomains(domains []string) ([]string, error) {
+	res := make([]string, len(domains))
+	for i, domain := range domains {
+		normalized, err := normalizeDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = strings.TrimPrefix(normalized, ".")
+	}
+
+	return res, nil
+}
+
+// matchesDomain returns true if `host` is one of `domains` or a subdomain of one of them.
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which is not public.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// parseNumericIPv4 parses an ipv4 host in the forms accepted by inet_aton and browsers:
+// one to four parts separated by dots, each decimal, octal (`0` prefix) or hexadecimal (`0x` prefix),
+// where the last part fills the remaining bytes (e.g. `2130706433`, `0x7f.1` and `0177.0.0.1` are 127.0.0.1).
+// It returns nil if `host` is not such a host.
+func parseNumericIPv4(host string) net.IP {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+
+	var value uint64
+	for i, part := range parts {
+		n, ok := parseIPv4Part(part)
+		if !ok {
+			return nil
+		}
+
+		// The last part fills the remaining bytes, the other parts are one byte each.
+		bits := uint(8)
+		if i == len(parts)-1 {
+			bits = uint(8 * (5 - len(parts)))
+		}
+		if n >= 1<<bits {
+			return nil
+		}
+
+		value = value<<bits | n
+	}
+
+	return net.IPv4(byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
+
+// parseIPv4Part parses a decimal, octal (`0` prefix) or hexadecimal (`0x` prefix) part of a numeric ipv4 host.
+func parseIPv4Part(part string) (uint64, bool) {
+	base := 10
+	switch {
+	case strings.HasPrefix(part, "0x"):
+		part, base = strings.TrimPrefix(part, "0x"), 16
+		if part == "" {
+			return 0, true
+		}
+	case len(part) > 1 && part[0] == '0':
+		part, base = part[1:], 8
+	}
+
+	// strconv accepts signs and underscores, which are not valid in a host.
+	for _, c := range part {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return 0, false
+		}
+	}
+
+	n, err := strconv.ParseUint(part, base, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+func (p *urlPolicy) Check(ctx context.Context, u string) error {
+	if p.options.MaxLength > 0 && len(u) > p.options.MaxLength {
+		return &BlockedUrlError{Url: u, Reason: "the url is too long"}
+	}
+
+	pu, err := url.Parse(u)
+	if err != nil {
+		return newValidationError("invalid url %s: %w", u, err)
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(pu.Hostname()), ".")
+
+	if len(p.options.AllowedDomains) > 0 && !matchesDomain(host, p.options.AllowedDomains) {
+		return &BlockedUrlError{Url: u, Reason: "the domain is not allowed"}
+	}
+
+	if matchesDomain(host, p.options.DeniedDomains) {
+		return &BlockedUrlError{Url: u, Reason: "the domain is denied"}
+	}
+
+	if p.options.BlockPrivateAddresses {
+		if err := p.checkAddress(ctx, u, host); err != nil {
+			return err
+		}
+	}
+
+	if p.options.ThreatList != nil && p.options.ThreatList.Contains(u) {
+		return &BlockedUrlError{Url: u, Reason: "the url is on the threat list"}
+	}
+
+	return nil
+}
+
+func (p *urlPolicy) checkAddress(ctx context.Context, u string, host string) error {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return &BlockedUrlError{Url: u, Reason: "the destination is a private address"}
+	}
+
+	ip := parseNumericIPv4(host)
+	if ip == nil {
+		ip = net.ParseIP(host)
+	}
+	if ip != nil {
+		if isPrivateIP(ip) {
+			return &BlockedUrlError{Url: u, Reason: "the destination is a private address"}
+		}
+		return nil
+	}
+
+	if !p.options.ResolveHosts {
+		return nil
+	}
+
+	addrs, err := p.lookupIP(ctx, host)
+	if err != nil {
+		return nil
+	}
+
+	for _, addr := range addrs {
+		if isPrivateIP(addr.IP) {
+			return &BlockedUrlError{Url: u, Reason: "the destination resolves to a private address"}
+		}
+	}
+
+	return nil
+}
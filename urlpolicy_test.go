@@ -0,0 +1,103 @@
+package short
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUrlPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	requireBlocked := func(t *testing.T, p UrlPolicy, u string) {
+		var berr *BlockedUrlError
+		require.ErrorAs(t, p.Check(ctx, u), &berr, u)
+	}
+
+	t.Run("allowed domains", func(t *testing.T) {
+		p, err := NewUrlPolicy(UrlPolicyOptions{AllowedDomains: []string{"Acme.com"}})
+		require.Nil(t, err)
+		require.Nil(t, p.Check(ctx, "https://acme.com/docs"))
+		require.Nil(t, p.Check(ctx, "https://www.acme.com/docs"))
+		requireBlocked(t, p, "https://notacme.com")
+		requireBlocked(t, p, "https://test.com")
+	})
+
+	t.Run("denied domains", func(t *testing.T) {
+		p, err := NewUrlPolicy(UrlPolicyOptions{DeniedDomains: []string{"evil.com"}})
+		require.Nil(t, err)
+		require.Nil(t, p.Check(ctx, "https://test.com"))
+		requireBlocked(t, p, "https://evil.com")
+		requireBlocked(t, p, "https://www.EVIL.com./path")
+	})
+
+	t.Run("invalid domains", func(t *testing.T) {
+		var verr *ValidationError
+		_, err := NewUrlPolicy(UrlPolicyOptions{DeniedDomains: []string{"evil.com/path"}})
+		require.ErrorAs(t, err, &verr)
+	})
+
+	t.Run("private addresses", func(t *testing.T) {
+		p, err := NewUrlPolicy(UrlPolicyOptions{BlockPrivateAddresses: true})
+		require.Nil(t, err)
+		for _, u := range []string{"http://localhost:8080", "http://api.localhost", "http://127.0.0.1", "http://10.0.0.1/admin", "http://192.168.1.1", "http://169.254.169.254/latest/meta-data", "http://[::1]:8080", "http://[fe80::1]", "http://0.0.0.0"} {
+			requireBlocked(t, p, u)
+		}
+		require.Nil(t, p.Check(ctx, "http://8.8.8.8"))
+		require.Nil(t, p.Check(ctx, "https://test.com"))
+	})
+
+	t.Run("numeric and shared addresses", func(t *testing.T) {
+		p, err := NewUrlPolicy(UrlPolicyOptions{BlockPrivateAddresses: true})
+		require.Nil(t, err)
+		for _, u := range []string{"http://2130706433/", "http://0x7f.1/", "http://0x7F000001", "http://0177.0.0.1", "http://127.1", "http://10.0x10203", "http://0", "http://100.64.0.1", "http://100.127.255.254", "http://[::ffff:100.64.0.1]"} {
+			requireBlocked(t, p, u)
+		}
+		for _, u := range []string{"http://134744072", "http://0x8.0x8.0x8.0x8", "http://100.128.0.1", "http://100.63.255.255", "http://1.2.3.4.5", "http://4294967296", "http://256.0.0.1", "http://0x7g.1"} {
+			require.Nil(t, p.Check(ctx, u), u)
+		}
+	})
+
+	t.Run("resolve hosts", func(t *testing.T) {
+		p, err := NewUrlPolicy(UrlPolicyOptions{BlockPrivateAddresses: true, ResolveHosts: true})
+		require.Nil(t, err)
+		p.(*urlPolicy).lookupIP = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			switch host {
+			case "internal.test.com":
+				return []net.IPAddr{{IP: net.ParseIP("8.8.8.8")}, {IP: net.ParseIP("10.1.2.3")}}, nil
+			case "public.test.com":
+				return []net.IPAddr{{IP: net.ParseIP("8.8.8.8")}}, nil
+			default:
+				return nil, errors.New("no such host")
+			}
+		}
+		requireBlocked(t, p, "https://internal.test.com")
+		require.Nil(t, p.Check(ctx, "https://public.test.com"))
+		require.Nil(t, p.Check(ctx, "https://unknown.test.com"))
+	})
+
+	t.Run("max length", func(t *testing.T) {
+		p, err := NewUrlPolicy(UrlPolicyOptions{MaxLength: 30})
+		require.Nil(t, err)
+		require.Nil(t, p.Check(ctx, "https://test.com"))
+		requireBlocked(t, p, "https://test.com/"+strings.Repeat("a", 20))
+
+		var verr *ValidationError
+		_, err = NewUrlPolicy(UrlPolicyOptions{MaxLength: -1})
+		require.ErrorAs(t, err, &verr)
+	})
+
+	t.Run("threat list", func(t *testing.T) {
+		list := NewThreatList()
+		require.Nil(t, list.ApplyUpdate(strings.NewReader(threatListUpdateJSON(t, "FULL_UPDATE", nil, "evil.com/"))))
+
+		p, err := NewUrlPolicy(UrlPolicyOptions{ThreatList: list})
+		require.Nil(t, err)
+		requireBlocked(t, p, "https://www.evil.com/login?a=b")
+		require.Nil(t, p.Check(ctx, "https://test.com"))
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strings"
 
+	"net/http"
 	"net/url"
 
 	"github.com/jxskiss/base62"
@@ -31,9 +32,29 @@ func generateRandomId() (string, error) {
 
 func validateUrl(u string) error {
 	if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
-		return fmt.Errorf("the url must have an 'https' or an 'http' scheme")
+		return newValidationError("the url must have an 'https' or an 'http' scheme")
 	}
 
-	_, err := url.ParseRequestURI(u)
-	return err
+	if _, err := url.ParseRequestURI(u); err != nil {
+		return &ValidationError{err: err}
+	}
+
+	return nil
+}
+
+func validateId(id string) error {
+	if len(id) == 0 || !isAlphaNumeric(id) {
+		return newValidationError("invalid short url path %s", id)
+	}
+
+	return nil
+}
+
+func validateRedirectType(statusCode int) error {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	default:
+		return newValidationError("invalid redirect type %d (must be 301, 302, 307 or 308)", statusCode)
+	}
 }
@@ -47,3 +47,13 @@ func TestGeneratorRandomId(t *testing.T) {
 		require.True(t, isAlphaNumeric(id))
 	}
 }
+
+func TestValidateRedirectType(t *testing.T) {
+	for _, statusCode := range []int{301, 302, 307, 308} {
+		require.Nil(t, validateRedirectType(statusCode))
+	}
+
+	for _, statusCode := range []int{0, 200, 303, 404} {
+		require.Error(t, validateRedirectType(statusCode))
+	}
+}